@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/estensen/marketplace-pipeline/internal/auth"
+	"github.com/estensen/marketplace-pipeline/internal/config"
+	"github.com/estensen/marketplace-pipeline/internal/database"
+	"github.com/estensen/marketplace-pipeline/internal/models"
+)
+
+// runAPIKey generates an API key. With ClickHouse auth the key is stored hashed in ClickHouse; otherwise the
+// entry to add to the API key file is printed.
+func runAPIKey(args []string) {
+	flags := flag.NewFlagSet("apikey", flag.ExitOnError)
+	name := flags.String("name", "", "Name of the client the key is issued to")
+	projects := flags.String("projects", "", "Comma-separated project IDs the key may read, all projects when empty")
+	rate := flags.Float64("rate", auth.DefaultRatePerSecond, "Requests per second refilled into the key's bucket")
+	burst := flags.Uint("burst", auth.DefaultBurst, "Most requests the key can make at once")
+	flags.Parse(args)
+
+	if *name == "" {
+		log.Fatal("Missing -name flag")
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+
+	key, err := auth.GenerateKey()
+	if err != nil {
+		log.Fatalf("Error generating API key: %v", err)
+	}
+	entry := models.APIKey{
+		Name:          *name,
+		KeyHash:       auth.HashKey(key),
+		ProjectIDs:    []string{},
+		RatePerSecond: *rate,
+		Burst:         uint32(*burst),
+	}
+	if *projects != "" {
+		entry.ProjectIDs = strings.Split(*projects, ",")
+	}
+
+	if cfg.AuthBackend == config.AuthClickHouse {
+		ctx := context.Background()
+		repo, _, closeRepo := setupRepository(ctx, cfg)
+		defer closeRepo()
+
+		if err := repo.(*database.ClickHouseRepository).StoreAPIKey(ctx, entry); err != nil {
+			log.Fatalf("Error storing API key: %v", err)
+		}
+		log.Printf("Stored API key %s in ClickHouse.", *name)
+	} else {
+		data, err := json.MarshalIndent(entry, "", "  ")
+		if err != nil {
+			log.Fatalf("Error encoding API key: %v", err)
+		}
+		log.Printf("Add this entry to %s:\n%s", cfg.APIKeysFile, data)
+	}
+
+	// The key itself is never stored, so this is the only time it is shown
+	fmt.Println(key)
+}
@@ -2,15 +2,25 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"log"
-	"strings"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 	"time"
 
 	"github.com/estensen/marketplace-pipeline/internal/aggregator"
 	"github.com/estensen/marketplace-pipeline/internal/api"
+	"github.com/estensen/marketplace-pipeline/internal/archive"
+	"github.com/estensen/marketplace-pipeline/internal/auth"
+	"github.com/estensen/marketplace-pipeline/internal/config"
 	"github.com/estensen/marketplace-pipeline/internal/database"
 	"github.com/estensen/marketplace-pipeline/internal/parser"
+	"github.com/estensen/marketplace-pipeline/internal/pipeline"
 	"github.com/estensen/marketplace-pipeline/internal/price"
+	"github.com/estensen/marketplace-pipeline/internal/source"
 	"github.com/estensen/marketplace-pipeline/internal/storage"
 	"github.com/estensen/marketplace-pipeline/internal/utils"
 )
@@ -19,112 +29,172 @@ func main() {
 	// Initialize logging with timestamp and file info
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
-	ctx := context.Background()
+	if len(os.Args) > 1 && os.Args[1] == "reprice" {
+		runReprice(os.Args[2:])
+		return
+	}
 
-	// Use a fixed date matching the sample data
-	dateStr := "2024-04-02"
-	date, err := time.Parse("2006-01-02", dateStr)
-	if err != nil {
-		log.Fatalf("Error parsing date: %v", err)
+	if len(os.Args) > 1 && os.Args[1] == "apikey" {
+		runAPIKey(os.Args[2:])
+		return
 	}
 
-	// Set up ClickHouse connection
-	clickhouseConn := database.NewClickHouseConnection(ctx)
-	defer clickhouseConn.Close()
+	runPipeline(os.Args[1:])
+}
 
-	// Initialize MinIO storage
-	minioStorage := storage.SetupMinIOStorage()
+// runPipeline parses the transactions, fetches prices, loads the aggregates and serves the API.
+func runPipeline(args []string) {
+	flags := flag.NewFlagSet("pipeline", flag.ExitOnError)
+	reprocess := flags.Bool("reprocess", false, "Process inputs even if identical content was already processed")
+	flags.Parse(args)
 
-	// Initialize CoinGecko API
-	coinAPI := price.NewCoinGeckoAPI()
+	// Cancel the run and drain the API on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// Fetch coin list
-	symbolToCoinID, err := coinAPI.FetchCoinsList()
+	cfg, err := config.Load()
 	if err != nil {
-		log.Fatalf("Error fetching coin list: %v", err)
+		log.Fatalf("Error loading config: %v", err)
 	}
 
-	// Parse CSV file to get the transactions
-	csvParser := parser.NewCSVParser()
-	transactions, err := csvParser.ParseCSV("data/sample.csv")
-	if err != nil {
-		log.Fatalf("Error parsing CSV: %v", err)
-	}
+	// Set up the configured database
+	repo, checks, closeRepo := setupRepository(ctx, cfg)
+	defer closeRepo()
 
-	// Extract unique tokens from the transactions
-	tokens := utils.ExtractUniqueTokens(transactions)
+	// Initialize the configured object storage
+	objectStorage := setupStorage(cfg)
 
-	// Map tokens to CoinGecko IDs
-	coinIDs := []string{}
-	for _, tokenSymbol := range tokens {
-		// Normalize token symbol
-		normalizedToken := utils.NormalizeTokenSymbol(strings.ToUpper(tokenSymbol))
-		if coinID, found := symbolToCoinID[normalizedToken]; found {
-			coinIDs = append(coinIDs, coinID)
-		} else {
-			log.Printf("No CoinGecko ID found for token: %s", tokenSymbol)
-		}
-	}
+	// Serve prices from archived snapshots when available, falling back to CoinGecko
+	coinAPI := price.NewSnapshotProvider(objectStorage, price.NewCoinGeckoAPI())
 
-	if len(coinIDs) == 0 {
+	// Run the pipeline
+	agg := aggregator.NewAggregator()
+	p := pipeline.NewPipeline(setupSource(cfg, objectStorage), parser.NewCSVParser(), coinAPI, agg, repo, repo, repo, archive.NewArchiver(objectStorage, cfg.ArchiveFormats...))
+	p.Reprocess = *reprocess
+
+	var loadedFrom, loadedTo time.Time
+	p.Loaded = func(from, to time.Time) {
+		loadedFrom, loadedTo = from, to
+	}
+	switch err := p.Run(ctx, cfg.InputURI); {
+	case errors.Is(err, pipeline.ErrNoValidCoinIDs):
 		log.Println("No valid CoinGecko IDs found, exiting.")
 		return
+	case errors.Is(err, pipeline.ErrNoNewInputs):
+		log.Println("All inputs were already processed, use --reprocess to process them again.")
+	case ctx.Err() != nil:
+		log.Printf("Pipeline interrupted: %v", err)
+		return
+	case err != nil:
+		log.Fatalf("Error running pipeline: %v", err)
+	default:
+		log.Println("Data pipeline completed successfully.")
 	}
 
-	// Run batch job to fetch and store token prices
-	batchJob := database.NewBatchJob(coinAPI, clickhouseConn, minioStorage)
-	err = batchJob.RunDailyBatchJob(ctx, coinIDs, date)
-	if err != nil {
-		log.Printf("Error running daily batch job: %v", err)
-	} else {
-		log.Println("Daily batch job completed successfully.")
+	// Display the metrics of the days loaded by the run in terminal
+	if !loadedFrom.IsZero() {
+		aggregatedMetrics, err := repo.FetchMetricsRange(ctx, loadedFrom, loadedTo)
+		if err != nil {
+			log.Fatalf("Error fetching metrics: %v", err)
+		}
+		utils.DisplayMetrics(aggregatedMetrics)
 	}
 
-	// Fetch prices from ClickHouse
-	prices, err := database.FetchPrices(ctx, clickhouseConn, coinIDs, date)
-	if err != nil {
-		log.Fatalf("Error fetching prices from ClickHouse: %v", err)
+	// Serve the API until interrupted. Returning closes the database once in-flight requests are drained.
+	apiServer := api.NewServer(agg, repo, repo)
+	apiServer.Runs = p
+	apiServer.Checks = append(checks, api.Check{Name: cfg.StorageBackend, Run: objectStorage.Ping})
+	apiServer.Keys = setupAuth(cfg, repo)
+	// Cache metrics responses until a run, by this or any other process, records new inputs in the ledger
+	if cfg.HTTP.CacheSize > 0 {
+		apiServer.Cache = api.NewResponseCache(cfg.HTTP.CacheSize)
+		apiServer.Cache.Generation = api.LedgerGeneration(repo)
 	}
+	if err := apiServer.ListenAndServe(ctx, cfg.HTTP); err != nil {
+		log.Printf("Error running API server: %v", err)
+	}
+}
 
-	// Map CoinGecko IDs back to symbols
-	coinIDToSymbol := utils.InvertMap(symbolToCoinID)
-
-	// Map prices to symbols
-	symbolPrices := make(map[string]float64)
-	for coinID, priceUSD := range prices {
-		symbol := coinIDToSymbol[coinID]
-		symbolPrices[symbol] = priceUSD
+// setupRepository opens the database backend selected in the config and returns its readiness checks
+// and a function closing it.
+func setupRepository(ctx context.Context, cfg config.Config) (database.Repository, []api.Check, func()) {
+	if cfg.DatabaseBackend == config.DatabaseSQLite {
+		repo, err := database.NewSQLiteRepository(ctx, cfg.SQLitePath)
+		if err != nil {
+			log.Fatalf("Error opening SQLite database: %v", err)
+		}
+		return repo, []api.Check{{Name: "sqlite", Run: repo.Ping}}, closeWith(repo.Close)
 	}
 
-	// Aggregate data
-	agg := aggregator.NewAggregator()
-	aggregatedData, err := agg.Aggregate(transactions, symbolPrices)
-	if err != nil {
-		log.Fatalf("Error aggregating data: %v", err)
+	// Set up ClickHouse connection
+	clickhouseConn := database.NewClickHouseConnection(ctx)
+
+	// Apply pending schema migrations
+	if err := database.Migrate(ctx, clickhouseConn); err != nil {
+		log.Fatalf("Error migrating ClickHouse schema: %v", err)
 	}
 
-	// Load aggregated data into ClickHouse
-	dataLoader := database.NewClickHouseLoader(clickhouseConn)
-	err = dataLoader.Load(aggregatedData)
-	if err != nil {
-		log.Fatalf("Error loading data into ClickHouse: %v", err)
+	repo := database.NewClickHouseRepository(clickhouseConn)
+	checks := []api.Check{
+		{Name: "clickhouse", Run: repo.Ping},
+		{Name: "schema", Run: func(ctx context.Context) error { return database.CheckSchemaVersion(ctx, clickhouseConn) }},
 	}
+	return repo, checks, closeWith(repo.Close)
+}
 
-	log.Println("Data pipeline completed successfully.")
+// closeWith returns a function calling closeFn and logging its error.
+func closeWith(closeFn func() error) func() {
+	return func() {
+		if err := closeFn(); err != nil {
+			log.Printf("Error closing database: %v", err)
+		}
+	}
+}
 
-	// Start the API server in a separate goroutine
-	apiServer := api.NewServer(agg, clickhouseConn)
-	go api.StartServer(":8080", apiServer)
+// setupAuth returns the API key store selected in the config, or nil to leave the API open.
+func setupAuth(cfg config.Config, repo database.Repository) auth.KeyStore {
+	switch cfg.AuthBackend {
+	case config.AuthFile:
+		keys, err := auth.LoadKeyFile(cfg.APIKeysFile)
+		if err != nil {
+			log.Fatalf("Error loading API keys: %v", err)
+		}
+		return keys
+	case config.AuthClickHouse:
+		// The config only allows ClickHouse keys with the ClickHouse database
+		return repo.(*database.ClickHouseRepository)
+	default:
+		log.Println("API key authentication is disabled, the API is open.")
+		return nil
+	}
+}
 
-	// Fetch aggregated metrics
-	aggregatedMetrics, err := database.FetchMetrics(ctx, clickhouseConn, date)
-	if err != nil {
-		log.Fatalf("Error fetching metrics: %v", err)
+// setupStorage initializes the object storage backend selected in the config.
+func setupStorage(cfg config.Config) storage.Storage {
+	if cfg.StorageBackend == config.StorageLocal {
+		localStorage, err := storage.NewLocalFSStorage(cfg.StorageRoot)
+		if err != nil {
+			log.Fatalf("Failed to initialize local storage: %v", err)
+		}
+		return localStorage
 	}
 
-	// Display metrics in terminal
-	utils.DisplayMetrics(aggregatedMetrics)
+	return storage.SetupMinIOStorage()
+}
+
+// setupSource registers the input sources. s3:// URIs read buckets from the configured object storage;
+// with local storage each bucket is a directory under the storage root.
+func setupSource(cfg config.Config, objectStorage storage.Storage) source.Source {
+	bucket := func(name string) (storage.Storage, error) {
+		return storage.NewLocalFSStorage(filepath.Join(cfg.StorageRoot, name))
+	}
+	if minioStorage, ok := objectStorage.(*storage.MinIOStorage); ok {
+		bucket = func(name string) (storage.Storage, error) {
+			return minioStorage.WithBucket(name), nil
+		}
+	}
 
-	// Keep the main function running so it's possible to query the API
-	select {}
+	mux := source.NewMux()
+	mux.Register("s3", source.NewObjectSource("s3", bucket))
+	return mux
 }
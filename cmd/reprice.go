@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/estensen/marketplace-pipeline/internal/aggregator"
+	"github.com/estensen/marketplace-pipeline/internal/archive"
+	"github.com/estensen/marketplace-pipeline/internal/config"
+	"github.com/estensen/marketplace-pipeline/internal/database"
+	"github.com/estensen/marketplace-pipeline/internal/price"
+	"github.com/estensen/marketplace-pipeline/internal/reprice"
+)
+
+// runReprice recomputes USD analytics for a token over a date range after its prices were corrected.
+func runReprice(args []string) {
+	flags := flag.NewFlagSet("reprice", flag.ExitOnError)
+	token := flags.String("token", "", "CoinGecko ID of the token whose prices changed")
+	fromStr := flags.String("from", "", "First day to reprice (YYYY-MM-DD)")
+	toStr := flags.String("to", "", "Last day to reprice (YYYY-MM-DD)")
+	refetch := flags.Bool("refetch", false, "Fetch the token's prices for the range again and store them as corrections before repricing")
+	flags.Parse(args)
+
+	if *token == "" {
+		log.Fatal("Missing -token flag")
+	}
+
+	from, err := time.Parse("2006-01-02", *fromStr)
+	if err != nil {
+		log.Fatalf("Error parsing -from date: %v", err)
+	}
+
+	to, err := time.Parse("2006-01-02", *toStr)
+	if err != nil {
+		log.Fatalf("Error parsing -to date: %v", err)
+	}
+
+	ctx := context.Background()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+
+	// Set up the configured database
+	repo, _, closeRepo := setupRepository(ctx, cfg)
+	defer closeRepo()
+
+	if *refetch {
+		// Fetch from CoinGecko directly, as the archived snapshots hold the prices being corrected
+		batchJob := database.NewBatchJob(price.NewCoinGeckoAPI(), repo, archive.NewArchiver(setupStorage(cfg), cfg.ArchiveFormats...))
+		batchJob.Overwrite = true
+		for date := from; !date.After(to); date = date.AddDate(0, 0, 1) {
+			if err := batchJob.RunDailyBatchJob(ctx, []string{*token}, date); err != nil {
+				log.Fatalf("Error refetching prices for %s: %v", date.Format("2006-01-02"), err)
+			}
+		}
+	}
+
+	repricer := reprice.NewRepricer(aggregator.NewAggregator(), repo, repo)
+	entries, err := repricer.Reprice(ctx, *token, from, to)
+	if err != nil {
+		log.Fatalf("Error repricing analytics: %v", err)
+	}
+
+	for _, entry := range entries {
+		log.Printf("Repriced project %s on %s: %.2f USD -> %.2f USD",
+			entry.ProjectID, entry.Date.Format("2006-01-02"), entry.OldVolumeUSD, entry.NewVolumeUSD)
+	}
+	log.Printf("Repriced %d analytics rows for token %s.", len(entries), *token)
+}
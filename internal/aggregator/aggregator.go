@@ -1,14 +1,13 @@
 package aggregator
 
 import (
-	"context"
 	"fmt"
 	"log"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/estensen/marketplace-pipeline/internal/models"
 )
 
@@ -82,48 +81,98 @@ func (a *Aggregator) updateAggregatedData(dataMap map[string]*models.AggregatedD
 	}
 }
 
-// collectAggregatedData compiles the aggregated data into a slice.
+// collectAggregatedData compiles the aggregated data into a slice ordered by date and project.
 func (a *Aggregator) collectAggregatedData(dataMap map[string]*models.AggregatedData) []models.AggregatedData {
 	aggregatedData := make([]models.AggregatedData, 0, len(dataMap))
 	for _, data := range dataMap {
 		aggregatedData = append(aggregatedData, *data)
 	}
+	sort.Slice(aggregatedData, func(i, j int) bool {
+		if !aggregatedData[i].Date.Equal(aggregatedData[j].Date) {
+			return aggregatedData[i].Date.Before(aggregatedData[j].Date)
+		}
+		return aggregatedData[i].ProjectID < aggregatedData[j].ProjectID
+	})
 	return aggregatedData
 }
 
-// normalizeSymbol normalizes a token symbol by converting it to uppercase and splitting on periods.
-func normalizeSymbol(symbol string) string {
-	return strings.ToUpper(strings.Split(symbol, ".")[0])
+// AggregateNative sums the native currency volume of transactions per day, project and currency.
+// The coinIDs map links normalized currency symbols to CoinGecko IDs, which is the key used in token_prices.
+func (a *Aggregator) AggregateNative(transactions []models.Transaction, coinIDs map[string]string) ([]models.NativeVolume, error) {
+	dataMap := make(map[string]*models.NativeVolume)
+
+	for _, txn := range transactions {
+		date := txn.Timestamp.Truncate(24 * time.Hour)
+		key := date.Format("2006-01-02") + txn.ProjectID + txn.Props.CurrencySymbol
+
+		currencyValue, err := a.parseCurrencyValue(txn.Nums.CurrencyValueDecimal)
+		if err != nil {
+			log.Printf("Error parsing currency value: %v", err)
+			continue
+		}
+
+		if data, exists := dataMap[key]; exists {
+			data.TransactionCount++
+			data.TotalVolumeNative += currencyValue
+			continue
+		}
+
+		token, found := coinIDs[normalizeSymbol(txn.Props.CurrencySymbol)]
+		if !found {
+			log.Printf("No token ID found for currency symbol: %s", txn.Props.CurrencySymbol)
+		}
+
+		dataMap[key] = &models.NativeVolume{
+			Date:              date,
+			ProjectID:         txn.ProjectID,
+			CurrencySymbol:    txn.Props.CurrencySymbol,
+			Token:             token,
+			TransactionCount:  1,
+			TotalVolumeNative: currencyValue,
+		}
+	}
+
+	nativeVolumes := make([]models.NativeVolume, 0, len(dataMap))
+	for _, data := range dataMap {
+		nativeVolumes = append(nativeVolumes, *data)
+	}
+	return nativeVolumes, nil
 }
 
-// CalculateMetrics fetches aggregated metrics from ClickHouse.
-func (a *Aggregator) CalculateMetrics(conn clickhouse.Conn, date time.Time) ([]models.AggregatedData, error) {
-	query := `
-        SELECT
-            date,
-            project_id,
-            SUM(transaction_count) AS transaction_count,
-            SUM(total_volume_usd) AS total_volume_usd
-        FROM marketplace_analytics
-        WHERE date = ?
-        GROUP BY date, project_id
-        `
-
-	var aggregatedData []models.AggregatedData
-
-	rows, err := conn.Query(context.Background(), query, date)
-	if err != nil {
-		return nil, err
+// PriceNativeVolumes converts native volumes to USD using the price of each token on the same day.
+// Volumes without a matching price are skipped, mirroring how Aggregate skips unpriced transactions.
+func (a *Aggregator) PriceNativeVolumes(volumes []models.NativeVolume, prices []models.TokenPrice) []models.AggregatedData {
+	priceMap := make(map[string]float64)
+	for _, p := range prices {
+		priceMap[p.Date.Format("2006-01-02")+p.Token] = p.AveragePriceUSD
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var data models.AggregatedData
-		if err := rows.Scan(&data.Date, &data.ProjectID, &data.TransactionCount, &data.TotalVolumeUSD); err != nil {
-			return nil, err
+	dataMap := make(map[string]*models.AggregatedData)
+	for _, v := range volumes {
+		priceUSD, found := priceMap[v.Date.Format("2006-01-02")+v.Token]
+		if !found {
+			log.Printf("Price not found for token %s on %s", v.Token, v.Date.Format("2006-01-02"))
+			continue
+		}
+
+		key := v.Date.Format("2006-01-02") + v.ProjectID
+		if aggData, exists := dataMap[key]; exists {
+			aggData.TransactionCount += v.TransactionCount
+			aggData.TotalVolumeUSD += v.TotalVolumeNative * priceUSD
+		} else {
+			dataMap[key] = &models.AggregatedData{
+				Date:             v.Date,
+				ProjectID:        v.ProjectID,
+				TransactionCount: v.TransactionCount,
+				TotalVolumeUSD:   v.TotalVolumeNative * priceUSD,
+			}
 		}
-		aggregatedData = append(aggregatedData, data)
 	}
 
-	return aggregatedData, nil
+	return a.collectAggregatedData(dataMap)
+}
+
+// normalizeSymbol normalizes a token symbol by converting it to uppercase and splitting on periods.
+func normalizeSymbol(symbol string) string {
+	return strings.ToUpper(strings.Split(symbol, ".")[0])
 }
@@ -50,16 +50,16 @@ func TestAggregate(t *testing.T) {
 			},
 			expected: []models.AggregatedData{
 				{
-					Date:             time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC),
+					Date:             time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC),
 					ProjectID:        "137",
 					TransactionCount: 1,
-					TotalVolumeUSD:   1.316777549196586 * 1.23,
+					TotalVolumeUSD:   0.7 * 0.408257,
 				},
 				{
-					Date:             time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC),
+					Date:             time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC),
 					ProjectID:        "137",
 					TransactionCount: 1,
-					TotalVolumeUSD:   0.7 * 0.408257,
+					TotalVolumeUSD:   1.316777549196586 * 1.23,
 				},
 			},
 			expectedError: false,
@@ -308,3 +308,148 @@ func TestUpdateAggregatedData(t *testing.T) {
 		})
 	}
 }
+
+func TestAggregateNative(t *testing.T) {
+	t.Parallel()
+
+	coinIDs := map[string]string{
+		"MATIC": "matic-network",
+		"USDC":  "usd-coin",
+	}
+
+	tests := []struct {
+		name         string
+		transactions []models.Transaction
+		expected     []models.NativeVolume
+	}{
+		{
+			name: "Separate buckets per currency",
+			transactions: []models.Transaction{
+				{
+					Timestamp: time.Date(2024, 4, 2, 1, 0, 0, 0, time.UTC),
+					ProjectID: "4974",
+					Props:     models.Props{CurrencySymbol: "MATIC"},
+					Nums:      models.Nums{CurrencyValueDecimal: "700000000000000000"},
+				},
+				{
+					Timestamp: time.Date(2024, 4, 2, 5, 0, 0, 0, time.UTC),
+					ProjectID: "4974",
+					Props:     models.Props{CurrencySymbol: "MATIC"},
+					Nums:      models.Nums{CurrencyValueDecimal: "200000000000000000"},
+				},
+				{
+					Timestamp: time.Date(2024, 4, 2, 6, 0, 0, 0, time.UTC),
+					ProjectID: "4974",
+					Props:     models.Props{CurrencySymbol: "USDC.e"},
+					Nums:      models.Nums{CurrencyValueDecimal: "1000000000000000000"},
+				},
+			},
+			expected: []models.NativeVolume{
+				{
+					Date:              time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC),
+					ProjectID:         "4974",
+					CurrencySymbol:    "MATIC",
+					Token:             "matic-network",
+					TransactionCount:  2,
+					TotalVolumeNative: 0.9,
+				},
+				{
+					Date:              time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC),
+					ProjectID:         "4974",
+					CurrencySymbol:    "USDC.e",
+					Token:             "usd-coin",
+					TransactionCount:  1,
+					TotalVolumeNative: 1,
+				},
+			},
+		},
+		{
+			name: "Unknown currency is kept without token",
+			transactions: []models.Transaction{
+				{
+					Timestamp: time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC),
+					ProjectID: "1609",
+					Props:     models.Props{CurrencySymbol: "SFL"},
+					Nums:      models.Nums{CurrencyValueDecimal: "1316777549196586000"},
+				},
+			},
+			expected: []models.NativeVolume{
+				{
+					Date:              time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC),
+					ProjectID:         "1609",
+					CurrencySymbol:    "SFL",
+					Token:             "",
+					TransactionCount:  1,
+					TotalVolumeNative: 1.316777549196586,
+				},
+			},
+		},
+		{
+			name: "Invalid currency value",
+			transactions: []models.Transaction{
+				{
+					Timestamp: time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC),
+					Props:     models.Props{CurrencySymbol: "MATIC"},
+					Nums:      models.Nums{CurrencyValueDecimal: "invalid_value"},
+				},
+			},
+			expected: nil,
+		},
+	}
+
+	aggregator := NewAggregator()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nativeVolumes, err := aggregator.AggregateNative(tt.transactions, coinIDs)
+			require.NoError(t, err)
+
+			if tt.expected == nil {
+				assert.Empty(t, nativeVolumes)
+				return
+			}
+
+			require.Len(t, nativeVolumes, len(tt.expected))
+			for _, expected := range tt.expected {
+				var found bool
+				for _, actual := range nativeVolumes {
+					if actual.CurrencySymbol != expected.CurrencySymbol {
+						continue
+					}
+					found = true
+					assert.Equal(t, expected.Date, actual.Date)
+					assert.Equal(t, expected.ProjectID, actual.ProjectID)
+					assert.Equal(t, expected.Token, actual.Token)
+					assert.Equal(t, expected.TransactionCount, actual.TransactionCount)
+					assert.InDelta(t, expected.TotalVolumeNative, actual.TotalVolumeNative, 0.0001)
+				}
+				assert.True(t, found, "native volume for %s not found", expected.CurrencySymbol)
+			}
+		})
+	}
+}
+
+func TestPriceNativeVolumes(t *testing.T) {
+	aggregator := NewAggregator()
+
+	day := time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)
+	volumes := []models.NativeVolume{
+		{Date: day, ProjectID: "4974", CurrencySymbol: "MATIC", Token: "matic-network", TransactionCount: 2, TotalVolumeNative: 0.9},
+		{Date: day, ProjectID: "4974", CurrencySymbol: "USDC", Token: "usd-coin", TransactionCount: 1, TotalVolumeNative: 3},
+		{Date: day, ProjectID: "1609", CurrencySymbol: "SFL", Token: "sunflower-land", TransactionCount: 4, TotalVolumeNative: 2},
+	}
+	prices := []models.TokenPrice{
+		{Token: "matic-network", Date: day, AveragePriceUSD: 0.5},
+		{Token: "usd-coin", Date: day, AveragePriceUSD: 1},
+		{Token: "sunflower-land", Date: day.AddDate(0, 0, 1), AveragePriceUSD: 0.1},
+	}
+
+	aggregatedData := aggregator.PriceNativeVolumes(volumes, prices)
+
+	// Project 1609 is skipped since there is no SFL price for that day
+	require.Len(t, aggregatedData, 1)
+	assert.Equal(t, day, aggregatedData[0].Date)
+	assert.Equal(t, "4974", aggregatedData[0].ProjectID)
+	assert.Equal(t, uint64(3), aggregatedData[0].TransactionCount)
+	assert.InDelta(t, 0.9*0.5+3, aggregatedData[0].TotalVolumeUSD, 0.0001)
+}
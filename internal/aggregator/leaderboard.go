@@ -0,0 +1,140 @@
+package aggregator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/estensen/marketplace-pipeline/internal/database"
+	"github.com/estensen/marketplace-pipeline/internal/models"
+)
+
+// ErrInvalidDimension is returned for leaderboard dimensions other than project and collection.
+var ErrInvalidDimension = errors.New("invalid leaderboard dimension")
+
+// Dimension is what a leaderboard ranks.
+type Dimension string
+
+const (
+	DimensionProject    Dimension = "project"
+	DimensionCollection Dimension = "collection"
+)
+
+// ParseDimension converts a string to a Dimension, defaulting to projects when empty.
+func ParseDimension(s string) (Dimension, error) {
+	switch d := Dimension(s); d {
+	case "":
+		return DimensionProject, nil
+	case DimensionProject, DimensionCollection:
+		return d, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrInvalidDimension, s)
+	}
+}
+
+// LeaderboardQuery selects the top Limit projects or collections by USD volume over the days of Metrics.
+type LeaderboardQuery struct {
+	Metrics MetricsQuery
+	By      Dimension
+	Limit   int
+}
+
+// Leaderboard ranks projects or collections by USD volume and compares each rank with the previous period of the same length.
+// A PreviousRank of zero means the entry had no volume in the previous period.
+func (a *Aggregator) Leaderboard(ctx context.Context, metrics database.MetricsRepository, prices database.PriceRepository, q LeaderboardQuery) ([]models.LeaderboardEntry, error) {
+	current, err := a.rank(ctx, metrics, prices, q.By, q.Metrics)
+	if err != nil {
+		return nil, err
+	}
+
+	previousQuery := q.Metrics
+	previousQuery.From, previousQuery.To = previousPeriod(q.Metrics)
+	previous, err := a.rank(ctx, metrics, prices, q.By, previousQuery)
+	if err != nil {
+		return nil, err
+	}
+	previousRanks := make(map[string]int, len(previous))
+	for _, entry := range previous {
+		previousRanks[entry.ID] = entry.Rank
+	}
+
+	if q.Limit > 0 && len(current) > q.Limit {
+		current = current[:q.Limit]
+	}
+	for i := range current {
+		if rank, found := previousRanks[current[i].ID]; found {
+			current[i].PreviousRank = rank
+			current[i].RankChange = rank - current[i].Rank
+		}
+	}
+
+	return current, nil
+}
+
+// rank sums the USD volume of every project or collection in the days of q, ordered by volume descending.
+func (a *Aggregator) rank(ctx context.Context, metrics database.MetricsRepository, prices database.PriceRepository, by Dimension, q MetricsQuery) ([]models.LeaderboardEntry, error) {
+	totals := make(map[string]*models.LeaderboardEntry)
+	add := func(id string, count uint64, volumeUSD float64) {
+		if entry, exists := totals[id]; exists {
+			entry.TransactionCount += count
+			entry.TotalVolumeUSD += volumeUSD
+			return
+		}
+		totals[id] = &models.LeaderboardEntry{ID: id, TransactionCount: count, TotalVolumeUSD: volumeUSD}
+	}
+
+	if by == DimensionCollection {
+		transactions, err := a.priceTransactions(ctx, metrics, prices, database.TransactionFilter{
+			From:       q.From,
+			To:         q.To.AddDate(0, 0, 1),
+			ProjectIDs: q.ProjectIDs,
+			ChainIDs:   q.ChainIDs,
+			Events:     q.Events,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, txn := range transactions {
+			add(txn.Props.CollectionAddress, 1, txn.VolumeUSD)
+		}
+	} else {
+		q.Granularity = database.GranularityDay
+		data, err := a.QueryMetrics(ctx, metrics, prices, q)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range data {
+			add(d.ProjectID, d.TransactionCount, d.TotalVolumeUSD)
+		}
+	}
+
+	entries := make([]models.LeaderboardEntry, 0, len(totals))
+	for _, entry := range totals {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].TotalVolumeUSD != entries[j].TotalVolumeUSD {
+			return entries[i].TotalVolumeUSD > entries[j].TotalVolumeUSD
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	for i := range entries {
+		entries[i].Rank = i + 1
+	}
+	return entries, nil
+}
+
+// previousPeriod returns the days of the period preceding q. Ranges of whole calendar months
+// step back by months, anything else by the same number of days.
+func previousPeriod(q MetricsQuery) (time.Time, time.Time) {
+	to := q.From.AddDate(0, 0, -1)
+	end := q.To.AddDate(0, 0, 1)
+	if q.From.Day() == 1 && end.Day() == 1 {
+		months := (end.Year()-q.From.Year())*12 + int(end.Month()-q.From.Month())
+		return q.From.AddDate(0, -months, 0), to
+	}
+	days := int(end.Sub(q.From).Hours() / 24)
+	return q.From.AddDate(0, 0, -days), to
+}
@@ -0,0 +1,79 @@
+package aggregator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/estensen/marketplace-pipeline/internal/database"
+	"github.com/estensen/marketplace-pipeline/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreviousPeriod(t *testing.T) {
+	day := func(month time.Month, d int) time.Time {
+		return time.Date(2024, month, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name         string
+		from, to     time.Time
+		expectedFrom time.Time
+		expectedTo   time.Time
+	}{
+		{name: "Day", from: day(4, 2), to: day(4, 2), expectedFrom: day(4, 1), expectedTo: day(4, 1)},
+		{name: "Week", from: day(4, 8), to: day(4, 14), expectedFrom: day(4, 1), expectedTo: day(4, 7)},
+		{name: "Month", from: day(3, 1), to: day(3, 31), expectedFrom: day(2, 1), expectedTo: day(2, 29)},
+		{name: "Two months", from: day(3, 1), to: day(4, 30), expectedFrom: day(1, 1), expectedTo: day(2, 29)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to := previousPeriod(MetricsQuery{From: tt.from, To: tt.to})
+			assert.Equal(t, tt.expectedFrom, from)
+			assert.Equal(t, tt.expectedTo, to)
+		})
+	}
+}
+
+func TestLeaderboardCollections(t *testing.T) {
+	ctx := context.Background()
+	repo := database.NewMemoryRepository()
+	apr1 := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	apr2 := apr1.AddDate(0, 0, 1)
+
+	txn := func(ts time.Time, collection, value string) models.Transaction {
+		return models.Transaction{
+			Timestamp: ts,
+			ProjectID: "4974",
+			Props:     models.Props{CurrencySymbol: "MATIC", CollectionAddress: collection},
+			Nums:      models.Nums{CurrencyValueDecimal: value},
+		}
+	}
+	require.NoError(t, repo.LoadTransactions(ctx, []models.Transaction{
+		txn(apr1.Add(time.Hour), "0xaaa", "5e18"),
+		txn(apr1.Add(2*time.Hour), "0xbbb", "1e18"),
+		txn(apr2.Add(time.Hour), "0xaaa", "1e18"),
+		txn(apr2.Add(2*time.Hour), "0xbbb", "2e18"),
+		txn(apr2.Add(3*time.Hour), "0xccc", "1e18"),
+	}))
+	for _, d := range []time.Time{apr1, apr2} {
+		require.NoError(t, repo.LoadNativeVolumes(ctx, []models.NativeVolume{
+			{Date: d, ProjectID: "4974", CurrencySymbol: "MATIC", Token: "matic-network"},
+		}))
+		require.NoError(t, repo.StorePrices(ctx, d, "coingecko", map[string]float64{"matic-network": 2}))
+	}
+
+	entries, err := NewAggregator().Leaderboard(ctx, repo, repo, LeaderboardQuery{
+		Metrics: MetricsQuery{From: apr2, To: apr2, Granularity: database.GranularityDay},
+		By:      DimensionCollection,
+		Limit:   2,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []models.LeaderboardEntry{
+		{Rank: 1, PreviousRank: 2, RankChange: 1, ID: "0xbbb", TransactionCount: 1, TotalVolumeUSD: 4},
+		{Rank: 2, PreviousRank: 1, RankChange: -1, ID: "0xaaa", TransactionCount: 1, TotalVolumeUSD: 2},
+	}, entries)
+}
@@ -0,0 +1,157 @@
+package aggregator
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/estensen/marketplace-pipeline/internal/database"
+	"github.com/estensen/marketplace-pipeline/internal/models"
+)
+
+// MetricsQuery selects metrics for the days between From and To, inclusive, bucketed by Granularity.
+// Empty ID lists match every value.
+type MetricsQuery struct {
+	From        time.Time
+	To          time.Time
+	Granularity database.Granularity
+	ProjectIDs  []string
+	ChainIDs    []string
+	Events      []string
+}
+
+// needsTransactions reports whether the query can only be answered from raw transactions,
+// since the analytics tables are daily and have no chain or event dimension.
+func (q MetricsQuery) needsTransactions() bool {
+	return !q.Granularity.HasRollup() || len(q.ChainIDs) > 0 || len(q.Events) > 0
+}
+
+// PeriodRange returns the start of the first period overlapping the range and the end of the last, exclusive.
+func (q MetricsQuery) PeriodRange() (time.Time, time.Time) {
+	from := q.Granularity.PeriodStart(q.From)
+	if !q.Granularity.HasRollup() {
+		return from, q.To.AddDate(0, 0, 1)
+	}
+	return from, q.Granularity.NextPeriodStart(q.Granularity.PeriodStart(q.To))
+}
+
+// QueryMetrics returns the metrics per period and project matching q, covering every period that overlaps the range.
+// Queries without chain or event filters are read from the rollup of their granularity.
+func (a *Aggregator) QueryMetrics(ctx context.Context, metrics database.MetricsRepository, prices database.PriceRepository, q MetricsQuery) ([]models.AggregatedData, error) {
+	if q.needsTransactions() {
+		from, to := q.PeriodRange()
+		return a.queryTransactions(ctx, metrics, prices, q, from, to)
+	}
+
+	var data []models.AggregatedData
+	if err := a.StreamMetrics(ctx, metrics, prices, q, func(d models.AggregatedData) error {
+		data = append(data, d)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// StreamMetrics calls fn with the metrics per period and project matching q, ordered by period and project.
+// Queries answered from a rollup are read row by row; the others are computed from the transactions first.
+func (a *Aggregator) StreamMetrics(ctx context.Context, metrics database.MetricsRepository, prices database.PriceRepository, q MetricsQuery, fn func(models.AggregatedData) error) error {
+	if q.needsTransactions() {
+		data, err := a.QueryMetrics(ctx, metrics, prices, q)
+		if err != nil {
+			return err
+		}
+		for _, d := range data {
+			if err := fn(d); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	from := q.Granularity.PeriodStart(q.From)
+	to := q.Granularity.PeriodStart(q.To)
+	err := metrics.StreamRollup(ctx, q.Granularity, from, to, q.ProjectIDs, fn)
+	if err != nil {
+		return fmt.Errorf("error streaming metrics: %w", err)
+	}
+	return nil
+}
+
+// queryTransactions sums the priced transactions matching q per period and project.
+func (a *Aggregator) queryTransactions(ctx context.Context, metrics database.MetricsRepository, prices database.PriceRepository, q MetricsQuery, from, to time.Time) ([]models.AggregatedData, error) {
+	transactions, err := a.priceTransactions(ctx, metrics, prices, database.TransactionFilter{
+		From:       from,
+		To:         to,
+		ProjectIDs: q.ProjectIDs,
+		ChainIDs:   q.ChainIDs,
+		Events:     q.Events,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	dataMap := make(map[string]*models.AggregatedData)
+	for _, txn := range transactions {
+		periodStart := q.Granularity.PeriodStart(txn.Timestamp)
+		key := periodStart.Format(time.RFC3339) + txn.ProjectID
+		a.updateAggregatedData(dataMap, key, periodStart, txn.ProjectID, txn.VolumeUSD)
+	}
+
+	return a.collectAggregatedData(dataMap), nil
+}
+
+// pricedTransaction is a raw transaction with its volume converted to USD.
+type pricedTransaction struct {
+	models.Transaction
+	VolumeUSD float64
+}
+
+// priceTransactions fetches the transactions matching filter and prices them with the token and price recorded for their day.
+// Transactions without a price are skipped, mirroring Aggregate.
+func (a *Aggregator) priceTransactions(ctx context.Context, metrics database.MetricsRepository, prices database.PriceRepository, filter database.TransactionFilter) ([]pricedTransaction, error) {
+	transactions, err := metrics.FetchTransactions(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching transactions: %w", err)
+	}
+
+	lastDay := filter.To.Add(-time.Nanosecond).Truncate(24 * time.Hour)
+	volumes, err := metrics.FetchNativeVolumes(ctx, filter.From, lastDay)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching native volumes: %w", err)
+	}
+	tokens := make(map[string]string)
+	for _, v := range volumes {
+		tokens[v.Date.Format("2006-01-02")+v.CurrencySymbol] = v.Token
+	}
+
+	history, err := prices.FetchPriceHistory(ctx, filter.From, lastDay, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching price history: %w", err)
+	}
+	priceMap := make(map[string]float64)
+	for _, p := range history {
+		priceMap[p.Date.Format("2006-01-02")+p.Token] = p.AveragePriceUSD
+	}
+
+	priced := make([]pricedTransaction, 0, len(transactions))
+	for _, txn := range transactions {
+		day := txn.Timestamp.Truncate(24 * time.Hour).Format("2006-01-02")
+		priceUSD, found := priceMap[day+tokens[day+txn.Props.CurrencySymbol]]
+		if !found {
+			log.Printf("Price not found for currency symbol %s on %s", txn.Props.CurrencySymbol, day)
+			continue
+		}
+
+		currencyValue, err := a.parseCurrencyValue(txn.Nums.CurrencyValueDecimal)
+		if err != nil {
+			log.Printf("Error parsing currency value: %v", err)
+			continue
+		}
+
+		priced = append(priced, pricedTransaction{Transaction: txn, VolumeUSD: currencyValue * priceUSD})
+	}
+
+	return priced, nil
+}
@@ -0,0 +1,130 @@
+package aggregator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/estensen/marketplace-pipeline/internal/database"
+	"github.com/estensen/marketplace-pipeline/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryMetrics(t *testing.T) {
+	ctx := context.Background()
+	repo := database.NewMemoryRepository()
+	apr1 := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	apr2 := apr1.AddDate(0, 0, 1)
+
+	txn := func(ts time.Time, event, projectID, chainID, value string) models.Transaction {
+		return models.Transaction{
+			Timestamp: ts,
+			Event:     event,
+			ProjectID: projectID,
+			Props:     models.Props{CurrencySymbol: "MATIC", ChainID: chainID},
+			Nums:      models.Nums{CurrencyValueDecimal: value},
+		}
+	}
+	require.NoError(t, repo.LoadTransactions(ctx, []models.Transaction{
+		txn(apr1.Add(9*time.Hour), "BUY_ITEMS", "4974", "137", "2e18"),
+		txn(apr1.Add(9*time.Hour+30*time.Minute), "SELL_ITEMS", "4974", "1", "4e18"),
+		txn(apr2.Add(13*time.Hour), "BUY_ITEMS", "1609", "137", "6e18"),
+	}))
+	require.NoError(t, repo.LoadNativeVolumes(ctx, []models.NativeVolume{
+		{Date: apr1, ProjectID: "4974", CurrencySymbol: "MATIC", Token: "matic-network", TransactionCount: 2, TotalVolumeNative: 6},
+		{Date: apr2, ProjectID: "1609", CurrencySymbol: "MATIC", Token: "matic-network", TransactionCount: 1, TotalVolumeNative: 6},
+	}))
+	require.NoError(t, repo.StorePrices(ctx, apr1, "coingecko", map[string]float64{"matic-network": 0.5}))
+	require.NoError(t, repo.StorePrices(ctx, apr2, "coingecko", map[string]float64{"matic-network": 1}))
+	require.NoError(t, repo.LoadAggregates(ctx, []models.AggregatedData{
+		{Date: apr1, ProjectID: "4974", TransactionCount: 2, TotalVolumeUSD: 3},
+		{Date: apr2, ProjectID: "1609", TransactionCount: 1, TotalVolumeUSD: 6},
+	}))
+
+	tests := []struct {
+		name     string
+		query    MetricsQuery
+		expected []models.AggregatedData
+	}{
+		{
+			name:  "Daily range",
+			query: MetricsQuery{From: apr1, To: apr2, Granularity: database.GranularityDay},
+			expected: []models.AggregatedData{
+				{Date: apr1, ProjectID: "4974", TransactionCount: 2, TotalVolumeUSD: 3},
+				{Date: apr2, ProjectID: "1609", TransactionCount: 1, TotalVolumeUSD: 6},
+			},
+		},
+		{
+			name:  "Weekly projects",
+			query: MetricsQuery{From: apr2, To: apr2, Granularity: database.GranularityWeek, ProjectIDs: []string{"4974"}},
+			expected: []models.AggregatedData{
+				{Date: apr1, ProjectID: "4974", TransactionCount: 2, TotalVolumeUSD: 3},
+			},
+		},
+		{
+			name:  "Hourly",
+			query: MetricsQuery{From: apr1, To: apr2, Granularity: database.GranularityHour},
+			expected: []models.AggregatedData{
+				{Date: apr1.Add(9 * time.Hour), ProjectID: "4974", TransactionCount: 2, TotalVolumeUSD: 3},
+				{Date: apr2.Add(13 * time.Hour), ProjectID: "1609", TransactionCount: 1, TotalVolumeUSD: 6},
+			},
+		},
+		{
+			name:  "Chain and event",
+			query: MetricsQuery{From: apr1, To: apr2, Granularity: database.GranularityDay, ChainIDs: []string{"137"}, Events: []string{"BUY_ITEMS"}},
+			expected: []models.AggregatedData{
+				{Date: apr1, ProjectID: "4974", TransactionCount: 1, TotalVolumeUSD: 1},
+				{Date: apr2, ProjectID: "1609", TransactionCount: 1, TotalVolumeUSD: 6},
+			},
+		},
+	}
+
+	aggregator := NewAggregator()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics, err := aggregator.QueryMetrics(ctx, repo, repo, tt.query)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, metrics)
+		})
+	}
+}
+
+func TestStreamMetrics(t *testing.T) {
+	ctx := context.Background()
+	repo := database.NewMemoryRepository()
+	apr1 := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, repo.LoadAggregates(ctx, []models.AggregatedData{
+		{Date: apr1, ProjectID: "4974", TransactionCount: 5, TotalVolumeUSD: 10},
+		{Date: apr1.AddDate(0, 0, 1), ProjectID: "4974", TransactionCount: 3, TotalVolumeUSD: 6},
+		{Date: apr1.AddDate(0, 0, 1), ProjectID: "1609", TransactionCount: 1, TotalVolumeUSD: 2},
+		{Date: apr1.AddDate(0, 0, 14), ProjectID: "4974", TransactionCount: 2, TotalVolumeUSD: 4},
+		{Date: apr1.AddDate(0, 1, 0), ProjectID: "1609", TransactionCount: 7, TotalVolumeUSD: 14},
+	}))
+
+	tests := []struct {
+		name  string
+		query MetricsQuery
+	}{
+		{name: "Days", query: MetricsQuery{From: apr1, To: apr1.AddDate(0, 1, 0), Granularity: database.GranularityDay}},
+		{name: "Weeks", query: MetricsQuery{From: apr1, To: apr1.AddDate(0, 1, 0), Granularity: database.GranularityWeek}},
+		{name: "Months for one project", query: MetricsQuery{From: apr1, To: apr1.AddDate(0, 1, 0), Granularity: database.GranularityMonth, ProjectIDs: []string{"1609"}}},
+	}
+
+	agg := NewAggregator()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expected, err := agg.QueryMetrics(ctx, repo, repo, tt.query)
+			require.NoError(t, err)
+			require.NotEmpty(t, expected)
+
+			var streamed []models.AggregatedData
+			require.NoError(t, agg.StreamMetrics(ctx, repo, repo, tt.query, func(d models.AggregatedData) error {
+				streamed = append(streamed, d)
+				return nil
+			}))
+			assert.Equal(t, expected, streamed)
+		})
+	}
+}
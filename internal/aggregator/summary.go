@@ -0,0 +1,138 @@
+package aggregator
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/estensen/marketplace-pipeline/internal/database"
+	"github.com/estensen/marketplace-pipeline/internal/models"
+)
+
+// breakdownToleranceUSD is how far the USD volume of the breakdowns may drift from the total, from summing
+// in a different order, before they are reported as not matching.
+const breakdownToleranceUSD = 0.01
+
+// SummaryQuery selects a project and the days between From and To, inclusive, of the period to summarize.
+type SummaryQuery struct {
+	ProjectID string
+	From      time.Time
+	To        time.Time
+}
+
+// ProjectSummary summarizes a project over the period of q and the preceding period of the same number of days,
+// with the change of each metric between them.
+func (a *Aggregator) ProjectSummary(ctx context.Context, metrics database.MetricsRepository, prices database.PriceRepository, q SummaryQuery) (models.ProjectSummary, error) {
+	current, err := a.summarizePeriod(ctx, metrics, prices, q.ProjectID, q.From, q.To)
+	if err != nil {
+		return models.ProjectSummary{}, err
+	}
+
+	days := int(q.To.Sub(q.From).Hours()/24) + 1
+	previous, err := a.summarizePeriod(ctx, metrics, prices, q.ProjectID, q.From.AddDate(0, 0, -days), q.From.AddDate(0, 0, -1))
+	if err != nil {
+		return models.ProjectSummary{}, err
+	}
+
+	return models.ProjectSummary{
+		ProjectID: q.ProjectID,
+		Current:   current,
+		Previous:  previous,
+		Change: models.SummaryChange{
+			TransactionCount: change(float64(previous.TransactionCount), float64(current.TransactionCount)),
+			TotalVolumeUSD:   change(previous.TotalVolumeUSD, current.TotalVolumeUSD),
+			UniqueTraders:    change(float64(previous.UniqueTraders), float64(current.UniqueTraders)),
+			AverageTicketUSD: change(previous.AverageTicketUSD, current.AverageTicketUSD),
+		},
+	}, nil
+}
+
+// summarizePeriod summarizes a project over the days between from and to, inclusive. Totals come from the daily
+// rollup, like /metrics. Traders and breakdowns come from the priced raw transactions, which only cover the days
+// loaded since raw transactions were stored, so BreakdownsMatch reports whether the breakdowns add up to the totals.
+func (a *Aggregator) summarizePeriod(ctx context.Context, metrics database.MetricsRepository, prices database.PriceRepository, projectID string, from, to time.Time) (models.PeriodSummary, error) {
+	summary := models.PeriodSummary{From: from, To: to}
+
+	data, err := a.QueryMetrics(ctx, metrics, prices, MetricsQuery{
+		From:        from,
+		To:          to,
+		Granularity: database.GranularityDay,
+		ProjectIDs:  []string{projectID},
+	})
+	if err != nil {
+		return summary, err
+	}
+	for _, d := range data {
+		summary.TransactionCount += d.TransactionCount
+		summary.TotalVolumeUSD += d.TotalVolumeUSD
+	}
+	if summary.TransactionCount > 0 {
+		summary.AverageTicketUSD = summary.TotalVolumeUSD / float64(summary.TransactionCount)
+	}
+
+	transactions, err := a.priceTransactions(ctx, metrics, prices, database.TransactionFilter{
+		From:       from,
+		To:         to.AddDate(0, 0, 1),
+		ProjectIDs: []string{projectID},
+	})
+	if err != nil {
+		return summary, err
+	}
+
+	traders := make(map[string]struct{})
+	currencies := make(map[string]*models.BreakdownEntry)
+	events := make(map[string]*models.BreakdownEntry)
+	var volumeUSD float64
+	for _, txn := range transactions {
+		volumeUSD += txn.VolumeUSD
+		if txn.UserID != "" {
+			traders[txn.UserID] = struct{}{}
+		}
+		addBreakdown(currencies, txn.Props.CurrencySymbol, txn.VolumeUSD)
+		addBreakdown(events, txn.Event, txn.VolumeUSD)
+	}
+	summary.BreakdownsMatch = uint64(len(transactions)) == summary.TransactionCount &&
+		math.Abs(volumeUSD-summary.TotalVolumeUSD) <= breakdownToleranceUSD
+	summary.UniqueTraders = uint64(len(traders))
+	summary.Currencies = sortedBreakdown(currencies)
+	summary.Events = sortedBreakdown(events)
+
+	return summary, nil
+}
+
+// addBreakdown counts a transaction of volumeUSD towards the breakdown entry of key.
+func addBreakdown(entries map[string]*models.BreakdownEntry, key string, volumeUSD float64) {
+	entry, exists := entries[key]
+	if !exists {
+		entry = &models.BreakdownEntry{Key: key}
+		entries[key] = entry
+	}
+	entry.TransactionCount++
+	entry.TotalVolumeUSD += volumeUSD
+}
+
+// sortedBreakdown returns the breakdown entries ordered by USD volume descending.
+func sortedBreakdown(entries map[string]*models.BreakdownEntry) []models.BreakdownEntry {
+	sorted := make([]models.BreakdownEntry, 0, len(entries))
+	for _, entry := range entries {
+		sorted = append(sorted, *entry)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].TotalVolumeUSD != sorted[j].TotalVolumeUSD {
+			return sorted[i].TotalVolumeUSD > sorted[j].TotalVolumeUSD
+		}
+		return sorted[i].Key < sorted[j].Key
+	})
+	return sorted
+}
+
+// change returns the difference from previous to current, with its percentage of previous unless previous is zero.
+func change(previous, current float64) models.Change {
+	c := models.Change{Absolute: current - previous}
+	if previous != 0 {
+		percent := c.Absolute / previous * 100
+		c.Percent = &percent
+	}
+	return c
+}
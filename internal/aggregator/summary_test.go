@@ -0,0 +1,121 @@
+package aggregator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/estensen/marketplace-pipeline/internal/database"
+	"github.com/estensen/marketplace-pipeline/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProjectSummary(t *testing.T) {
+	ctx := context.Background()
+	repo := database.NewMemoryRepository()
+	day := func(d int) time.Time {
+		return time.Date(2024, 4, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	txn := func(ts time.Time, event, userID, symbol, value string) models.Transaction {
+		return models.Transaction{
+			Timestamp: ts,
+			Event:     event,
+			ProjectID: "4974",
+			UserID:    userID,
+			Props:     models.Props{CurrencySymbol: symbol},
+			Nums:      models.Nums{CurrencyValueDecimal: value},
+		}
+	}
+	require.NoError(t, repo.LoadTransactions(ctx, []models.Transaction{
+		txn(day(2).Add(time.Hour), "BUY_ITEMS", "alice", "MATIC", "2e18"),
+		txn(day(3).Add(time.Hour), "BUY_ITEMS", "alice", "MATIC", "1e18"),
+		txn(day(3).Add(2*time.Hour), "SELL_ITEMS", "bob", "SFL", "10e18"),
+		txn(day(4).Add(time.Hour), "BUY_ITEMS", "carol", "MATIC", "3e18"),
+		txn(day(4).Add(2*time.Hour), "BUY_ITEMS", "", "MATIC", "1e18"),
+		// Not counted in the analytics, since the currency has no price
+		txn(day(4).Add(3*time.Hour), "BUY_ITEMS", "dave", "BTC", "1e18"),
+	}))
+	for _, d := range []time.Time{day(2), day(3), day(4)} {
+		require.NoError(t, repo.LoadNativeVolumes(ctx, []models.NativeVolume{
+			{Date: d, ProjectID: "4974", CurrencySymbol: "MATIC", Token: "matic-network"},
+			{Date: d, ProjectID: "4974", CurrencySymbol: "SFL", Token: "sunflower-land"},
+		}))
+		require.NoError(t, repo.StorePrices(ctx, d, "coingecko", map[string]float64{"matic-network": 2, "sunflower-land": 0.1}))
+	}
+	require.NoError(t, repo.LoadAggregates(ctx, []models.AggregatedData{
+		// Loaded before raw transactions were stored
+		{Date: day(1), ProjectID: "4974", TransactionCount: 3, TotalVolumeUSD: 12},
+		{Date: day(2), ProjectID: "4974", TransactionCount: 1, TotalVolumeUSD: 4},
+		{Date: day(3), ProjectID: "4974", TransactionCount: 2, TotalVolumeUSD: 3},
+		{Date: day(4), ProjectID: "4974", TransactionCount: 2, TotalVolumeUSD: 8},
+		{Date: day(4), ProjectID: "1609", TransactionCount: 7, TotalVolumeUSD: 70},
+	}))
+
+	summary, err := NewAggregator().ProjectSummary(ctx, repo, repo, SummaryQuery{ProjectID: "4974", From: day(3), To: day(4)})
+	require.NoError(t, err)
+
+	assert.Equal(t, "4974", summary.ProjectID)
+	assert.Equal(t, models.PeriodSummary{
+		From:             day(3),
+		To:               day(4),
+		TransactionCount: 4,
+		TotalVolumeUSD:   11,
+		UniqueTraders:    3,
+		AverageTicketUSD: 2.75,
+		Currencies: []models.BreakdownEntry{
+			{Key: "MATIC", TransactionCount: 3, TotalVolumeUSD: 10},
+			{Key: "SFL", TransactionCount: 1, TotalVolumeUSD: 1},
+		},
+		Events: []models.BreakdownEntry{
+			{Key: "BUY_ITEMS", TransactionCount: 3, TotalVolumeUSD: 10},
+			{Key: "SELL_ITEMS", TransactionCount: 1, TotalVolumeUSD: 1},
+		},
+		BreakdownsMatch: true,
+	}, summary.Current)
+	assert.Equal(t, models.PeriodSummary{
+		From:             day(1),
+		To:               day(2),
+		TransactionCount: 4,
+		TotalVolumeUSD:   16,
+		UniqueTraders:    1,
+		AverageTicketUSD: 4,
+		Currencies:       []models.BreakdownEntry{{Key: "MATIC", TransactionCount: 1, TotalVolumeUSD: 4}},
+		Events:           []models.BreakdownEntry{{Key: "BUY_ITEMS", TransactionCount: 1, TotalVolumeUSD: 4}},
+		// The breakdowns miss the day without raw transactions
+		BreakdownsMatch: false,
+	}, summary.Previous)
+
+	assert.Equal(t, 0.0, summary.Change.TransactionCount.Absolute)
+	require.NotNil(t, summary.Change.TransactionCount.Percent)
+	assert.Equal(t, 0.0, *summary.Change.TransactionCount.Percent)
+	assert.Equal(t, -5.0, summary.Change.TotalVolumeUSD.Absolute)
+	assert.Equal(t, -31.25, *summary.Change.TotalVolumeUSD.Percent)
+	assert.Equal(t, 2.0, summary.Change.UniqueTraders.Absolute)
+	assert.Equal(t, -1.25, summary.Change.AverageTicketUSD.Absolute)
+	assert.Equal(t, -31.25, *summary.Change.AverageTicketUSD.Percent)
+}
+
+func TestChange(t *testing.T) {
+	percent := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name     string
+		previous float64
+		current  float64
+		expected models.Change
+	}{
+		{name: "Increase", previous: 4, current: 5, expected: models.Change{Absolute: 1, Percent: percent(25)}},
+		{name: "Decrease", previous: 4, current: 2, expected: models.Change{Absolute: -2, Percent: percent(-50)}},
+		{name: "No previous value", previous: 0, current: 3, expected: models.Change{Absolute: 3}},
+		{name: "Unchanged", previous: 0, current: 0, expected: models.Change{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, change(tt.previous, tt.current))
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package aggregator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/estensen/marketplace-pipeline/internal/database"
+	"github.com/estensen/marketplace-pipeline/internal/models"
+)
+
+var (
+	// ErrInvalidMetric is returned for time-series metrics other than volume_usd and transaction_count.
+	ErrInvalidMetric = errors.New("invalid metric")
+	// ErrInvalidTransform is returned for time-series transforms other than moving_average and cumulative.
+	ErrInvalidTransform = errors.New("invalid transform")
+)
+
+// Metric is the value plotted by a time series.
+type Metric string
+
+const (
+	MetricVolumeUSD        Metric = "volume_usd"
+	MetricTransactionCount Metric = "transaction_count"
+)
+
+// ParseMetric converts a string to a Metric, defaulting to USD volume when empty.
+func ParseMetric(s string) (Metric, error) {
+	switch m := Metric(s); m {
+	case "":
+		return MetricVolumeUSD, nil
+	case MetricVolumeUSD, MetricTransactionCount:
+		return m, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrInvalidMetric, s)
+	}
+}
+
+// Transform is applied server-side to the gap-filled points of a time series.
+type Transform string
+
+const (
+	TransformNone          Transform = ""
+	TransformMovingAverage Transform = "moving_average"
+	TransformCumulative    Transform = "cumulative"
+)
+
+// ParseTransform converts a string to a Transform, where empty means no transform.
+func ParseTransform(s string) (Transform, error) {
+	switch t := Transform(s); t {
+	case TransformNone, TransformMovingAverage, TransformCumulative:
+		return t, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrInvalidTransform, s)
+	}
+}
+
+// TimeSeriesQuery selects one metric per period of Metrics, summed over the selected projects.
+// Window is the number of trailing points averaged by the moving-average transform.
+type TimeSeriesQuery struct {
+	Metrics   MetricsQuery
+	Metric    Metric
+	Transform Transform
+	Window    int
+}
+
+// TimeSeries returns one point per period overlapping the range, with periods without activity filled with zero.
+func (a *Aggregator) TimeSeries(ctx context.Context, metrics database.MetricsRepository, prices database.PriceRepository, q TimeSeriesQuery) ([]models.TimeSeriesPoint, error) {
+	data, err := a.QueryMetrics(ctx, metrics, prices, q.Metrics)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[int64]float64, len(data))
+	for _, d := range data {
+		switch q.Metric {
+		case MetricTransactionCount:
+			values[d.Date.Unix()] += float64(d.TransactionCount)
+		default:
+			values[d.Date.Unix()] += d.TotalVolumeUSD
+		}
+	}
+
+	var points []models.TimeSeriesPoint
+	start, end := q.Metrics.PeriodRange()
+	for ts := start; ts.Before(end); ts = q.Metrics.Granularity.NextPeriodStart(ts) {
+		points = append(points, models.TimeSeriesPoint{Timestamp: ts, Value: values[ts.Unix()]})
+	}
+
+	switch q.Transform {
+	case TransformCumulative:
+		cumulative(points)
+	case TransformMovingAverage:
+		movingAverage(points, q.Window)
+	}
+
+	return points, nil
+}
+
+// cumulative replaces each value with the running total up to and including it.
+func cumulative(points []models.TimeSeriesPoint) {
+	var total float64
+	for i := range points {
+		total += points[i].Value
+		points[i].Value = total
+	}
+}
+
+// movingAverage replaces each value with the mean of the trailing window of values ending at it.
+// The first points average over the values available so far.
+func movingAverage(points []models.TimeSeriesPoint, window int) {
+	if window < 1 {
+		return
+	}
+	raw := make([]float64, len(points))
+	var sum float64
+	for i := range points {
+		raw[i] = points[i].Value
+		sum += raw[i]
+		if i >= window {
+			sum -= raw[i-window]
+		}
+		points[i].Value = sum / float64(min(i+1, window))
+	}
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/estensen/marketplace-pipeline/internal/aggregator"
+	"github.com/estensen/marketplace-pipeline/internal/auth"
+)
+
+// APIKeyHeader carries the API key. Keys are also accepted as an Authorization bearer token.
+const APIKeyHeader = "X-API-Key"
+
+// Rate limit headers set on every authenticated response.
+const (
+	RateLimitLimitHeader     = "X-RateLimit-Limit"
+	RateLimitRemainingHeader = "X-RateLimit-Remaining"
+	RateLimitResetHeader     = "X-RateLimit-Reset"
+)
+
+// authenticate requires a valid API key when the server has a key store, rate-limits it, and stores it in the
+// request context for scope. Without a key store every request is let through.
+func (s *Server) authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.Keys == nil {
+			next(w, r)
+			return
+		}
+
+		token := apiKey(r)
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, r, ErrUnauthorized)
+			return
+		}
+		key, found, err := s.Keys.FindAPIKey(r.Context(), auth.HashKey(token))
+		if err != nil {
+			writeError(w, r, fmt.Errorf("error looking up API key: %w", err))
+			return
+		}
+		if !found {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, r, ErrUnauthorized)
+			return
+		}
+
+		if s.Limiter != nil {
+			decision := s.Limiter.Allow(key)
+			w.Header().Set(RateLimitLimitHeader, strconv.Itoa(decision.Limit))
+			w.Header().Set(RateLimitRemainingHeader, strconv.Itoa(decision.Remaining))
+			w.Header().Set(RateLimitResetHeader, ceilSeconds(decision.Reset))
+			if !decision.Allowed {
+				w.Header().Set("Retry-After", ceilSeconds(decision.RetryAfter))
+				writeError(w, r, ErrRateLimited)
+				return
+			}
+		}
+
+		next(w, r.WithContext(auth.WithKey(r.Context(), key)))
+	}
+}
+
+// apiKey returns the key sent in the X-API-Key header or as a bearer token.
+func apiKey(r *http.Request) string {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return key
+	}
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// scope restricts q to the projects of the authenticated key. Queries without project IDs are narrowed to the
+// key's projects, and queries naming other projects are forbidden.
+func scope(r *http.Request, q *aggregator.MetricsQuery) error {
+	key, ok := auth.KeyFromContext(r.Context())
+	if !ok || len(key.ProjectIDs) == 0 {
+		return nil
+	}
+	if len(q.ProjectIDs) == 0 {
+		q.ProjectIDs = key.ProjectIDs
+		return nil
+	}
+	for _, id := range q.ProjectIDs {
+		if !auth.Allows(key, id) {
+			return fmt.Errorf("%w: project %s", ErrForbidden, id)
+		}
+	}
+	return nil
+}
+
+// requireUnscoped forbids keys restricted to some projects, for operations affecting every project.
+func requireUnscoped(r *http.Request) error {
+	if key, ok := auth.KeyFromContext(r.Context()); ok && len(key.ProjectIDs) > 0 {
+		return fmt.Errorf("%w: key %s is restricted to projects", ErrForbidden, key.Name)
+	}
+	return nil
+}
+
+// ceilSeconds formats d as a whole number of seconds, rounded up.
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/estensen/marketplace-pipeline/internal/auth"
+	"github.com/estensen/marketplace-pipeline/internal/models"
+)
+
+func TestAuthenticate(t *testing.T) {
+	server, _ := newTestServer(t)
+	server.Keys = auth.NewFileKeyStore([]models.APIKey{
+		{Name: "partner", KeyHash: auth.HashKey("partner-key"), ProjectIDs: []string{"4974"}, RatePerSecond: 1, Burst: 100},
+		{Name: "internal", KeyHash: auth.HashKey("internal-key")},
+	})
+	handler := server.Routes()
+
+	tests := []struct {
+		name             string
+		method           string
+		target           string
+		header           string
+		value            string
+		expectedStatus   int
+		expectedCode     string
+		expectedProjects []string
+	}{
+		{name: "Missing key", target: "/metrics?date=2024-04-02", expectedStatus: http.StatusUnauthorized, expectedCode: CodeUnauthorized},
+		{name: "Unknown key", target: "/metrics?date=2024-04-02", header: APIKeyHeader, value: "guess", expectedStatus: http.StatusUnauthorized, expectedCode: CodeUnauthorized},
+		{name: "Scoped key sees its projects", target: "/metrics?date=2024-04-02", header: APIKeyHeader, value: "partner-key", expectedStatus: http.StatusOK, expectedProjects: []string{"4974"}},
+		{name: "Bearer token", target: "/metrics?date=2024-04-02", header: "Authorization", value: "Bearer partner-key", expectedStatus: http.StatusOK, expectedProjects: []string{"4974"}},
+		{name: "Scoped key asks for another project", target: "/metrics?date=2024-04-02&project_id=1609", header: APIKeyHeader, value: "partner-key", expectedStatus: http.StatusForbidden, expectedCode: CodeForbidden},
+		{name: "Scoped key on the v1 API", target: "/v1/metrics?date=2024-04-02&project_id=1609", header: APIKeyHeader, value: "partner-key", expectedStatus: http.StatusForbidden, expectedCode: CodeForbidden},
+		{name: "Scoped key asks for another project summary", target: "/v1/projects/1609/summary?date=2024-04-02", header: APIKeyHeader, value: "partner-key", expectedStatus: http.StatusForbidden, expectedCode: CodeForbidden},
+		{name: "Scoped key can't reprice", method: http.MethodPost, target: "/reprice?token=matic-network&from=2024-04-02&to=2024-04-02", header: APIKeyHeader, value: "partner-key", expectedStatus: http.StatusForbidden, expectedCode: CodeForbidden},
+		{name: "Unscoped key sees every project", target: "/metrics?date=2024-04-02", header: APIKeyHeader, value: "internal-key", expectedStatus: http.StatusOK, expectedProjects: []string{"1609", "4974"}},
+		{name: "Probes stay open", target: "/healthz", expectedStatus: http.StatusOK},
+		{name: "OpenAPI document stays open", target: "/v1/openapi.json", expectedStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, tt.target, nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			require.Equal(t, tt.expectedStatus, rec.Code, rec.Body.String())
+
+			if tt.expectedCode != "" {
+				var resp ErrorResponse
+				require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+				assert.Equal(t, tt.expectedCode, resp.Error.Code)
+			}
+			if tt.expectedProjects != nil {
+				var metrics []models.AggregatedData
+				require.NoError(t, json.NewDecoder(rec.Body).Decode(&metrics))
+				var projects []string
+				for _, m := range metrics {
+					projects = append(projects, m.ProjectID)
+				}
+				assert.ElementsMatch(t, tt.expectedProjects, projects)
+			}
+		})
+	}
+}
+
+func TestAuthenticateRateLimit(t *testing.T) {
+	server, _ := newTestServer(t)
+	server.Keys = auth.NewFileKeyStore([]models.APIKey{
+		{Name: "partner", KeyHash: auth.HashKey("partner-key"), RatePerSecond: 0.5, Burst: 2},
+	})
+	handler := server.Routes()
+
+	request := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/metrics?date=2024-04-02", nil)
+		req.Header.Set(APIKeyHeader, "partner-key")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := request()
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get(RateLimitLimitHeader))
+	assert.Equal(t, "1", rec.Header().Get(RateLimitRemainingHeader))
+	assert.Equal(t, "2", rec.Header().Get(RateLimitResetHeader))
+
+	require.Equal(t, http.StatusOK, request().Code)
+
+	rec = request()
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "0", rec.Header().Get(RateLimitRemainingHeader))
+	assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+
+	var resp ErrorResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, CodeRateLimited, resp.Error.Code)
+	assert.NotEmpty(t, resp.Error.RequestID)
+}
@@ -0,0 +1,272 @@
+package api
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/estensen/marketplace-pipeline/internal/aggregator"
+	"github.com/estensen/marketplace-pipeline/internal/database"
+)
+
+// Cache-Control values of metrics responses. Past days only change when they are reloaded or repriced, so
+// clients may reuse them for a while; ranges including today must be revalidated with the ETag.
+const (
+	CacheControlPast  = "max-age=3600"
+	CacheControlToday = "no-cache"
+)
+
+// cachedResponse is an encoded response along with the headers it was served with.
+type cachedResponse struct {
+	Body   []byte
+	ETag   string
+	Header map[string]string
+	// From and To bound the days the response covers, To exclusive.
+	From time.Time
+	To   time.Time
+	// Generation is the data generation the response was computed from.
+	Generation string
+}
+
+// ResponseCache is an LRU cache of encoded responses keyed by normalized query.
+type ResponseCache struct {
+	// Generation, when set, identifies the data loaded so far. It is probed on every cacheable request, and
+	// responses of earlier generations are dropped, so loads by other processes sharing the database are seen.
+	Generation func(ctx context.Context) (string, error)
+
+	mu         sync.Mutex
+	size       int
+	order      *list.List
+	entries    map[string]*list.Element
+	generation string
+}
+
+// cacheEntry is an element of the LRU order.
+type cacheEntry struct {
+	key      string
+	response cachedResponse
+}
+
+// NewResponseCache creates a ResponseCache holding at most size responses.
+func NewResponseCache(size int) *ResponseCache {
+	return &ResponseCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// Get returns the response cached under key, marking it as recently used.
+func (c *ResponseCache) Get(key string) (cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return cachedResponse{}, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*cacheEntry).response, true
+}
+
+// Put caches resp under key, evicting the least recently used response when full. Responses computed from
+// an earlier generation than the current one are not cached.
+func (c *ResponseCache) Put(key string, resp cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if resp.Generation != c.generation {
+		return
+	}
+
+	if element, ok := c.entries[key]; ok {
+		element.Value.(*cacheEntry).response = resp
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, response: resp})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// Invalidate drops the responses covering any day from from to to, inclusive.
+func (c *ResponseCache) Invalidate(from, to time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for element := c.order.Front(); element != nil; {
+		next := element.Next()
+		resp := element.Value.(*cacheEntry).response
+		if resp.From.Before(to.AddDate(0, 0, 1)) && from.Before(resp.To) {
+			c.remove(element)
+		}
+		element = next
+	}
+}
+
+// probe returns the current generation, dropping every cached response when it changed since the last probe.
+func (c *ResponseCache) probe(ctx context.Context) (string, error) {
+	if c.Generation == nil {
+		return "", nil
+	}
+	generation, err := c.Generation(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		c.generation = generation
+		c.order.Init()
+		clear(c.entries)
+	}
+	return generation, nil
+}
+
+// LedgerGeneration returns a cache generation that changes whenever a pipeline run records inputs in ledger,
+// which every load does.
+func LedgerGeneration(ledger database.InputLedger) func(ctx context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		last, found, err := ledger.LastProcessedInput(ctx)
+		if err != nil || !found {
+			return "", err
+		}
+		return last.RunID, nil
+	}
+}
+
+// Len returns the number of cached responses.
+func (c *ResponseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// remove drops element from the cache. The caller must hold the lock.
+func (c *ResponseCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).key)
+}
+
+// cacheKey normalizes a metrics query into a cache key. It is built from the parsed query, after scoping to the
+// API key's projects, so equivalent URLs share an entry and differently scoped keys don't.
+func cacheKey(path string, q aggregator.MetricsQuery, p page) string {
+	after := ""
+	if p.After != nil {
+		after = encodeCursor(*p.After)
+	}
+	return strings.Join([]string{
+		path,
+		q.From.Format("2006-01-02"),
+		q.To.Format("2006-01-02"),
+		string(q.Granularity),
+		sortedIDs(q.ProjectIDs),
+		sortedIDs(q.ChainIDs),
+		sortedIDs(q.Events),
+		p.Sort,
+		strconv.FormatBool(p.Desc),
+		strconv.Itoa(p.Limit),
+		after,
+	}, "|")
+}
+
+// sortedIDs joins a sorted copy of ids.
+func sortedIDs(ids []string) string {
+	sorted := append([]string{}, ids...)
+	slices.Sort(sorted)
+	return strings.Join(slices.Compact(sorted), ",")
+}
+
+// serveCached answers a metrics query with its cached response, or computes, encodes and caches it. Responses
+// covering today are never cached, since their data still changes, and neither are responses whose cache
+// generation can't be probed. Every response carries an ETag, and requests whose If-None-Match matches it are
+// answered with 304.
+func (s *Server) serveCached(w http.ResponseWriter, r *http.Request, key string, q aggregator.MetricsQuery, compute func() (any, map[string]string, error)) {
+	from, to := q.PeriodRange()
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	cacheable := !to.After(today)
+
+	useCache := cacheable && s.Cache != nil
+	generation := ""
+	if useCache {
+		var err error
+		if generation, err = s.Cache.probe(r.Context()); err != nil {
+			log.Printf("Request %s bypasses the cache: error probing cache generation: %v", requestID(w, r), err)
+			useCache = false
+		}
+	}
+
+	resp, hit := cachedResponse{}, false
+	if useCache {
+		resp, hit = s.Cache.Get(key)
+	}
+	if !hit {
+		v, header, err := compute()
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		var buf bytes.Buffer
+		if err := json.NewEncoder(&buf).Encode(v); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		resp = cachedResponse{Body: buf.Bytes(), ETag: etag(buf.Bytes()), Header: header, From: from, To: to, Generation: generation}
+		if useCache {
+			s.Cache.Put(key, resp)
+		}
+	}
+
+	w.Header().Set("ETag", resp.ETag)
+	w.Header().Set("Cache-Control", cacheControl(cacheable, s.Keys != nil))
+	for name, value := range resp.Header {
+		w.Header().Set(name, value)
+	}
+	if etagMatches(r.Header.Get("If-None-Match"), resp.ETag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(resp.Body); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// cacheControl returns the Cache-Control header of a response. Authenticated responses may only be cached by
+// the client, since they depend on the API key.
+func cacheControl(past, authenticated bool) string {
+	value := CacheControlToday
+	if past {
+		value = CacheControlPast
+	}
+	if authenticated {
+		return "private, " + value
+	}
+	return "public, " + value
+}
+
+// etag returns the strong ETag of body.
+func etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header matches tag, comparing weakly as RFC 9110 requires.
+func etagMatches(header, tag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == tag {
+			return true
+		}
+	}
+	return false
+}
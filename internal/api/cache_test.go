@@ -0,0 +1,148 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/estensen/marketplace-pipeline/internal/models"
+)
+
+func TestResponseCache(t *testing.T) {
+	apr1 := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	apr2 := time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)
+	apr3 := time.Date(2024, 4, 3, 0, 0, 0, 0, time.UTC)
+
+	cache := NewResponseCache(2)
+	cache.Put("a", cachedResponse{ETag: "a", From: apr1, To: apr2})
+	cache.Put("b", cachedResponse{ETag: "b", From: apr2, To: apr3})
+
+	// Reading a marks it as recently used, so adding c evicts b
+	_, ok := cache.Get("a")
+	require.True(t, ok)
+	cache.Put("c", cachedResponse{ETag: "c", From: apr1, To: apr3})
+	_, ok = cache.Get("b")
+	assert.False(t, ok)
+	assert.Equal(t, 2, cache.Len())
+
+	// Invalidating April 2 drops only the responses covering it
+	cache.Invalidate(apr2, apr2)
+	_, ok = cache.Get("a")
+	assert.True(t, ok)
+	_, ok = cache.Get("c")
+	assert.False(t, ok)
+}
+
+func TestCalculateMetricsHandlerCaching(t *testing.T) {
+	server, repo := newTestServer(t)
+	server.Cache = NewResponseCache(10)
+	apr2 := time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)
+
+	get := func(target, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		server.Routes().ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := get("/metrics?date=2024-04-02&project_id=4974&project_id=1609", "")
+	require.Equal(t, http.StatusOK, first.Code)
+	tag := first.Header().Get("ETag")
+	require.NotEmpty(t, tag)
+	assert.Equal(t, "public, "+CacheControlPast, first.Header().Get("Cache-Control"))
+
+	// A later load isn't visible until the day is invalidated, and the same query in another order hits the cache
+	require.NoError(t, repo.LoadAggregates(context.Background(), []models.AggregatedData{
+		{Date: apr2, ProjectID: "1609", TransactionCount: 4, TotalVolumeUSD: 8},
+	}))
+	cached := get("/metrics?project_id=1609&date=2024-04-02&project_id=4974", "")
+	require.Equal(t, http.StatusOK, cached.Code)
+	assert.Equal(t, first.Body.String(), cached.Body.String())
+	assert.Equal(t, 1, server.Cache.Len())
+
+	notModified := get("/metrics?date=2024-04-02&project_id=4974&project_id=1609", `W/"other", `+tag)
+	require.Equal(t, http.StatusNotModified, notModified.Code)
+	assert.Empty(t, notModified.Body.String())
+	assert.Equal(t, tag, notModified.Header().Get("ETag"))
+
+	server.Cache.Invalidate(apr2, apr2)
+	reloaded := get("/metrics?date=2024-04-02&project_id=4974&project_id=1609", tag)
+	require.Equal(t, http.StatusOK, reloaded.Code)
+	assert.NotEqual(t, tag, reloaded.Header().Get("ETag"))
+	assert.NotEqual(t, first.Body.String(), reloaded.Body.String())
+
+	// Ranges including today are revalidated and never cached
+	today := time.Now().UTC().Format("2006-01-02")
+	live := get("/metrics?date="+today, "")
+	require.Equal(t, http.StatusOK, live.Code)
+	assert.Equal(t, "public, "+CacheControlToday, live.Header().Get("Cache-Control"))
+	assert.NotEmpty(t, live.Header().Get("ETag"))
+	assert.Equal(t, 1, server.Cache.Len())
+}
+
+func TestResponseCacheGeneration(t *testing.T) {
+	server, repo := newTestServer(t)
+	server.Cache = NewResponseCache(10)
+	server.Cache.Generation = LedgerGeneration(repo)
+	ctx := context.Background()
+	apr2 := time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)
+
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		server.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics?date=2024-04-02", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		return rec
+	}
+
+	first := get()
+	assert.Equal(t, 1, server.Cache.Len())
+
+	// A load by another process completes after the response was cached, recording its inputs in the ledger
+	require.NoError(t, repo.LoadAggregates(ctx, []models.AggregatedData{
+		{Date: apr2, ProjectID: "1609", TransactionCount: 4, TotalVolumeUSD: 8},
+	}))
+	require.NoError(t, repo.RecordProcessedInputs(ctx, []models.ProcessedInput{
+		{URI: "data/sample.csv", SHA256: "aaa", RunID: "run-1", ProcessedAt: time.Now()},
+	}))
+
+	reloaded := get()
+	assert.NotEqual(t, first.Header().Get("ETag"), reloaded.Header().Get("ETag"))
+	assert.NotEqual(t, first.Body.String(), reloaded.Body.String())
+	assert.Equal(t, 1, server.Cache.Len())
+
+	// Responses of the new generation are cached again
+	assert.Equal(t, reloaded.Body.String(), get().Body.String())
+
+	// Responses computed before the generation changed aren't cached
+	server.Cache.Put("stale", cachedResponse{Generation: ""})
+	_, ok := server.Cache.Get("stale")
+	assert.False(t, ok)
+}
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected bool
+	}{
+		{header: `"abc"`, expected: true},
+		{header: `W/"abc"`, expected: true},
+		{header: `"xyz", "abc"`, expected: true},
+		{header: `*`, expected: true},
+		{header: `"xyz"`, expected: false},
+		{header: ``, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			assert.Equal(t, tt.expected, etagMatches(tt.header, `"abc"`))
+		})
+	}
+}
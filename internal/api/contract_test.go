@@ -0,0 +1,267 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/estensen/marketplace-pipeline/internal/models"
+)
+
+// formatPatterns validates the string formats used by the v1 DTOs.
+var formatPatterns = map[string]*regexp.Regexp{
+	"date":      regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`),
+	"date-time": regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})$`),
+}
+
+func TestV1Contract(t *testing.T) {
+	server, repo := newTestServer(t)
+	ctx := context.Background()
+	day := time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, repo.LoadTransactions(ctx, []models.Transaction{{
+		Timestamp: day.Add(9 * time.Hour),
+		Event:     "BUY_ITEMS",
+		ProjectID: "4974",
+		Props:     models.Props{CurrencySymbol: "MATIC", ChainID: "137", CollectionAddress: "0xaaa"},
+		Nums:      models.Nums{CurrencyValueDecimal: "2e18"},
+	}}))
+	require.NoError(t, repo.LoadNativeVolumes(ctx, []models.NativeVolume{
+		{Date: day, ProjectID: "4974", CurrencySymbol: "MATIC", Token: "matic-network", TransactionCount: 1, TotalVolumeNative: 2},
+	}))
+	require.NoError(t, repo.StorePrices(ctx, day, "coingecko", map[string]float64{"matic-network": 1.5}))
+
+	handler := server.V1Handler()
+	spec := fetchSpec(t, handler)
+
+	// target is the requested path of routes with path parameters
+	tests := []struct {
+		method string
+		path   string
+		target string
+		query  string
+	}{
+		{method: http.MethodGet, path: "/v1/metrics", query: "from=2024-04-01&to=2024-04-15&limit=2"},
+		{method: http.MethodGet, path: "/v1/metrics", query: "date=2024-04-02&granularity=hour"},
+		{method: http.MethodGet, path: "/v1/leaderboard", query: "date=2024-04-02&by=collection"},
+		{method: http.MethodGet, path: "/v1/leaderboard", query: "date=2024-04-02&granularity=week"},
+		{method: http.MethodGet, path: "/v1/timeseries", query: "from=2024-04-01&to=2024-04-07&transform=cumulative"},
+		{method: http.MethodPost, path: "/v1/reprice", query: "token=matic-network&from=2024-04-01&to=2024-04-02"},
+		{method: http.MethodGet, path: "/v1/prices", query: "token=matic-network&from=2024-04-01&to=2024-04-02"},
+		{method: http.MethodGet, path: "/v1/prices/latest", query: "token=matic-network"},
+		{method: http.MethodGet, path: "/v1/projects/{id}/summary", target: "/v1/projects/4974/summary", query: "period=7d&date=2024-04-07"},
+	}
+
+	covered := make(map[string]bool)
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path+"?"+tt.query, func(t *testing.T) {
+			operation := specOperation(t, spec, tt.method, tt.path)
+			covered[tt.method+" "+tt.path] = true
+			assertParametersDocumented(t, operation, tt.query)
+
+			target := tt.path
+			if tt.target != "" {
+				target = tt.target
+			}
+			req := httptest.NewRequest(tt.method, target+"?"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+			var body any
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+			schema := lookup(t, operation, "responses", "200", "content", "application/json", "schema")
+			for _, violation := range validate(spec, schema, body, "$") {
+				t.Error(violation)
+			}
+		})
+	}
+
+	errorTests := []struct {
+		method         string
+		path           string
+		query          string
+		expectedStatus int
+	}{
+		{method: http.MethodGet, path: "/v1/metrics", query: "date=2024-04-02&limit=0", expectedStatus: http.StatusBadRequest},
+		{method: http.MethodGet, path: "/v1/reprice", query: "token=matic-network", expectedStatus: http.StatusMethodNotAllowed},
+		{method: http.MethodPost, path: "/v1/reprice", query: "token=matic-network&from=2024-04-02&to=2024-04-01", expectedStatus: http.StatusBadRequest},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.method+" "+tt.path+"?"+tt.query, func(t *testing.T) {
+			// Every operation documents the same error envelope
+			operation := specOperation(t, spec, http.MethodGet, "/v1/metrics")
+
+			req := httptest.NewRequest(tt.method, tt.path+"?"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			require.Equal(t, tt.expectedStatus, rec.Code)
+
+			var body any
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+			schema := lookup(t, operation, "responses", fmt.Sprint(tt.expectedStatus), "content", "application/json", "schema")
+			for _, violation := range validate(spec, schema, body, "$") {
+				t.Error(violation)
+			}
+		})
+	}
+
+	t.Run("Unknown path", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/missing", nil))
+		require.Equal(t, http.StatusNotFound, rec.Code)
+
+		var resp ErrorResponse
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal(t, CodeNotFound, resp.Error.Code)
+	})
+
+	// Every documented operation must be exercised, and every route must be documented
+	for _, route := range server.V1Routes() {
+		assert.True(t, covered[route.Method+" "+route.Path], "no contract test for %s %s", route.Method, route.Path)
+	}
+	for path, item := range spec["paths"].(map[string]any) {
+		for method := range item.(map[string]any) {
+			assert.True(t, covered[strings.ToUpper(method)+" "+path], "no contract test for documented %s %s", method, path)
+		}
+	}
+}
+
+func TestV1ValidateDetectsDrift(t *testing.T) {
+	spec := fetchSpec(t, (&Server{}).V1Handler())
+	schema := map[string]any{"$ref": "#/components/schemas/MetricResponse"}
+
+	valid := map[string]any{"date": "2024-04-02", "project_id": "4974", "transaction_count": 1.0, "total_volume_usd": 2.5}
+	assert.Empty(t, validate(spec, schema, valid, "$"))
+
+	renamed := map[string]any{"date": "2024-04-02", "ProjectID": "4974", "transaction_count": 1.0, "total_volume_usd": 2.5}
+	assert.Len(t, validate(spec, schema, renamed, "$"), 2)
+
+	timestamp := map[string]any{"date": "2024-04-02T00:00:00Z", "project_id": "4974", "transaction_count": 1.0, "total_volume_usd": 2.5}
+	assert.Len(t, validate(spec, schema, timestamp, "$"), 1)
+}
+
+// fetchSpec retrieves and decodes the OpenAPI document served by handler.
+func fetchSpec(t *testing.T, handler http.Handler) map[string]any {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/openapi.json", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var spec map[string]any
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&spec))
+	require.Equal(t, OpenAPIVersion, spec["openapi"])
+	return spec
+}
+
+// specOperation returns the operation documented for method and path.
+func specOperation(t *testing.T, spec map[string]any, method, path string) map[string]any {
+	t.Helper()
+	return lookup(t, spec, "paths", path, strings.ToLower(method))
+}
+
+// assertParametersDocumented checks that every parameter in query is declared by the operation.
+func assertParametersDocumented(t *testing.T, operation map[string]any, query string) {
+	t.Helper()
+	documented := make(map[string]bool)
+	params, _ := operation["parameters"].([]any)
+	for _, p := range params {
+		documented[p.(map[string]any)["name"].(string)] = true
+	}
+	for _, pair := range strings.Split(query, "&") {
+		name, _, _ := strings.Cut(pair, "=")
+		assert.True(t, documented[name], "parameter %s is not documented", name)
+	}
+}
+
+// lookup follows keys through nested JSON objects.
+func lookup(t *testing.T, v map[string]any, keys ...string) map[string]any {
+	t.Helper()
+	for _, key := range keys {
+		next, ok := v[key].(map[string]any)
+		require.True(t, ok, "missing %s in %v", key, keys)
+		v = next
+	}
+	return v
+}
+
+// validate checks value against the subset of JSON schema emitted by OpenAPI and returns every violation.
+func validate(spec map[string]any, schema map[string]any, value any, path string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		resolved, ok := spec["components"].(map[string]any)["schemas"].(map[string]any)[name].(map[string]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: unresolved reference %s", path, ref)}
+		}
+		return validate(spec, resolved, value, path)
+	}
+
+	var violations []string
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected object, got %T", path, value)}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, exists := object[name.(string)]; !exists {
+				violations = append(violations, fmt.Sprintf("%s: missing required property %s", path, name))
+			}
+		}
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			property, documented := properties[key].(map[string]any)
+			if !documented {
+				violations = append(violations, fmt.Sprintf("%s: undocumented property %s", path, key))
+				continue
+			}
+			violations = append(violations, validate(spec, property, object[key], path+"."+key)...)
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected array, got %T", path, value)}
+		}
+		for i, item := range items {
+			violations = append(violations, validate(spec, schema["items"].(map[string]any), item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected string, got %T", path, value)}
+		}
+		if pattern, ok := formatPatterns[fmt.Sprint(schema["format"])]; ok && !pattern.MatchString(s) {
+			violations = append(violations, fmt.Sprintf("%s: %q is not a %s", path, s, schema["format"]))
+		}
+	case "integer":
+		n, ok := value.(float64)
+		if !ok || n != float64(int64(n)) {
+			return []string{fmt.Sprintf("%s: expected integer, got %v", path, value)}
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return []string{fmt.Sprintf("%s: expected number, got %T", path, value)}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{fmt.Sprintf("%s: expected boolean, got %T", path, value)}
+		}
+	}
+	return violations
+}
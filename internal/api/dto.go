@@ -0,0 +1,263 @@
+package api
+
+import (
+	"time"
+
+	"github.com/estensen/marketplace-pipeline/internal/aggregator"
+	"github.com/estensen/marketplace-pipeline/internal/database"
+	"github.com/estensen/marketplace-pipeline/internal/models"
+)
+
+// The v1 response DTOs fix the JSON schema of the API independently of the models.
+// Their json tags name the fields and their format tags feed the OpenAPI document.
+
+// MetricResponse is the metrics of one project over one period.
+// Hour is only set for hourly granularity.
+type MetricResponse struct {
+	Date             string  `json:"date" format:"date"`
+	Hour             *int    `json:"hour,omitempty"`
+	ProjectID        string  `json:"project_id"`
+	TransactionCount uint64  `json:"transaction_count"`
+	TotalVolumeUSD   float64 `json:"total_volume_usd"`
+}
+
+// MetricsResponse is a page of metrics. NextCursor is empty on the last page.
+type MetricsResponse struct {
+	Data       []MetricResponse `json:"data"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// LeaderboardEntryResponse is the rank of a project or collection by USD volume.
+type LeaderboardEntryResponse struct {
+	Rank             int     `json:"rank"`
+	PreviousRank     int     `json:"previous_rank"`
+	RankChange       int     `json:"rank_change"`
+	ID               string  `json:"id"`
+	TransactionCount uint64  `json:"transaction_count"`
+	TotalVolumeUSD   float64 `json:"total_volume_usd"`
+}
+
+// LeaderboardResponse lists the top projects or collections.
+type LeaderboardResponse struct {
+	By   string                     `json:"by"`
+	Data []LeaderboardEntryResponse `json:"data"`
+}
+
+// TimeSeriesPointResponse is one point of a time series.
+type TimeSeriesPointResponse struct {
+	Timestamp string  `json:"timestamp" format:"date-time"`
+	Value     float64 `json:"value"`
+}
+
+// TimeSeriesResponse is a gap-filled time series.
+type TimeSeriesResponse struct {
+	Metric      string                    `json:"metric"`
+	Granularity string                    `json:"granularity"`
+	Data        []TimeSeriesPointResponse `json:"data"`
+}
+
+// RepriceAuditResponse is the before and after values of a repriced analytics row.
+type RepriceAuditResponse struct {
+	RepricedAt          string  `json:"repriced_at" format:"date-time"`
+	Token               string  `json:"token"`
+	Date                string  `json:"date" format:"date"`
+	ProjectID           string  `json:"project_id"`
+	OldTransactionCount uint64  `json:"old_transaction_count"`
+	NewTransactionCount uint64  `json:"new_transaction_count"`
+	OldVolumeUSD        float64 `json:"old_volume_usd"`
+	NewVolumeUSD        float64 `json:"new_volume_usd"`
+}
+
+// RepriceResponse lists the analytics rows changed by a reprice.
+type RepriceResponse struct {
+	Data []RepriceAuditResponse `json:"data"`
+}
+
+// PriceResponse is the USD price of a token on one day, with where and when it was fetched.
+type PriceResponse struct {
+	Token     string  `json:"token"`
+	Date      string  `json:"date" format:"date"`
+	PriceUSD  float64 `json:"price_usd"`
+	Source    string  `json:"source"`
+	FetchedAt string  `json:"fetched_at" format:"date-time"`
+}
+
+// PricesResponse lists token prices.
+type PricesResponse struct {
+	Data []PriceResponse `json:"data"`
+}
+
+// BreakdownEntryResponse is the share of a currency or event type in a period.
+type BreakdownEntryResponse struct {
+	Key              string  `json:"key"`
+	TransactionCount uint64  `json:"transaction_count"`
+	TotalVolumeUSD   float64 `json:"total_volume_usd"`
+}
+
+// PeriodSummaryResponse is the activity of a project over one period, broken down by currency and event type.
+// BreakdownsMatch is false when the breakdowns don't add up to the totals, since some days have analytics
+// but no priced raw transactions.
+type PeriodSummaryResponse struct {
+	From             string                   `json:"from" format:"date"`
+	To               string                   `json:"to" format:"date"`
+	TransactionCount uint64                   `json:"transaction_count"`
+	TotalVolumeUSD   float64                  `json:"total_volume_usd"`
+	UniqueTraders    uint64                   `json:"unique_traders"`
+	AverageTicketUSD float64                  `json:"average_ticket_usd"`
+	Currencies       []BreakdownEntryResponse `json:"currencies"`
+	Events           []BreakdownEntryResponse `json:"events"`
+	BreakdownsMatch  bool                     `json:"breakdowns_match"`
+}
+
+// ChangeResponse is the change of a metric from the previous period. Percent is omitted when the previous
+// value is zero.
+type ChangeResponse struct {
+	Absolute float64  `json:"absolute"`
+	Percent  *float64 `json:"percent,omitempty"`
+}
+
+// SummaryChangeResponse is the change of each summary metric from the previous period.
+type SummaryChangeResponse struct {
+	TransactionCount ChangeResponse `json:"transaction_count"`
+	TotalVolumeUSD   ChangeResponse `json:"total_volume_usd"`
+	UniqueTraders    ChangeResponse `json:"unique_traders"`
+	AverageTicketUSD ChangeResponse `json:"average_ticket_usd"`
+}
+
+// ProjectSummaryResponse compares a project's activity over a period with the preceding period of the same length.
+type ProjectSummaryResponse struct {
+	ProjectID string                `json:"project_id"`
+	Current   PeriodSummaryResponse `json:"current"`
+	Previous  PeriodSummaryResponse `json:"previous"`
+	Change    SummaryChangeResponse `json:"change"`
+}
+
+// newMetricsResponse converts a page of metrics.
+func newMetricsResponse(metrics []models.AggregatedData, granularity database.Granularity, next string) MetricsResponse {
+	resp := MetricsResponse{Data: make([]MetricResponse, 0, len(metrics)), NextCursor: next}
+	for _, m := range metrics {
+		resp.Data = append(resp.Data, newMetricResponse(m, granularity))
+	}
+	return resp
+}
+
+// newMetricResponse converts the metrics of one project over one period.
+func newMetricResponse(m models.AggregatedData, granularity database.Granularity) MetricResponse {
+	metric := MetricResponse{
+		Date:             m.Date.Format("2006-01-02"),
+		ProjectID:        m.ProjectID,
+		TransactionCount: m.TransactionCount,
+		TotalVolumeUSD:   m.TotalVolumeUSD,
+	}
+	if !granularity.HasRollup() {
+		hour := m.Date.Hour()
+		metric.Hour = &hour
+	}
+	return metric
+}
+
+// newLeaderboardResponse converts leaderboard entries.
+func newLeaderboardResponse(by aggregator.Dimension, entries []models.LeaderboardEntry) LeaderboardResponse {
+	resp := LeaderboardResponse{By: string(by), Data: make([]LeaderboardEntryResponse, 0, len(entries))}
+	for _, e := range entries {
+		resp.Data = append(resp.Data, LeaderboardEntryResponse{
+			Rank:             e.Rank,
+			PreviousRank:     e.PreviousRank,
+			RankChange:       e.RankChange,
+			ID:               e.ID,
+			TransactionCount: e.TransactionCount,
+			TotalVolumeUSD:   e.TotalVolumeUSD,
+		})
+	}
+	return resp
+}
+
+// newTimeSeriesResponse converts time-series points.
+func newTimeSeriesResponse(q aggregator.TimeSeriesQuery, points []models.TimeSeriesPoint) TimeSeriesResponse {
+	resp := TimeSeriesResponse{
+		Metric:      string(q.Metric),
+		Granularity: string(q.Metrics.Granularity),
+		Data:        make([]TimeSeriesPointResponse, 0, len(points)),
+	}
+	for _, p := range points {
+		resp.Data = append(resp.Data, newTimeSeriesPointResponse(p))
+	}
+	return resp
+}
+
+// newTimeSeriesPointResponse converts one time-series point.
+func newTimeSeriesPointResponse(p models.TimeSeriesPoint) TimeSeriesPointResponse {
+	return TimeSeriesPointResponse{Timestamp: p.Timestamp.UTC().Format(time.RFC3339), Value: p.Value}
+}
+
+// newPricesResponse converts token prices.
+func newPricesResponse(prices []models.TokenPrice) PricesResponse {
+	resp := PricesResponse{Data: make([]PriceResponse, 0, len(prices))}
+	for _, p := range prices {
+		resp.Data = append(resp.Data, PriceResponse{
+			Token:     p.Token,
+			Date:      p.Date.Format("2006-01-02"),
+			PriceUSD:  p.AveragePriceUSD,
+			Source:    p.Source,
+			FetchedAt: p.FetchedAt.UTC().Format(time.RFC3339),
+		})
+	}
+	return resp
+}
+
+// newProjectSummaryResponse converts a project summary.
+func newProjectSummaryResponse(s models.ProjectSummary) ProjectSummaryResponse {
+	return ProjectSummaryResponse{
+		ProjectID: s.ProjectID,
+		Current:   newPeriodSummaryResponse(s.Current),
+		Previous:  newPeriodSummaryResponse(s.Previous),
+		Change: SummaryChangeResponse{
+			TransactionCount: ChangeResponse(s.Change.TransactionCount),
+			TotalVolumeUSD:   ChangeResponse(s.Change.TotalVolumeUSD),
+			UniqueTraders:    ChangeResponse(s.Change.UniqueTraders),
+			AverageTicketUSD: ChangeResponse(s.Change.AverageTicketUSD),
+		},
+	}
+}
+
+// newPeriodSummaryResponse converts the summary of one period.
+func newPeriodSummaryResponse(p models.PeriodSummary) PeriodSummaryResponse {
+	return PeriodSummaryResponse{
+		From:             p.From.Format("2006-01-02"),
+		To:               p.To.Format("2006-01-02"),
+		TransactionCount: p.TransactionCount,
+		TotalVolumeUSD:   p.TotalVolumeUSD,
+		UniqueTraders:    p.UniqueTraders,
+		AverageTicketUSD: p.AverageTicketUSD,
+		Currencies:       newBreakdownResponse(p.Currencies),
+		Events:           newBreakdownResponse(p.Events),
+		BreakdownsMatch:  p.BreakdownsMatch,
+	}
+}
+
+// newBreakdownResponse converts breakdown entries.
+func newBreakdownResponse(entries []models.BreakdownEntry) []BreakdownEntryResponse {
+	resp := make([]BreakdownEntryResponse, 0, len(entries))
+	for _, e := range entries {
+		resp = append(resp, BreakdownEntryResponse(e))
+	}
+	return resp
+}
+
+// newRepriceResponse converts reprice audit entries.
+func newRepriceResponse(entries []models.RepriceAudit) RepriceResponse {
+	resp := RepriceResponse{Data: make([]RepriceAuditResponse, 0, len(entries))}
+	for _, e := range entries {
+		resp.Data = append(resp.Data, RepriceAuditResponse{
+			RepricedAt:          e.RepricedAt.UTC().Format(time.RFC3339),
+			Token:               e.Token,
+			Date:                e.Date.Format("2006-01-02"),
+			ProjectID:           e.ProjectID,
+			OldTransactionCount: e.OldTransactionCount,
+			NewTransactionCount: e.NewTransactionCount,
+			OldVolumeUSD:        e.OldVolumeUSD,
+			NewVolumeUSD:        e.NewVolumeUSD,
+		})
+	}
+	return resp
+}
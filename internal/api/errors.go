@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+
+	"github.com/google/uuid"
+
+	"github.com/estensen/marketplace-pipeline/internal/aggregator"
+	"github.com/estensen/marketplace-pipeline/internal/database"
+	"github.com/estensen/marketplace-pipeline/internal/reprice"
+)
+
+// Stable error codes of the JSON error envelope. Clients may switch on them, so they must never change.
+const (
+	CodeInvalidParameter = "invalid_parameter"
+	CodeInvalidRange     = "invalid_range"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeRateLimited      = "rate_limited"
+	CodeTimeout          = "timeout"
+	CodePartialFailure   = "partial_failure"
+	CodeInternal         = "internal_error"
+)
+
+// RequestIDHeader carries the ID correlating a request with its error responses and logs.
+const RequestIDHeader = "X-Request-ID"
+
+var (
+	// ErrNotFound is returned for paths without a handler.
+	ErrNotFound = errors.New("not found")
+	// ErrMethodNotAllowed is returned for paths that exist with another method.
+	ErrMethodNotAllowed = errors.New("method not allowed")
+	// ErrUnauthorized is returned for requests without a valid API key.
+	ErrUnauthorized = errors.New("missing or invalid API key")
+	// ErrForbidden is returned when the API key is not allowed to read the requested data.
+	ErrForbidden = errors.New("forbidden")
+	// ErrRateLimited is returned when the API key exhausted its rate limit.
+	ErrRateLimited = errors.New("rate limit exceeded")
+)
+
+// requestIDPattern restricts the client-supplied request IDs that are echoed back.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9_\-.]{1,128}$`)
+
+// ErrorResponse is the JSON body of every error response.
+type ErrorResponse struct {
+	Error ErrorDetail `json:"error"`
+}
+
+// ErrorDetail describes an error. Details lists the invalid request parameters, if any.
+type ErrorDetail struct {
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	RequestID string       `json:"request_id"`
+	Details   []FieldError `json:"details,omitempty"`
+}
+
+// FieldError is a validation error of a single request parameter.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error implements the error interface.
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: '%s' %s", ErrInvalidQuery, e.Field, e.Message)
+}
+
+// Unwrap makes every FieldError match ErrInvalidQuery.
+func (e *FieldError) Unwrap() error {
+	return ErrInvalidQuery
+}
+
+// invalidParameter returns a validation error for the named parameter.
+func invalidParameter(field, format string, args ...any) error {
+	return &FieldError{Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+// requestIDKey is the context key of the request ID.
+type requestIDKey struct{}
+
+// RequestID assigns every request an ID, reusing a valid X-Request-ID header, and echoes it in the response.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestID returns the ID assigned by RequestID, assigning one when the handler is called without it.
+func requestID(w http.ResponseWriter, r *http.Request) string {
+	if id, ok := r.Context().Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	id := uuid.NewString()
+	w.Header().Set(RequestIDHeader, id)
+	return id
+}
+
+// writeError responds with the JSON error envelope matching err. Server errors are logged
+// with the request ID and answered with a generic message.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status, detail := classifyError(err)
+	detail.RequestID = requestID(w, r)
+	if status >= http.StatusInternalServerError {
+		log.Printf("Request %s failed: %v", detail.RequestID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(ErrorResponse{Error: detail}); err != nil {
+		log.Printf("Error encoding error response: %v", err)
+	}
+}
+
+// classifyError maps err to an HTTP status and a stable error code.
+func classifyError(err error) (int, ErrorDetail) {
+	var fieldErr *FieldError
+	switch {
+	case errors.As(err, &fieldErr):
+		return http.StatusBadRequest, ErrorDetail{
+			Code:    CodeInvalidParameter,
+			Message: fmt.Sprintf("Invalid '%s' parameter: %s.", fieldErr.Field, fieldErr.Message),
+			Details: []FieldError{*fieldErr},
+		}
+	case errors.Is(err, reprice.ErrInvalidRange):
+		return http.StatusBadRequest, ErrorDetail{
+			Code:    CodeInvalidRange,
+			Message: "Invalid date range.",
+			Details: []FieldError{{Field: "to", Message: "must not be before 'from'"}},
+		}
+	case errors.Is(err, ErrInvalidQuery), errors.Is(err, database.ErrInvalidGranularity),
+		errors.Is(err, aggregator.ErrInvalidDimension), errors.Is(err, aggregator.ErrInvalidMetric),
+		errors.Is(err, aggregator.ErrInvalidTransform):
+		return http.StatusBadRequest, ErrorDetail{Code: CodeInvalidParameter, Message: "Invalid request parameters."}
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound, ErrorDetail{Code: CodeNotFound, Message: "Not found."}
+	case errors.Is(err, ErrMethodNotAllowed):
+		return http.StatusMethodNotAllowed, ErrorDetail{Code: CodeMethodNotAllowed, Message: "Method not allowed."}
+	case errors.Is(err, ErrUnauthorized):
+		return http.StatusUnauthorized, ErrorDetail{Code: CodeUnauthorized, Message: "A valid API key is required."}
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden, ErrorDetail{Code: CodeForbidden, Message: "The API key is not allowed to access this data."}
+	case errors.Is(err, ErrRateLimited):
+		return http.StatusTooManyRequests, ErrorDetail{Code: CodeRateLimited, Message: "Rate limit exceeded, retry later."}
+	case errors.Is(err, database.ErrPartialReplace):
+		return http.StatusServiceUnavailable, ErrorDetail{Code: CodePartialFailure, Message: "The change was partially applied, retry the request."}
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, ErrorDetail{Code: CodeTimeout, Message: "The request timed out."}
+	default:
+		return http.StatusInternalServerError, ErrorDetail{Code: CodeInternal, Message: "Internal server error."}
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/estensen/marketplace-pipeline/internal/database"
+	"github.com/estensen/marketplace-pipeline/internal/reprice"
+)
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		expectedStatus int
+		expectedCode   string
+		expectedField  string
+	}{
+		{name: "Field error", err: invalidParameter("limit", "must be between 1 and %d", 10), expectedStatus: http.StatusBadRequest, expectedCode: CodeInvalidParameter, expectedField: "limit"},
+		{name: "Reprice range", err: fmt.Errorf("error repricing analytics: %w", reprice.ErrInvalidRange), expectedStatus: http.StatusBadRequest, expectedCode: CodeInvalidRange, expectedField: "to"},
+		{name: "Not found", err: ErrNotFound, expectedStatus: http.StatusNotFound, expectedCode: CodeNotFound},
+		{name: "Method not allowed", err: ErrMethodNotAllowed, expectedStatus: http.StatusMethodNotAllowed, expectedCode: CodeMethodNotAllowed},
+		{name: "Timeout", err: fmt.Errorf("error fetching metrics: %w", context.DeadlineExceeded), expectedStatus: http.StatusGatewayTimeout, expectedCode: CodeTimeout},
+		{name: "Partial replace", err: fmt.Errorf("error repricing analytics: %w", database.ErrPartialReplace), expectedStatus: http.StatusServiceUnavailable, expectedCode: CodePartialFailure},
+		{name: "Internal", err: errors.New("connection refused"), expectedStatus: http.StatusInternalServerError, expectedCode: CodeInternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			writeError(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil), tt.err)
+
+			require.Equal(t, tt.expectedStatus, rec.Code)
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+			var resp ErrorResponse
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal(t, tt.expectedCode, resp.Error.Code)
+			assert.NotEmpty(t, resp.Error.Message)
+			assert.NotContains(t, resp.Error.Message, "connection refused")
+			assert.Equal(t, rec.Header().Get(RequestIDHeader), resp.Error.RequestID)
+			if tt.expectedField == "" {
+				assert.Empty(t, resp.Error.Details)
+				return
+			}
+			require.Len(t, resp.Error.Details, 1)
+			assert.Equal(t, tt.expectedField, resp.Error.Details[0].Field)
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, ErrNotFound)
+	}))
+
+	tests := []struct {
+		name     string
+		header   string
+		expected string
+	}{
+		{name: "Client ID is reused", header: "req-123", expected: "req-123"},
+		{name: "Invalid ID is replaced", header: "bad id\n"},
+		{name: "Missing ID is generated"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/missing", nil)
+			if tt.header != "" {
+				req.Header.Set(RequestIDHeader, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			var resp ErrorResponse
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+			id := rec.Header().Get(RequestIDHeader)
+			assert.Equal(t, id, resp.Error.RequestID)
+			if tt.expected != "" {
+				assert.Equal(t, tt.expected, id)
+			} else {
+				assert.NotEqual(t, tt.header, id)
+				assert.Len(t, id, 36)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/estensen/marketplace-pipeline/internal/aggregator"
+	"github.com/estensen/marketplace-pipeline/internal/models"
+)
+
+// Response formats of /metrics and /timeseries, selected with the format parameter or the Accept header.
+const (
+	FormatJSON   = "json"
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// Media types of the export formats.
+const (
+	MediaTypeCSV    = "text/csv"
+	MediaTypeNDJSON = "application/x-ndjson"
+)
+
+// exportFlushRows is the number of rows written between flushes, so clients receive large exports progressively.
+const exportFlushRows = 500
+
+// paginationParameters only apply to JSON responses. Exports always stream the whole range in date order.
+var paginationParameters = []string{"sort", "order", "limit", "cursor"}
+
+// negotiateFormat returns the response format of r, rejecting the JSON-only parameters when exporting. Responses
+// vary by Accept, since it may select the format.
+func negotiateFormat(w http.ResponseWriter, r *http.Request, jsonOnly ...string) (string, error) {
+	w.Header().Add("Vary", "Accept")
+	format, err := parseFormat(r)
+	if err != nil || format == FormatJSON {
+		return format, err
+	}
+	for _, param := range jsonOnly {
+		if r.URL.Query().Has(param) {
+			return "", invalidParameter(param, "is not supported with the %s format", format)
+		}
+	}
+	return format, nil
+}
+
+// parseFormat returns the format selected with the format parameter, falling back to the first supported media
+// type of the Accept header, and to JSON.
+func parseFormat(r *http.Request) (string, error) {
+	switch format := r.URL.Query().Get("format"); format {
+	case FormatJSON, FormatCSV, FormatNDJSON:
+		return format, nil
+	case "":
+	default:
+		return "", invalidParameter("format", "must be json, csv or ndjson")
+	}
+
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(accepted, ";")
+		switch strings.TrimSpace(mediaType) {
+		case "application/json":
+			return FormatJSON, nil
+		case MediaTypeCSV:
+			return FormatCSV, nil
+		case MediaTypeNDJSON:
+			return FormatNDJSON, nil
+		}
+	}
+	return FormatJSON, nil
+}
+
+// exporter writes the rows of an export to the response as they are produced.
+type exporter struct {
+	w      http.ResponseWriter
+	csv    *csv.Writer
+	ndjson *json.Encoder
+	rows   int
+}
+
+// newExporter starts an export named name, writing the CSV header row when format is csv.
+func newExporter(w http.ResponseWriter, format, name string, header []string) (*exporter, error) {
+	e := &exporter{w: w}
+	if format == FormatNDJSON {
+		w.Header().Set("Content-Type", MediaTypeNDJSON)
+		e.ndjson = json.NewEncoder(w)
+		return e, nil
+	}
+
+	w.Header().Set("Content-Type", MediaTypeCSV+"; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".csv"))
+	e.csv = csv.NewWriter(w)
+	return e, e.csv.Write(header)
+}
+
+// write adds a row, as record in CSV or as v encoded as a JSON line.
+func (e *exporter) write(record []string, v any) error {
+	var err error
+	if e.csv != nil {
+		err = e.csv.Write(record)
+	} else {
+		err = e.ndjson.Encode(v)
+	}
+	if err != nil {
+		return err
+	}
+
+	e.rows++
+	if e.rows%exportFlushRows == 0 {
+		return e.flush()
+	}
+	return nil
+}
+
+// flush sends the rows written so far to the client.
+func (e *exporter) flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if err := http.NewResponseController(e.w).Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
+
+// exportMetrics streams the metrics matching q. The export only starts with the first row, so errors of the
+// query are still answered with the JSON error envelope; later errors can only cut the export short.
+func (s *Server) exportMetrics(w http.ResponseWriter, r *http.Request, format string, q aggregator.MetricsQuery) {
+	hourly := !q.Granularity.HasRollup()
+	header := []string{"date", "project_id", "transaction_count", "total_volume_usd"}
+	if hourly {
+		header = []string{"date", "hour", "project_id", "transaction_count", "total_volume_usd"}
+	}
+
+	var e *exporter
+	start := func() error {
+		if e != nil {
+			return nil
+		}
+		var err error
+		e, err = newExporter(w, format, "metrics", header)
+		return err
+	}
+
+	err := s.Aggregator.StreamMetrics(r.Context(), s.Metrics, s.Prices, q, func(m models.AggregatedData) error {
+		if err := start(); err != nil {
+			return err
+		}
+		metric := newMetricResponse(m, q.Granularity)
+		record := []string{metric.Date}
+		if hourly {
+			record = append(record, strconv.Itoa(*metric.Hour))
+		}
+		record = append(record, metric.ProjectID, strconv.FormatUint(metric.TransactionCount, 10), formatFloat(metric.TotalVolumeUSD))
+		return e.write(record, metric)
+	})
+	if err == nil {
+		err = start()
+	}
+	if err != nil {
+		err = fmt.Errorf("error exporting metrics: %w", err)
+	}
+	s.finishExport(w, r, e, err)
+}
+
+// exportTimeSeries writes the points of a time series.
+func (s *Server) exportTimeSeries(w http.ResponseWriter, r *http.Request, format string, points []models.TimeSeriesPoint) {
+	e, err := newExporter(w, format, "timeseries", []string{"timestamp", "value"})
+	for _, p := range points {
+		if err != nil {
+			break
+		}
+		point := newTimeSeriesPointResponse(p)
+		err = e.write([]string{point.Timestamp, formatFloat(point.Value)}, point)
+	}
+	if err != nil {
+		err = fmt.Errorf("error exporting time series: %w", err)
+	}
+	s.finishExport(w, r, e, err)
+}
+
+// finishExport flushes a completed export, or reports the error that interrupted it. Without a started export,
+// the error is answered with the JSON error envelope.
+func (s *Server) finishExport(w http.ResponseWriter, r *http.Request, e *exporter, err error) {
+	switch {
+	case err == nil:
+		if err := e.flush(); err != nil {
+			log.Printf("Request %s failed: error flushing export: %v", requestID(w, r), err)
+		}
+	case e == nil:
+		writeError(w, r, err)
+	default:
+		log.Printf("Request %s failed: %v", requestID(w, r), err)
+	}
+}
+
+// formatFloat formats v with the fewest digits that represent it exactly.
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/estensen/marketplace-pipeline/internal/models"
+)
+
+func TestExport(t *testing.T) {
+	server, repo := newTestServer(t)
+	ctx := context.Background()
+	day := time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, repo.LoadTransactions(ctx, []models.Transaction{{
+		Timestamp: day.Add(9 * time.Hour),
+		Event:     "BUY_ITEMS",
+		ProjectID: "4974",
+		Props:     models.Props{CurrencySymbol: "MATIC", TxnHash: "0x1"},
+		Nums:      models.Nums{CurrencyValueDecimal: "2e18"},
+	}}))
+	require.NoError(t, repo.LoadNativeVolumes(ctx, []models.NativeVolume{
+		{Date: day, ProjectID: "4974", CurrencySymbol: "MATIC", Token: "matic-network", TransactionCount: 1, TotalVolumeNative: 2},
+	}))
+	require.NoError(t, repo.StorePrices(ctx, day, "coingecko", map[string]float64{"matic-network": 1.5}))
+
+	tests := []struct {
+		name           string
+		target         string
+		accept         string
+		expectedStatus int
+		expectedType   string
+		expected       string
+	}{
+		{
+			name:           "Metrics CSV",
+			target:         "/metrics?from=2024-04-01&to=2024-04-02&format=csv",
+			expectedStatus: http.StatusOK,
+			expectedType:   MediaTypeCSV + "; charset=utf-8",
+			expected: "date,project_id,transaction_count,total_volume_usd\n" +
+				"2024-04-01,4974,5,10\n" +
+				"2024-04-02,1609,1,2\n" +
+				"2024-04-02,4974,3,6\n",
+		},
+		{
+			name:           "Metrics NDJSON negotiated with Accept",
+			target:         "/metrics?date=2024-04-02",
+			accept:         "application/x-ndjson, application/json;q=0.5",
+			expectedStatus: http.StatusOK,
+			expectedType:   MediaTypeNDJSON,
+			expected: `{"date":"2024-04-02","project_id":"1609","transaction_count":1,"total_volume_usd":2}` + "\n" +
+				`{"date":"2024-04-02","project_id":"4974","transaction_count":3,"total_volume_usd":6}` + "\n",
+		},
+		{
+			name:           "Format parameter overrides Accept",
+			target:         "/metrics?date=2024-04-02&format=json",
+			accept:         MediaTypeCSV,
+			expectedStatus: http.StatusOK,
+			expectedType:   "application/json",
+		},
+		{
+			name:           "Empty range has only the header",
+			target:         "/metrics?date=2024-05-01&format=csv",
+			expectedStatus: http.StatusOK,
+			expectedType:   MediaTypeCSV + "; charset=utf-8",
+			expected:       "date,project_id,transaction_count,total_volume_usd\n",
+		},
+		{
+			name:           "Hourly CSV",
+			target:         "/metrics?date=2024-04-02&granularity=hour&format=csv",
+			expectedStatus: http.StatusOK,
+			expectedType:   MediaTypeCSV + "; charset=utf-8",
+			expected: "date,hour,project_id,transaction_count,total_volume_usd\n" +
+				"2024-04-02,9,4974,1,3\n",
+		},
+		{
+			name:           "Time series CSV",
+			target:         "/timeseries?project_id=4974&from=2024-04-01&to=2024-04-03&format=csv",
+			expectedStatus: http.StatusOK,
+			expectedType:   MediaTypeCSV + "; charset=utf-8",
+			expected: "timestamp,value\n" +
+				"2024-04-01T00:00:00Z,10\n" +
+				"2024-04-02T00:00:00Z,6\n" +
+				"2024-04-03T00:00:00Z,0\n",
+		},
+		{
+			name:           "Versioned time series NDJSON",
+			target:         "/v1/timeseries?project_id=4974&from=2024-04-01&to=2024-04-01&format=ndjson",
+			expectedStatus: http.StatusOK,
+			expectedType:   MediaTypeNDJSON,
+			expected:       `{"timestamp":"2024-04-01T00:00:00Z","value":10}` + "\n",
+		},
+		{
+			name:           "Invalid format",
+			target:         "/metrics?date=2024-04-02&format=xml",
+			expectedStatus: http.StatusBadRequest,
+			expectedType:   "application/json",
+		},
+		{
+			name:           "Pagination with export",
+			target:         "/v1/metrics?date=2024-04-02&format=csv&limit=1",
+			expectedStatus: http.StatusBadRequest,
+			expectedType:   "application/json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+
+			server.Routes().ServeHTTP(rec, req)
+
+			require.Equal(t, tt.expectedStatus, rec.Code, rec.Body.String())
+			assert.Equal(t, tt.expectedType, rec.Header().Get("Content-Type"))
+			assert.Contains(t, rec.Header().Values("Vary"), "Accept")
+			if tt.expected != "" {
+				assert.Equal(t, tt.expected, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestExportLargeRange(t *testing.T) {
+	server, repo := newTestServer(t)
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	var data []models.AggregatedData
+	for day := 0; day < 365; day++ {
+		for _, projectID := range []string{"1", "2"} {
+			data = append(data, models.AggregatedData{Date: start.AddDate(0, 0, day), ProjectID: projectID, TransactionCount: 1, TotalVolumeUSD: 1})
+		}
+	}
+	require.NoError(t, repo.LoadAggregates(context.Background(), data))
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics?from=2023-01-01&to=2023-12-31&format=ndjson", nil)
+	rec := httptest.NewRecorder()
+	server.Routes().ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	require.Len(t, lines, len(data))
+	var last MetricResponse
+	require.NoError(t, json.Unmarshal([]byte(lines[len(lines)-1]), &last))
+	assert.Equal(t, "2023-12-31", last.Date)
+	assert.Equal(t, "2", last.ProjectID)
+}
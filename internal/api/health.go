@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/estensen/marketplace-pipeline/internal/buildinfo"
+	"github.com/estensen/marketplace-pipeline/internal/models"
+)
+
+// ReadinessTimeout bounds each readiness check.
+const ReadinessTimeout = 2 * time.Second
+
+// Check is a named readiness check of a dependency.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// HealthResponse is the body of /healthz.
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+// CheckResult is the outcome of one readiness check. The probe is unauthenticated,
+// so failures are only logged and never described in the response.
+type CheckResult struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// ReadinessResponse is the body of /readyz.
+type ReadinessResponse struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks"`
+}
+
+// RunHistory reports the last completed pipeline run.
+type RunHistory interface {
+	LastRun() (models.PipelineRun, bool)
+}
+
+// PipelineRunResponse identifies the last completed pipeline run. Result is "success", or "skipped" when every
+// input was already processed.
+type PipelineRunResponse struct {
+	RunID       string `json:"run_id"`
+	URI         string `json:"uri"`
+	Result      string `json:"result"`
+	CompletedAt string `json:"completed_at" format:"date-time"`
+}
+
+// VersionResponse is the body of /version. LastPipelineRun is omitted until the pipeline has completed a run.
+type VersionResponse struct {
+	Version         string               `json:"version"`
+	GitSHA          string               `json:"git_sha"`
+	BuildTime       string               `json:"build_time"`
+	GoVersion       string               `json:"go_version"`
+	LastPipelineRun *PipelineRunResponse `json:"last_pipeline_run,omitempty"`
+}
+
+// HealthHandler handles the /healthz liveness probe. It answers as long as the process serves requests.
+func (s *Server) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, HealthResponse{Status: "ok"})
+}
+
+// ReadinessHandler handles the /readyz readiness probe, running every check concurrently.
+// It answers 503 when any check fails.
+func (s *Server) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	resp := ReadinessResponse{Status: "ready", Checks: make([]CheckResult, len(s.Checks))}
+	id := requestID(w, r)
+
+	var wg sync.WaitGroup
+	for i, check := range s.Checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp.Checks[i] = runCheck(r.Context(), id, check)
+		}()
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	for _, result := range resp.Checks {
+		if result.Status != "ok" {
+			resp.Status = "not_ready"
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSONStatus(w, status, resp)
+}
+
+// runCheck runs check with ReadinessTimeout, logging a failure under the request ID.
+func runCheck(ctx context.Context, requestID string, check Check) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, ReadinessTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.Run(ctx)
+	result := CheckResult{Name: check.Name, Status: "ok", DurationMS: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Status = "error"
+		log.Printf("Request %s readiness check %s failed: %v", requestID, check.Name, err)
+	}
+	return result
+}
+
+// VersionHandler handles the /version endpoint with the build info and the last completed pipeline run.
+func (s *Server) VersionHandler(w http.ResponseWriter, r *http.Request) {
+	info := buildinfo.Get()
+	resp := VersionResponse{
+		Version:   info.Version,
+		GitSHA:    info.GitSHA,
+		BuildTime: info.BuildTime,
+		GoVersion: info.GoVersion,
+	}
+
+	if s.Runs != nil {
+		if last, found := s.Runs.LastRun(); found {
+			resp.LastPipelineRun = &PipelineRunResponse{
+				RunID:       last.RunID,
+				URI:         last.URI,
+				Result:      last.Result,
+				CompletedAt: last.CompletedAt.UTC().Format(time.RFC3339),
+			}
+		}
+	}
+
+	writeJSON(w, resp)
+}
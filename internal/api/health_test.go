@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/estensen/marketplace-pipeline/internal/models"
+)
+
+func TestHealthHandler(t *testing.T) {
+	server, _ := newTestServer(t)
+	rec := httptest.NewRecorder()
+	server.HealthHandler(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, rec.Body.String())
+}
+
+func TestReadinessHandler(t *testing.T) {
+	ok := Check{Name: "clickhouse", Run: func(ctx context.Context) error { return nil }}
+	failing := Check{Name: "schema", Run: func(ctx context.Context) error { return errors.New("version 5, latest 6") }}
+	bounded := Check{Name: "minio", Run: func(ctx context.Context) error {
+		if _, ok := ctx.Deadline(); !ok {
+			return errors.New("check has no deadline")
+		}
+		return nil
+	}}
+
+	tests := []struct {
+		name           string
+		checks         []Check
+		expectedStatus int
+		expected       string
+	}{
+		{name: "Ready", checks: []Check{ok, bounded}, expectedStatus: http.StatusOK, expected: "ready"},
+		{name: "Failing check", checks: []Check{ok, failing}, expectedStatus: http.StatusServiceUnavailable, expected: "not_ready"},
+		{name: "No checks", expectedStatus: http.StatusOK, expected: "ready"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := newTestServer(t)
+			server.Checks = tt.checks
+
+			rec := httptest.NewRecorder()
+			server.ReadinessHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			require.Equal(t, tt.expectedStatus, rec.Code)
+			// Check failures are logged, never exposed on the unauthenticated probe
+			assert.NotContains(t, rec.Body.String(), "version 5")
+
+			var resp ReadinessResponse
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal(t, tt.expected, resp.Status)
+			require.Len(t, resp.Checks, len(tt.checks))
+			for i, check := range tt.checks {
+				assert.Equal(t, check.Name, resp.Checks[i].Name)
+			}
+		})
+	}
+}
+
+// fakeRunHistory reports run as the last pipeline run, or none when it is nil.
+type fakeRunHistory struct {
+	run *models.PipelineRun
+}
+
+func (f *fakeRunHistory) LastRun() (models.PipelineRun, bool) {
+	if f.run == nil {
+		return models.PipelineRun{}, false
+	}
+	return *f.run, true
+}
+
+func TestVersionHandler(t *testing.T) {
+	server, _ := newTestServer(t)
+	runs := &fakeRunHistory{}
+	server.Runs = runs
+
+	get := func() VersionResponse {
+		rec := httptest.NewRecorder()
+		server.VersionHandler(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var resp VersionResponse
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+		return resp
+	}
+
+	resp := get()
+	assert.NotEmpty(t, resp.GitSHA)
+	assert.NotEmpty(t, resp.GoVersion)
+	assert.Nil(t, resp.LastPipelineRun)
+
+	// Runs that skipped every input are reported too
+	runs.run = &models.PipelineRun{RunID: "run-1", URI: "data/sample.csv", Result: "skipped", CompletedAt: time.Date(2024, 4, 3, 1, 0, 0, 0, time.UTC)}
+
+	resp = get()
+	require.NotNil(t, resp.LastPipelineRun)
+	assert.Equal(t, PipelineRunResponse{RunID: "run-1", URI: "data/sample.csv", Result: "skipped", CompletedAt: "2024-04-03T01:00:00Z"}, *resp.LastPipelineRun)
+}
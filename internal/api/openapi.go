@@ -0,0 +1,213 @@
+package api
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// OpenAPIVersion is the OpenAPI specification version of the generated document.
+const OpenAPIVersion = "3.0.3"
+
+// Parameter is a query parameter of a v1 route.
+type Parameter struct {
+	Name        string
+	Description string
+	Type        string
+	Format      string
+	Enum        []string
+	Required    bool
+	Repeated    bool
+	// In is where the parameter is sent, the query string when empty.
+	In string
+}
+
+// OpenAPI generates the OpenAPI document of the v1 routes, deriving response schemas from the DTO types.
+func OpenAPI(routes []Route) map[string]any {
+	components := make(map[string]any)
+	paths := make(map[string]any)
+
+	for _, route := range routes {
+		var params []any
+		for _, p := range route.Parameters {
+			params = append(params, parameterSpec(p))
+		}
+
+		content := map[string]any{
+			"application/json": map[string]any{
+				"schema": schemaOf(reflect.TypeOf(route.Response), components),
+			},
+		}
+		if route.Exports {
+			content[MediaTypeCSV] = map[string]any{"schema": map[string]any{"type": "string"}}
+			content[MediaTypeNDJSON] = map[string]any{"schema": map[string]any{"type": "string"}}
+		}
+
+		operation := map[string]any{
+			"operationId": route.OperationID,
+			"summary":     route.Summary,
+			"responses": map[string]any{
+				"200": map[string]any{
+					"description": "OK",
+					"content":     content,
+				},
+				"400": errorSpec("Invalid request parameters", components),
+				"401": errorSpec("Missing or invalid API key", components),
+				"403": errorSpec("The API key is not allowed to access the requested projects", components),
+				"405": errorSpec("Method not allowed", components),
+				"429": errorSpec("Rate limit exceeded", components),
+				"500": errorSpec("Internal error", components),
+			},
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+
+		item, _ := paths[route.Path].(map[string]any)
+		if item == nil {
+			item = make(map[string]any)
+			paths[route.Path] = item
+		}
+		item[strings.ToLower(route.Method)] = operation
+	}
+
+	return map[string]any{
+		"openapi": OpenAPIVersion,
+		"info": map[string]any{
+			"title":   "Marketplace Pipeline API",
+			"version": "1",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": components,
+			"securitySchemes": map[string]any{
+				"apiKey": map[string]any{"type": "apiKey", "in": "header", "name": APIKeyHeader},
+				"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+		"security": []any{
+			map[string]any{"apiKey": []any{}},
+			map[string]any{"bearer": []any{}},
+		},
+	}
+}
+
+// errorSpec returns the OpenAPI response object of an error answered with the JSON error envelope.
+func errorSpec(description string, components map[string]any) map[string]any {
+	return map[string]any{
+		"description": description,
+		"content": map[string]any{
+			"application/json": map[string]any{
+				"schema": schemaOf(reflect.TypeOf(ErrorResponse{}), components),
+			},
+		},
+	}
+}
+
+// parameterSpec returns the OpenAPI parameter object of p.
+func parameterSpec(p Parameter) map[string]any {
+	schema := map[string]any{"type": p.Type}
+	if p.Format != "" {
+		schema["format"] = p.Format
+	}
+	if len(p.Enum) > 0 {
+		schema["enum"] = p.Enum
+	}
+	if p.Repeated {
+		schema = map[string]any{"type": "array", "items": schema}
+	}
+
+	in := p.In
+	if in == "" {
+		in = "query"
+	}
+	spec := map[string]any{
+		"name":     p.Name,
+		"in":       in,
+		"required": p.Required,
+		"schema":   schema,
+	}
+	if p.Description != "" {
+		spec["description"] = p.Description
+	}
+	if p.Repeated {
+		spec["style"] = "form"
+		spec["explode"] = true
+	}
+	return spec
+}
+
+// schemaOf returns the JSON schema of t. Structs are registered in components and referenced by name.
+func schemaOf(t reflect.Type, components map[string]any) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaOf(t.Elem(), components)
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem(), components)}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number", "format": "double"}
+	case reflect.Struct:
+		ref := map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+		if _, exists := components[t.Name()]; exists {
+			return ref
+		}
+		// Register before recursing so self-referencing types terminate
+		components[t.Name()] = nil
+
+		properties := make(map[string]any)
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, omitempty := jsonName(field)
+			if name == "" {
+				continue
+			}
+			schema := schemaOf(field.Type, components)
+			if format := field.Tag.Get("format"); format != "" {
+				schema["format"] = format
+			}
+			properties[name] = schema
+			if !omitempty {
+				required = append(required, name)
+			}
+		}
+		sort.Strings(required)
+
+		schema := map[string]any{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		components[t.Name()] = schema
+		return ref
+	default:
+		return map[string]any{}
+	}
+}
+
+// jsonName returns the JSON key of an exported field and whether it is omitted when empty.
+func jsonName(field reflect.StructField) (string, bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	name, options, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = field.Name
+	}
+	return name, strings.Contains(options, "omitempty")
+}
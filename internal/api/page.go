@@ -0,0 +1,174 @@
+package api
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/estensen/marketplace-pipeline/internal/models"
+)
+
+// Page size limits for /metrics.
+const (
+	DefaultPageLimit = 100
+	MaxPageLimit     = 1000
+)
+
+// NextCursorHeader carries the cursor of the next page when more rows remain.
+const NextCursorHeader = "X-Next-Cursor"
+
+// Sort keys accepted by /metrics.
+const (
+	SortDate   = "date"
+	SortVolume = "volume"
+	SortCount  = "count"
+)
+
+// page selects a window of sorted metrics.
+type page struct {
+	Sort  string
+	Desc  bool
+	Limit int
+	After *cursor
+}
+
+// cursor identifies the last row of the previous page, along with the ordering it was taken from.
+type cursor struct {
+	Sort      string    `json:"s"`
+	Desc      bool      `json:"o"`
+	Date      time.Time `json:"d"`
+	ProjectID string    `json:"p"`
+	Count     uint64    `json:"c"`
+	VolumeUSD float64   `json:"v"`
+}
+
+// parsePage reads the sort, order, limit and cursor query parameters. Volume and count sort descending by default.
+func parsePage(values url.Values) (page, error) {
+	p := page{Sort: SortDate, Limit: DefaultPageLimit}
+
+	switch s := values.Get("sort"); s {
+	case "", SortDate:
+	case SortVolume, SortCount:
+		p.Sort, p.Desc = s, true
+	default:
+		return p, invalidParameter("sort", "must be date, volume or count")
+	}
+
+	switch values.Get("order") {
+	case "":
+	case "asc":
+		p.Desc = false
+	case "desc":
+		p.Desc = true
+	default:
+		return p, invalidParameter("order", "must be asc or desc")
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > MaxPageLimit {
+			return p, invalidParameter("limit", "must be between 1 and %d", MaxPageLimit)
+		}
+		p.Limit = n
+	}
+
+	if token := values.Get("cursor"); token != "" {
+		c, err := decodeCursor(token)
+		if err != nil || c.Sort != p.Sort || c.Desc != p.Desc {
+			return p, invalidParameter("cursor", "must be a next_cursor returned with the same sort and order")
+		}
+		p.After = &c
+	}
+
+	return p, nil
+}
+
+// apply sorts metrics and returns the rows of the page, with the cursor of the next page or an empty string on the last page.
+func (p page) apply(metrics []models.AggregatedData) ([]models.AggregatedData, string) {
+	rows := make([]cursor, len(metrics))
+	for i, m := range metrics {
+		rows[i] = p.cursorOf(m)
+	}
+	order := make([]int, len(metrics))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(i, j int) int { return p.compare(rows[i], rows[j]) })
+
+	start := 0
+	if p.After != nil {
+		start = len(order)
+		for i, idx := range order {
+			if p.compare(*p.After, rows[idx]) < 0 {
+				start = i
+				break
+			}
+		}
+	}
+	end := min(start+p.Limit, len(order))
+
+	result := make([]models.AggregatedData, 0, end-start)
+	for _, idx := range order[start:end] {
+		result = append(result, metrics[idx])
+	}
+	if end == len(order) {
+		return result, ""
+	}
+	return result, encodeCursor(rows[order[end-1]])
+}
+
+// cursorOf returns the cursor positioned at m.
+func (p page) cursorOf(m models.AggregatedData) cursor {
+	return cursor{
+		Sort:      p.Sort,
+		Desc:      p.Desc,
+		Date:      m.Date,
+		ProjectID: m.ProjectID,
+		Count:     m.TransactionCount,
+		VolumeUSD: m.TotalVolumeUSD,
+	}
+}
+
+// compare orders rows by the sort key in the page's direction, breaking ties by date and project ascending.
+func (p page) compare(a, b cursor) int {
+	var c int
+	switch p.Sort {
+	case SortVolume:
+		c = cmp.Compare(a.VolumeUSD, b.VolumeUSD)
+	case SortCount:
+		c = cmp.Compare(a.Count, b.Count)
+	default:
+		c = a.Date.Compare(b.Date)
+	}
+	if p.Desc {
+		c = -c
+	}
+	if c != 0 {
+		return c
+	}
+	if c = a.Date.Compare(b.Date); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.ProjectID, b.ProjectID)
+}
+
+// encodeCursor serializes c as an opaque URL-safe token.
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a token produced by encodeCursor.
+func decodeCursor(token string) (cursor, error) {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
@@ -0,0 +1,270 @@
+package api
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/estensen/marketplace-pipeline/internal/aggregator"
+	"github.com/estensen/marketplace-pipeline/internal/database"
+)
+
+// Range caps keep a single /metrics request from scanning an unbounded number of rows.
+const (
+	MaxHourlyRangeDays = 31
+	MaxRangeDays       = 366
+)
+
+// Leaderboard size limits.
+const (
+	DefaultLeaderboardLimit = 10
+	MaxLeaderboardLimit     = 100
+)
+
+// Project summary periods. Both compared periods together fit in the range cap.
+const (
+	DefaultSummaryPeriod = "7d"
+	MaxSummaryDays       = MaxRangeDays / 2
+)
+
+// MaxMovingAverageWindow caps the number of points averaged by the moving-average transform.
+const MaxMovingAverageWindow = 365
+
+// ErrInvalidQuery is returned for malformed query parameters.
+var ErrInvalidQuery = errors.New("invalid query")
+
+// idPattern restricts the project, chain and event IDs accepted as filters.
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9_\-]{1,64}$`)
+
+// parseMetricsQuery builds a metrics query from the date or from/to, granularity and filter parameters.
+// A single date selects the period of the granularity containing it.
+func parseMetricsQuery(values url.Values) (aggregator.MetricsQuery, error) {
+	var q aggregator.MetricsQuery
+
+	granularity, err := database.ParseGranularity(values.Get("granularity"))
+	if err != nil {
+		return q, invalidParameter("granularity", "must be hour, day, week or month")
+	}
+	q.Granularity = granularity
+
+	dateStr, fromStr, toStr := values.Get("date"), values.Get("from"), values.Get("to")
+	switch {
+	case dateStr != "" && (fromStr != "" || toStr != ""):
+		return q, invalidParameter("date", "cannot be combined with 'from' and 'to'")
+	case dateStr != "":
+		date, err := parseDate("date", dateStr)
+		if err != nil {
+			return q, err
+		}
+		if granularity.HasRollup() {
+			date = granularity.PeriodStart(date)
+			q.From, q.To = date, granularity.NextPeriodStart(date).AddDate(0, 0, -1)
+		} else {
+			q.From, q.To = date, date
+		}
+	case fromStr == "" && toStr == "":
+		return q, invalidParameter("date", "is required unless 'from' and 'to' are given")
+	default:
+		if q.From, err = parseDate("from", fromStr); err != nil {
+			return q, err
+		}
+		if q.To, err = parseDate("to", toStr); err != nil {
+			return q, err
+		}
+	}
+
+	if q.To.Before(q.From) {
+		return q, invalidParameter("to", "must not be before 'from'")
+	}
+	maxDays := MaxRangeDays
+	if !granularity.HasRollup() {
+		maxDays = MaxHourlyRangeDays
+	}
+	if q.To.Sub(q.From) >= time.Duration(maxDays)*24*time.Hour {
+		return q, invalidParameter("to", "must be less than %d days after 'from' for %s granularity", maxDays, granularity)
+	}
+
+	if q.ProjectIDs, err = parseIDs(values, "project_id"); err != nil {
+		return q, err
+	}
+	if q.ChainIDs, err = parseIDs(values, "chain_id"); err != nil {
+		return q, err
+	}
+	if q.Events, err = parseIDs(values, "event"); err != nil {
+		return q, err
+	}
+
+	return q, nil
+}
+
+// parseLeaderboardQuery builds a leaderboard query from the metrics period and filter parameters, by and limit.
+func parseLeaderboardQuery(values url.Values) (aggregator.LeaderboardQuery, error) {
+	q := aggregator.LeaderboardQuery{Limit: DefaultLeaderboardLimit}
+
+	metrics, err := parseMetricsQuery(values)
+	if err != nil {
+		return q, err
+	}
+	q.Metrics = metrics
+
+	if q.By, err = aggregator.ParseDimension(values.Get("by")); err != nil {
+		return q, invalidParameter("by", "must be project or collection")
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > MaxLeaderboardLimit {
+			return q, invalidParameter("limit", "must be between 1 and %d", MaxLeaderboardLimit)
+		}
+		q.Limit = n
+	}
+
+	return q, nil
+}
+
+// parseTimeSeriesQuery builds a time-series query from the metrics period and filter parameters, metric, transform and window.
+// The moving-average window defaults to 7 points.
+func parseTimeSeriesQuery(values url.Values) (aggregator.TimeSeriesQuery, error) {
+	q := aggregator.TimeSeriesQuery{Window: 7}
+
+	metrics, err := parseMetricsQuery(values)
+	if err != nil {
+		return q, err
+	}
+	q.Metrics = metrics
+
+	if q.Metric, err = aggregator.ParseMetric(values.Get("metric")); err != nil {
+		return q, invalidParameter("metric", "must be volume_usd or transaction_count")
+	}
+	if q.Transform, err = aggregator.ParseTransform(values.Get("transform")); err != nil {
+		return q, invalidParameter("transform", "must be moving_average or cumulative")
+	}
+
+	if window := values.Get("window"); window != "" {
+		n, err := strconv.Atoi(window)
+		if err != nil || n < 1 || n > MaxMovingAverageWindow {
+			return q, invalidParameter("window", "must be between 1 and %d", MaxMovingAverageWindow)
+		}
+		if q.Transform != aggregator.TransformMovingAverage {
+			return q, invalidParameter("window", "requires transform=moving_average")
+		}
+		q.Window = n
+	}
+
+	return q, nil
+}
+
+// repriceQuery selects the token and days to reprice.
+type repriceQuery struct {
+	Token string
+	From  time.Time
+	To    time.Time
+}
+
+// parseRepriceQuery reads the token, from and to parameters of a reprice request, capped like a metrics range.
+func parseRepriceQuery(values url.Values) (repriceQuery, error) {
+	var q repriceQuery
+	if q.Token = values.Get("token"); q.Token == "" {
+		return q, invalidParameter("token", "is required")
+	}
+
+	var err error
+	if q.From, err = parseDate("from", values.Get("from")); err != nil {
+		return q, err
+	}
+	if q.To, err = parseDate("to", values.Get("to")); err != nil {
+		return q, err
+	}
+	return q, checkRange(q.From, q.To)
+}
+
+// priceQuery selects the tokens and days of a price history. No tokens selects every token.
+type priceQuery struct {
+	Tokens []string
+	From   time.Time
+	To     time.Time
+}
+
+// parsePriceQuery reads the token, from and to parameters of a price history request.
+func parsePriceQuery(values url.Values) (priceQuery, error) {
+	var q priceQuery
+	var err error
+	if q.Tokens, err = parseIDs(values, "token"); err != nil {
+		return q, err
+	}
+	if q.From, err = parseDate("from", values.Get("from")); err != nil {
+		return q, err
+	}
+	if q.To, err = parseDate("to", values.Get("to")); err != nil {
+		return q, err
+	}
+	return q, checkRange(q.From, q.To)
+}
+
+// parseSummaryQuery builds a project summary query from the project ID in the path and the period and date
+// parameters. The period is a number of days, such as 7d, ending on date, which defaults to today.
+func parseSummaryQuery(projectID string, values url.Values) (aggregator.SummaryQuery, error) {
+	q := aggregator.SummaryQuery{ProjectID: projectID}
+	if !idPattern.MatchString(projectID) {
+		return q, invalidParameter("id", "must be 1 to 64 letters, digits, '_' or '-'")
+	}
+
+	period := values.Get("period")
+	if period == "" {
+		period = DefaultSummaryPeriod
+	}
+	digits, ok := strings.CutSuffix(period, "d")
+	days, err := strconv.Atoi(digits)
+	if !ok || err != nil || days < 1 || days > MaxSummaryDays {
+		return q, invalidParameter("period", "must be a number of days between 1d and %dd", MaxSummaryDays)
+	}
+
+	q.To = time.Now().UTC().Truncate(24 * time.Hour)
+	if date := values.Get("date"); date != "" {
+		if q.To, err = parseDate("date", date); err != nil {
+			return q, err
+		}
+	}
+	q.From = q.To.AddDate(0, 0, 1-days)
+	return q, nil
+}
+
+// checkRange rejects a from/to range that is reversed or spans MaxRangeDays or more.
+func checkRange(from, to time.Time) error {
+	if to.Before(from) {
+		return invalidParameter("to", "must not be before 'from'")
+	}
+	if to.Sub(from) >= MaxRangeDays*24*time.Hour {
+		return invalidParameter("to", "must be less than %d days after 'from'", MaxRangeDays)
+	}
+	return nil
+}
+
+// parseDate parses a YYYY-MM-DD query parameter.
+func parseDate(name, value string) (time.Time, error) {
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, invalidParameter(name, "must be a date formatted as YYYY-MM-DD")
+	}
+	return date, nil
+}
+
+// parseIDs returns the validated, deduplicated values of a repeatable query parameter.
+func parseIDs(values url.Values, name string) ([]string, error) {
+	var ids []string
+	seen := make(map[string]struct{})
+	for _, id := range values[name] {
+		if !idPattern.MatchString(id) {
+			return nil, invalidParameter(name, "must be 1 to 64 letters, digits, '_' or '-'")
+		}
+		if _, exists := seen[id]; exists {
+			continue
+		}
+		seen[id] = struct{}{}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
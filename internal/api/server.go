@@ -1,65 +1,255 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"time"
 
-	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/estensen/marketplace-pipeline/internal/aggregator"
+	"github.com/estensen/marketplace-pipeline/internal/auth"
+	"github.com/estensen/marketplace-pipeline/internal/config"
+	"github.com/estensen/marketplace-pipeline/internal/database"
+	"github.com/estensen/marketplace-pipeline/internal/reprice"
+	"github.com/estensen/marketplace-pipeline/internal/telemetry"
 )
 
 // Server represents the API server with necessary dependencies.
 type Server struct {
 	Aggregator *aggregator.Aggregator
-	Conn       clickhouse.Conn
+	Metrics    database.MetricsRepository
+	Prices     database.PriceRepository
+	Repricer   *reprice.Repricer
+	Runs       RunHistory
+	Checks     []Check
+	// Keys authenticates the data endpoints. They are open when it is nil.
+	Keys    auth.KeyStore
+	Limiter *auth.Limiter
+	// Cache keeps the responses of metrics queries over past days. Responses aren't cached when it is nil.
+	Cache *ResponseCache
 }
 
 // NewServer initializes a new API server instance.
-func NewServer(agg *aggregator.Aggregator, conn clickhouse.Conn) *Server {
+func NewServer(agg *aggregator.Aggregator, metrics database.MetricsRepository, prices database.PriceRepository) *Server {
 	return &Server{
 		Aggregator: agg,
-		Conn:       conn,
+		Metrics:    metrics,
+		Prices:     prices,
+		Repricer:   reprice.NewRepricer(agg, metrics, prices),
+		Limiter:    auth.NewLimiter(),
 	}
 }
 
 // CalculateMetricsHandler handles the /metrics endpoint.
 func (s *Server) CalculateMetricsHandler(w http.ResponseWriter, r *http.Request) {
-	// Parse date from query parameters
-	dateStr := r.URL.Query().Get("date")
-	if dateStr == "" {
-		http.Error(w, "Missing 'date' query parameter", http.StatusBadRequest)
+	// Parse date range, granularity and filters from query parameters
+	query, err := parseMetricsQuery(r.URL.Query())
+	if err == nil {
+		err = scope(r, &query)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Stream CSV and NDJSON exports instead of paging them
+	format, err := negotiateFormat(w, r, paginationParameters...)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if format != FormatJSON {
+		s.exportMetrics(w, r, format, query)
+		return
+	}
+
+	page, err := parsePage(r.URL.Query())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Calculate metrics, or reuse the response of an identical query
+	s.serveCached(w, r, cacheKey(r.URL.Path, query, page), query, func() (any, map[string]string, error) {
+		metrics, err := s.Aggregator.QueryMetrics(r.Context(), s.Metrics, s.Prices, query)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error calculating metrics: %w", err)
+		}
+		metrics, next := page.apply(metrics)
+		if next == "" {
+			return metrics, nil, nil
+		}
+		return metrics, map[string]string{NextCursorHeader: next}, nil
+	})
+}
+
+// LeaderboardHandler handles the /leaderboard endpoint.
+func (s *Server) LeaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse period, dimension and size from query parameters
+	query, err := parseLeaderboardQuery(r.URL.Query())
+	if err == nil {
+		err = scope(r, &query.Metrics)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Rank projects or collections
+	entries, err := s.Aggregator.Leaderboard(r.Context(), s.Metrics, s.Prices, query)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("error calculating leaderboard: %w", err))
+		return
+	}
+
+	// Respond with JSON
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
+
+// TimeSeriesHandler handles the /timeseries endpoint.
+func (s *Server) TimeSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse period, metric and transform from query parameters
+	query, err := parseTimeSeriesQuery(r.URL.Query())
+	if err == nil {
+		err = scope(r, &query.Metrics)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	format, err := negotiateFormat(w, r)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	date, err := time.Parse("2006-01-02", dateStr)
+	// Build the gap-filled series
+	points, err := s.Aggregator.TimeSeries(r.Context(), s.Metrics, s.Prices, query)
 	if err != nil {
-		http.Error(w, "Invalid date format. Use YYYY-MM-DD.", http.StatusBadRequest)
+		writeError(w, r, fmt.Errorf("error calculating time series: %w", err))
+		return
+	}
+	if format != FormatJSON {
+		s.exportTimeSeries(w, r, format, points)
+		return
+	}
+
+	// Respond with JSON
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(points); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
+
+// RepriceHandler handles the /reprice endpoint.
+func (s *Server) RepriceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, r, ErrMethodNotAllowed)
 		return
 	}
 
-	// Calculate metrics
-	metrics, err := s.Aggregator.CalculateMetrics(s.Conn, date)
+	// Only keys with access to every project may reprice
+	if err := requireUnscoped(r); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Parse token and date range from query parameters
+	query, err := parseRepriceQuery(r.URL.Query())
 	if err != nil {
-		log.Printf("Error calculating metrics: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
+	}
+
+	// Recompute the affected analytics rows
+	// Drop cached responses even on failure, as a partial replacement may have changed the data
+	entries, err := s.Repricer.Reprice(r.Context(), query.Token, query.From, query.To)
+	s.invalidate(query.From, query.To)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("error repricing analytics: %w", err))
 		return
 	}
 
 	// Respond with JSON
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(metrics); err != nil {
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
 		log.Printf("Error encoding response: %v", err)
 	}
 }
 
-// StartServer initializes and starts the API server.
-func StartServer(addr string, server *Server) {
-	http.HandleFunc("/metrics", server.CalculateMetricsHandler)
+// invalidate drops the cached responses covering any day from from to to, inclusive.
+func (s *Server) invalidate(from, to time.Time) {
+	if s.Cache != nil {
+		s.Cache.Invalidate(from, to)
+	}
+}
+
+// Routes returns the handler serving every endpoint on a dedicated mux, assigning request IDs and
+// recording request metrics.
+func (s *Server) Routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.authenticate(s.CalculateMetricsHandler))
+	mux.HandleFunc("/leaderboard", s.authenticate(s.LeaderboardHandler))
+	mux.HandleFunc("/timeseries", s.authenticate(s.TimeSeriesHandler))
+	mux.HandleFunc("/reprice", s.authenticate(s.RepriceHandler))
+	mux.Handle(V1Prefix+"/", s.V1Handler())
+	mux.HandleFunc("/healthz", s.HealthHandler)
+	mux.HandleFunc("/readyz", s.ReadinessHandler)
+	mux.HandleFunc("/version", s.VersionHandler)
+	mux.Handle(telemetry.Path, telemetry.Handler())
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, ErrNotFound)
+	})
+
+	return RequestID(telemetry.Instrument(mux))
+}
+
+// ListenAndServe serves the API on cfg.Addr until ctx is cancelled.
+func (s *Server) ListenAndServe(ctx context.Context, cfg config.HTTPConfig) error {
+	listener, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %w", cfg.Addr, err)
+	}
+	return s.Serve(ctx, listener, cfg)
+}
+
+// Serve serves the API on listener until ctx is cancelled, then stops accepting connections and waits up to
+// cfg.ShutdownTimeout for in-flight requests to complete.
+func (s *Server) Serve(ctx context.Context, listener net.Listener, cfg config.HTTPConfig) error {
+	srv := &http.Server{
+		Handler:           s.Routes(),
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(listener)
+	}()
+	log.Printf("API server is running on %s", listener.Addr())
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("error serving API: %w", err)
+	case <-ctx.Done():
+	}
 
-	log.Printf("API server is running on %s", addr)
-	if err := http.ListenAndServe(addr, nil); err != nil {
-		log.Fatalf("Failed to start API server: %v", err)
+	log.Println("Shutting down API server, draining in-flight requests...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("error shutting down API server: %w", err)
 	}
+	log.Println("API server stopped.")
+	return nil
 }
@@ -0,0 +1,595 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/estensen/marketplace-pipeline/internal/aggregator"
+	"github.com/estensen/marketplace-pipeline/internal/config"
+	"github.com/estensen/marketplace-pipeline/internal/database"
+	"github.com/estensen/marketplace-pipeline/internal/models"
+	"github.com/estensen/marketplace-pipeline/internal/telemetry"
+)
+
+func newTestServer(t *testing.T) (*Server, *database.MemoryRepository) {
+	t.Helper()
+
+	repo := database.NewMemoryRepository()
+	err := repo.LoadAggregates(context.Background(), []models.AggregatedData{
+		{Date: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), ProjectID: "4974", TransactionCount: 5, TotalVolumeUSD: 10},
+		{Date: time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC), ProjectID: "4974", TransactionCount: 3, TotalVolumeUSD: 6},
+		{Date: time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC), ProjectID: "1609", TransactionCount: 1, TotalVolumeUSD: 2},
+		{Date: time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC), ProjectID: "4974", TransactionCount: 2, TotalVolumeUSD: 4},
+	})
+	require.NoError(t, err)
+
+	return NewServer(aggregator.NewAggregator(), repo, repo), repo
+}
+
+func TestCalculateMetricsHandler(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expected       []models.AggregatedData
+	}{
+		{
+			name:           "Daily metrics",
+			query:          "date=2024-04-02",
+			expectedStatus: http.StatusOK,
+			expected: []models.AggregatedData{
+				{Date: time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC), ProjectID: "1609", TransactionCount: 1, TotalVolumeUSD: 2},
+				{Date: time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC), ProjectID: "4974", TransactionCount: 3, TotalVolumeUSD: 6},
+			},
+		},
+		{
+			name:           "Weekly metrics",
+			query:          "date=2024-04-03&granularity=week",
+			expectedStatus: http.StatusOK,
+			expected: []models.AggregatedData{
+				{Date: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), ProjectID: "1609", TransactionCount: 1, TotalVolumeUSD: 2},
+				{Date: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), ProjectID: "4974", TransactionCount: 8, TotalVolumeUSD: 16},
+			},
+		},
+		{
+			name:           "Monthly metrics",
+			query:          "date=2024-04-20&granularity=month",
+			expectedStatus: http.StatusOK,
+			expected: []models.AggregatedData{
+				{Date: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), ProjectID: "1609", TransactionCount: 1, TotalVolumeUSD: 2},
+				{Date: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), ProjectID: "4974", TransactionCount: 10, TotalVolumeUSD: 20},
+			},
+		},
+		{
+			name:           "Date range",
+			query:          "from=2024-04-01&to=2024-04-02",
+			expectedStatus: http.StatusOK,
+			expected: []models.AggregatedData{
+				{Date: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), ProjectID: "4974", TransactionCount: 5, TotalVolumeUSD: 10},
+				{Date: time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC), ProjectID: "1609", TransactionCount: 1, TotalVolumeUSD: 2},
+				{Date: time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC), ProjectID: "4974", TransactionCount: 3, TotalVolumeUSD: 6},
+			},
+		},
+		{
+			name:           "Weekly range with projects",
+			query:          "from=2024-04-01&to=2024-04-15&granularity=week&project_id=4974&project_id=9999",
+			expectedStatus: http.StatusOK,
+			expected: []models.AggregatedData{
+				{Date: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), ProjectID: "4974", TransactionCount: 8, TotalVolumeUSD: 16},
+				{Date: time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC), ProjectID: "4974", TransactionCount: 2, TotalVolumeUSD: 4},
+			},
+		},
+		{
+			name:           "Date with range",
+			query:          "date=2024-04-02&from=2024-04-01",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Missing to",
+			query:          "from=2024-04-01",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Reversed range",
+			query:          "from=2024-04-02&to=2024-04-01",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Range too long",
+			query:          "from=2024-01-01&to=2025-01-01",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Hourly range too long",
+			query:          "from=2024-04-01&to=2024-05-02&granularity=hour",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid project ID",
+			query:          "date=2024-04-02&project_id=4974%27%20OR%201=1",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Missing date",
+			query:          "",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid date",
+			query:          "date=02-04-2024",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid granularity",
+			query:          "date=2024-04-02&granularity=year",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/metrics?"+tt.query, nil)
+			rec := httptest.NewRecorder()
+
+			server.CalculateMetricsHandler(rec, req)
+
+			require.Equal(t, tt.expectedStatus, rec.Code)
+			if tt.expected == nil {
+				var resp ErrorResponse
+				require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+				assert.Equal(t, CodeInvalidParameter, resp.Error.Code)
+				require.Len(t, resp.Error.Details, 1)
+				return
+			}
+
+			var metrics []models.AggregatedData
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&metrics))
+			assert.Equal(t, tt.expected, metrics)
+		})
+	}
+}
+
+func TestRepriceHandler(t *testing.T) {
+	server, repo := newTestServer(t)
+	ctx := context.Background()
+	day := time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)
+
+	require.NoError(t, repo.LoadNativeVolumes(ctx, []models.NativeVolume{
+		{Date: day, ProjectID: "4974", CurrencySymbol: "MATIC", Token: "matic-network", TransactionCount: 3, TotalVolumeNative: 6},
+	}))
+	require.NoError(t, repo.StorePrices(ctx, day, "coingecko", map[string]float64{"matic-network": 1.5}))
+
+	tests := []struct {
+		name           string
+		method         string
+		query          string
+		expectedStatus int
+		expectedCode   string
+	}{
+		{name: "Wrong method", method: http.MethodGet, query: "token=matic-network&from=2024-04-01&to=2024-04-02", expectedStatus: http.StatusMethodNotAllowed},
+		{name: "Missing token", method: http.MethodPost, query: "from=2024-04-01&to=2024-04-02", expectedStatus: http.StatusBadRequest, expectedCode: CodeInvalidParameter},
+		{name: "Invalid range", method: http.MethodPost, query: "token=matic-network&from=2024-04-02&to=2024-04-01", expectedStatus: http.StatusBadRequest, expectedCode: CodeInvalidParameter},
+		{name: "Range too long", method: http.MethodPost, query: "token=matic-network&from=2020-01-01&to=2024-04-02", expectedStatus: http.StatusBadRequest, expectedCode: CodeInvalidParameter},
+		{name: "Valid reprice", method: http.MethodPost, query: "token=matic-network&from=2024-04-01&to=2024-04-02", expectedStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/reprice?"+tt.query, nil)
+			rec := httptest.NewRecorder()
+
+			server.RepriceHandler(rec, req)
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+			if tt.expectedCode != "" {
+				var body ErrorResponse
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+				assert.Equal(t, tt.expectedCode, body.Error.Code)
+			}
+		})
+	}
+
+	metrics, err := repo.FetchRollup(ctx, database.GranularityDay, day, day, nil)
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+	assert.Equal(t, "4974", metrics[1].ProjectID)
+	assert.InDelta(t, 9.0, metrics[1].TotalVolumeUSD, 0.0001)
+	assert.Len(t, repo.RepriceAudit(), 1)
+}
+
+func TestV1Prices(t *testing.T) {
+	server, repo := newTestServer(t)
+	ctx := context.Background()
+	apr1 := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	apr2 := time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, repo.StorePrices(ctx, apr1, "coingecko", map[string]float64{"matic-network": 0.5, "usd-coin": 1}))
+	require.NoError(t, repo.StorePrices(ctx, apr2, "snapshot", map[string]float64{"matic-network": 0.6}))
+
+	tests := []struct {
+		name           string
+		target         string
+		expectedStatus int
+		expected       []string
+	}{
+		{
+			name:           "History of every token",
+			target:         "/v1/prices?from=2024-04-01&to=2024-04-02",
+			expectedStatus: http.StatusOK,
+			expected:       []string{"matic-network 2024-04-01 0.5 coingecko", "usd-coin 2024-04-01 1 coingecko", "matic-network 2024-04-02 0.6 snapshot"},
+		},
+		{
+			name:           "History of one token",
+			target:         "/v1/prices?token=usd-coin&from=2024-04-01&to=2024-04-02",
+			expectedStatus: http.StatusOK,
+			expected:       []string{"usd-coin 2024-04-01 1 coingecko"},
+		},
+		{
+			name:           "Latest prices",
+			target:         "/v1/prices/latest",
+			expectedStatus: http.StatusOK,
+			expected:       []string{"matic-network 2024-04-02 0.6 snapshot", "usd-coin 2024-04-01 1 coingecko"},
+		},
+		{
+			name:           "Latest price of unknown token",
+			target:         "/v1/prices/latest?token=bitcoin",
+			expectedStatus: http.StatusOK,
+			expected:       []string{},
+		},
+		{name: "Missing range", target: "/v1/prices?token=usd-coin", expectedStatus: http.StatusBadRequest},
+		{name: "Reversed range", target: "/v1/prices?from=2024-04-02&to=2024-04-01", expectedStatus: http.StatusBadRequest},
+		{name: "Range too long", target: "/v1/prices?from=2024-01-01&to=2025-01-01", expectedStatus: http.StatusBadRequest},
+		{name: "Invalid token", target: "/v1/prices/latest?token=bit%20coin", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			rec := httptest.NewRecorder()
+
+			server.V1Handler().ServeHTTP(rec, req)
+
+			require.Equal(t, tt.expectedStatus, rec.Code)
+			if tt.expected == nil {
+				return
+			}
+
+			var resp PricesResponse
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+			prices := []string{}
+			for _, p := range resp.Data {
+				assert.NotEmpty(t, p.FetchedAt)
+				prices = append(prices, fmt.Sprintf("%s %s %v %s", p.Token, p.Date, p.PriceUSD, p.Source))
+			}
+			assert.Equal(t, tt.expected, prices)
+		})
+	}
+}
+
+func TestV1ProjectSummary(t *testing.T) {
+	server, _ := newTestServer(t)
+	percent := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name           string
+		target         string
+		expectedStatus int
+		expectedFrom   string
+		expectedCount  uint64
+		expectedChange ChangeResponse
+	}{
+		{
+			name:           "Week over week",
+			target:         "/v1/projects/4974/summary?period=7d&date=2024-04-16",
+			expectedStatus: http.StatusOK,
+			expectedFrom:   "2024-04-10",
+			expectedCount:  2,
+			expectedChange: ChangeResponse{Absolute: 2},
+		},
+		{
+			name:           "Day over day",
+			target:         "/v1/projects/4974/summary?period=1d&date=2024-04-02",
+			expectedStatus: http.StatusOK,
+			expectedFrom:   "2024-04-02",
+			expectedCount:  3,
+			expectedChange: ChangeResponse{Absolute: -2, Percent: percent(-40)},
+		},
+		{name: "Invalid period", target: "/v1/projects/4974/summary?period=7w", expectedStatus: http.StatusBadRequest},
+		{name: "Empty period", target: "/v1/projects/4974/summary?period=0d", expectedStatus: http.StatusBadRequest},
+		{name: "Period too long", target: "/v1/projects/4974/summary?period=200d", expectedStatus: http.StatusBadRequest},
+		{name: "Invalid date", target: "/v1/projects/4974/summary?date=02-04-2024", expectedStatus: http.StatusBadRequest},
+		{name: "Invalid project ID", target: "/v1/projects/4974%27/summary", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			rec := httptest.NewRecorder()
+
+			server.Routes().ServeHTTP(rec, req)
+
+			require.Equal(t, tt.expectedStatus, rec.Code, rec.Body.String())
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var resp ProjectSummaryResponse
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal(t, "4974", resp.ProjectID)
+			assert.Equal(t, tt.expectedFrom, resp.Current.From)
+			assert.Equal(t, tt.expectedCount, resp.Current.TransactionCount)
+			assert.Equal(t, tt.expectedChange, resp.Change.TransactionCount)
+		})
+	}
+}
+
+func TestCalculateMetricsHandlerPagination(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	get := func(query string) (*httptest.ResponseRecorder, []models.AggregatedData) {
+		req := httptest.NewRequest(http.MethodGet, "/metrics?"+query, nil)
+		rec := httptest.NewRecorder()
+		server.CalculateMetricsHandler(rec, req)
+		var metrics []models.AggregatedData
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&metrics))
+		}
+		return rec, metrics
+	}
+
+	rec, metrics := get("from=2024-04-01&to=2024-04-30&sort=volume&limit=2")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Len(t, metrics, 2)
+	assert.Equal(t, 10.0, metrics[0].TotalVolumeUSD)
+	assert.Equal(t, 6.0, metrics[1].TotalVolumeUSD)
+	next := rec.Header().Get(NextCursorHeader)
+	require.NotEmpty(t, next)
+
+	rec, metrics = get("from=2024-04-01&to=2024-04-30&sort=volume&limit=2&cursor=" + next)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Len(t, metrics, 2)
+	assert.Equal(t, 4.0, metrics[0].TotalVolumeUSD)
+	assert.Equal(t, 2.0, metrics[1].TotalVolumeUSD)
+	assert.Empty(t, rec.Header().Get(NextCursorHeader))
+
+	rec, metrics = get("from=2024-04-01&to=2024-04-30&sort=count&order=asc")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Len(t, metrics, 4)
+	assert.Equal(t, uint64(1), metrics[0].TransactionCount)
+	assert.Equal(t, uint64(5), metrics[3].TransactionCount)
+
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{name: "Invalid sort", query: "sort=price"},
+		{name: "Invalid order", query: "order=up"},
+		{name: "Limit too large", query: "limit=1001"},
+		{name: "Malformed cursor", query: "cursor=!!!"},
+		{name: "Cursor from another ordering", query: "sort=count&cursor=" + next},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, _ := get("from=2024-04-01&to=2024-04-30&" + tt.query)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+		})
+	}
+}
+
+func TestLeaderboardHandler(t *testing.T) {
+	server, repo := newTestServer(t)
+	require.NoError(t, repo.LoadAggregates(context.Background(), []models.AggregatedData{
+		{Date: time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC), ProjectID: "1609", TransactionCount: 9, TotalVolumeUSD: 50},
+		{Date: time.Date(2024, 3, 30, 0, 0, 0, 0, time.UTC), ProjectID: "4974", TransactionCount: 1, TotalVolumeUSD: 1},
+	}))
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expected       []models.LeaderboardEntry
+	}{
+		{
+			name:           "Top projects",
+			query:          "from=2024-04-01&to=2024-04-02",
+			expectedStatus: http.StatusOK,
+			expected: []models.LeaderboardEntry{
+				{Rank: 1, PreviousRank: 2, RankChange: 1, ID: "4974", TransactionCount: 8, TotalVolumeUSD: 16},
+				{Rank: 2, PreviousRank: 1, RankChange: -1, ID: "1609", TransactionCount: 1, TotalVolumeUSD: 2},
+			},
+		},
+		{
+			name:           "Limit",
+			query:          "date=2024-04-15&granularity=month&limit=1",
+			expectedStatus: http.StatusOK,
+			expected: []models.LeaderboardEntry{
+				{Rank: 1, PreviousRank: 2, RankChange: 1, ID: "4974", TransactionCount: 10, TotalVolumeUSD: 20},
+			},
+		},
+		{
+			name:           "Invalid dimension",
+			query:          "date=2024-04-02&by=wallet",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid limit",
+			query:          "date=2024-04-02&limit=0",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/leaderboard?"+tt.query, nil)
+			rec := httptest.NewRecorder()
+
+			server.LeaderboardHandler(rec, req)
+
+			require.Equal(t, tt.expectedStatus, rec.Code)
+			if tt.expected == nil {
+				return
+			}
+
+			var entries []models.LeaderboardEntry
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&entries))
+			assert.Equal(t, tt.expected, entries)
+		})
+	}
+}
+
+func TestTimeSeriesHandler(t *testing.T) {
+	server, _ := newTestServer(t)
+	day := func(d int) time.Time {
+		return time.Date(2024, 4, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expected       []models.TimeSeriesPoint
+	}{
+		{
+			name:           "Gap filled",
+			query:          "project_id=4974&from=2024-04-01&to=2024-04-04",
+			expectedStatus: http.StatusOK,
+			expected: []models.TimeSeriesPoint{
+				{Timestamp: day(1), Value: 10},
+				{Timestamp: day(2), Value: 6},
+				{Timestamp: day(3), Value: 0},
+				{Timestamp: day(4), Value: 0},
+			},
+		},
+		{
+			name:           "Weekly transaction count",
+			query:          "metric=transaction_count&from=2024-04-01&to=2024-04-15&granularity=week",
+			expectedStatus: http.StatusOK,
+			expected: []models.TimeSeriesPoint{
+				{Timestamp: day(1), Value: 9},
+				{Timestamp: day(8), Value: 0},
+				{Timestamp: day(15), Value: 2},
+			},
+		},
+		{
+			name:           "Cumulative",
+			query:          "from=2024-04-01&to=2024-04-03&transform=cumulative",
+			expectedStatus: http.StatusOK,
+			expected: []models.TimeSeriesPoint{
+				{Timestamp: day(1), Value: 10},
+				{Timestamp: day(2), Value: 18},
+				{Timestamp: day(3), Value: 18},
+			},
+		},
+		{
+			name:           "Moving average",
+			query:          "from=2024-04-01&to=2024-04-03&transform=moving_average&window=2",
+			expectedStatus: http.StatusOK,
+			expected: []models.TimeSeriesPoint{
+				{Timestamp: day(1), Value: 10},
+				{Timestamp: day(2), Value: 9},
+				{Timestamp: day(3), Value: 4},
+			},
+		},
+		{
+			name:           "Invalid metric",
+			query:          "from=2024-04-01&to=2024-04-03&metric=price",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid transform",
+			query:          "from=2024-04-01&to=2024-04-03&transform=log",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Window without moving average",
+			query:          "from=2024-04-01&to=2024-04-03&window=3",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/timeseries?"+tt.query, nil)
+			rec := httptest.NewRecorder()
+
+			server.TimeSeriesHandler(rec, req)
+
+			require.Equal(t, tt.expectedStatus, rec.Code)
+			if tt.expected == nil {
+				return
+			}
+
+			var points []models.TimeSeriesPoint
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&points))
+			assert.Equal(t, tt.expected, points)
+		})
+	}
+}
+
+func TestServeDrainsOnShutdown(t *testing.T) {
+	server, _ := newTestServer(t)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server.Checks = []Check{{Name: "slow", Run: func(ctx context.Context) error {
+		close(started)
+		<-release
+		return nil
+	}}}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(ctx, listener, config.HTTPConfig{
+			ReadHeaderTimeout: time.Second,
+			WriteTimeout:      5 * time.Second,
+			ShutdownTimeout:   5 * time.Second,
+		})
+	}()
+
+	base := "http://" + listener.Addr().String()
+	resp, err := http.Get(base + telemetry.Path)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Start a request that is still in flight when the server is asked to stop
+	inFlight := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(base + "/readyz")
+		if err != nil {
+			inFlight <- 0
+			return
+		}
+		resp.Body.Close()
+		inFlight <- resp.StatusCode
+	}()
+	<-started
+	cancel()
+
+	select {
+	case <-served:
+		t.Fatal("Serve returned before the in-flight request completed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	assert.Equal(t, http.StatusOK, <-inFlight)
+	require.NoError(t, <-served)
+
+	_, err = http.Get(base + "/healthz")
+	assert.Error(t, err)
+}
@@ -0,0 +1,373 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/estensen/marketplace-pipeline/internal/aggregator"
+)
+
+// V1Prefix is the path prefix of the versioned API.
+const V1Prefix = "/v1"
+
+// Route is a v1 endpoint. Response is a zero value of the DTO the handler returns, used to generate its schema.
+type Route struct {
+	Method      string
+	Path        string
+	OperationID string
+	Summary     string
+	Parameters  []Parameter
+	Response    any
+	// Exports marks routes that also answer with CSV and NDJSON.
+	Exports bool
+	Handler http.HandlerFunc
+}
+
+// periodParameters are the period and filter parameters shared by the metrics endpoints.
+var periodParameters = []Parameter{
+	{Name: "date", Type: "string", Format: "date", Description: "Day within the single period to return. Excludes from and to."},
+	{Name: "from", Type: "string", Format: "date", Description: "First day of the range, inclusive."},
+	{Name: "to", Type: "string", Format: "date", Description: "Last day of the range, inclusive."},
+	{Name: "granularity", Type: "string", Enum: []string{"hour", "day", "week", "month"}, Description: "Period length. Defaults to day."},
+	{Name: "project_id", Type: "string", Repeated: true, Description: "Only include these projects."},
+	{Name: "chain_id", Type: "string", Repeated: true, Description: "Only include transactions on these chains."},
+	{Name: "event", Type: "string", Repeated: true, Description: "Only include these event types."},
+}
+
+// formatParameter selects the response format of the routes that support exports.
+var formatParameter = Parameter{Name: "format", Type: "string", Enum: []string{FormatJSON, FormatCSV, FormatNDJSON}, Description: "Response format. Defaults to the Accept header, then json."}
+
+// withParameters returns the period parameters followed by extra.
+func withParameters(extra ...Parameter) []Parameter {
+	return append(append([]Parameter{}, periodParameters...), extra...)
+}
+
+// V1Routes lists the endpoints of the versioned API.
+func (s *Server) V1Routes() []Route {
+	return []Route{
+		{
+			Method:      http.MethodGet,
+			Path:        V1Prefix + "/metrics",
+			OperationID: "listMetrics",
+			Summary:     "Metrics per period and project",
+			Parameters: withParameters(
+				Parameter{Name: "sort", Type: "string", Enum: []string{SortDate, SortVolume, SortCount}},
+				Parameter{Name: "order", Type: "string", Enum: []string{"asc", "desc"}},
+				Parameter{Name: "limit", Type: "integer", Description: "Page size, at most 1000."},
+				Parameter{Name: "cursor", Type: "string", Description: "next_cursor of the previous page."},
+				formatParameter,
+			),
+			Response: MetricsResponse{},
+			Exports:  true,
+			Handler:  s.v1Metrics,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        V1Prefix + "/leaderboard",
+			OperationID: "getLeaderboard",
+			Summary:     "Top projects or collections by USD volume",
+			Parameters: withParameters(
+				Parameter{Name: "by", Type: "string", Enum: []string{"project", "collection"}},
+				Parameter{Name: "limit", Type: "integer", Description: "Number of entries, at most 100."},
+			),
+			Response: LeaderboardResponse{},
+			Handler:  s.v1Leaderboard,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        V1Prefix + "/timeseries",
+			OperationID: "getTimeSeries",
+			Summary:     "Gap-filled time series of a metric",
+			Parameters: withParameters(
+				Parameter{Name: "metric", Type: "string", Enum: []string{"volume_usd", "transaction_count"}},
+				Parameter{Name: "transform", Type: "string", Enum: []string{"moving_average", "cumulative"}},
+				Parameter{Name: "window", Type: "integer", Description: "Moving-average window in points."},
+				formatParameter,
+			),
+			Response: TimeSeriesResponse{},
+			Exports:  true,
+			Handler:  s.v1TimeSeries,
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        V1Prefix + "/reprice",
+			OperationID: "reprice",
+			Summary:     "Recompute USD volumes after a token price correction",
+			Parameters: []Parameter{
+				{Name: "token", Type: "string", Required: true},
+				{Name: "from", Type: "string", Format: "date", Required: true},
+				{Name: "to", Type: "string", Format: "date", Required: true},
+			},
+			Response: RepriceResponse{},
+			Handler:  s.v1Reprice,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        V1Prefix + "/prices",
+			OperationID: "listPrices",
+			Summary:     "Daily USD prices of tokens, as used to convert volumes",
+			Parameters: []Parameter{
+				{Name: "token", Type: "string", Repeated: true, Description: "Only include these CoinGecko coin IDs."},
+				{Name: "from", Type: "string", Format: "date", Required: true, Description: "First day of the range, inclusive."},
+				{Name: "to", Type: "string", Format: "date", Required: true, Description: "Last day of the range, inclusive."},
+			},
+			Response: PricesResponse{},
+			Handler:  s.v1Prices,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        V1Prefix + "/prices/latest",
+			OperationID: "listLatestPrices",
+			Summary:     "Most recent USD price of each token",
+			Parameters: []Parameter{
+				{Name: "token", Type: "string", Repeated: true, Description: "Only include these CoinGecko coin IDs."},
+			},
+			Response: PricesResponse{},
+			Handler:  s.v1LatestPrices,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        V1Prefix + "/projects/{id}/summary",
+			OperationID: "getProjectSummary",
+			Summary:     "Activity of a project compared with the previous period",
+			Parameters: []Parameter{
+				{Name: "id", Type: "string", In: "path", Required: true, Description: "Project ID."},
+				{Name: "period", Type: "string", Description: "Number of days of each compared period, such as 7d. Defaults to 7d."},
+				{Name: "date", Type: "string", Format: "date", Description: "Last day of the current period. Defaults to today."},
+			},
+			Response: ProjectSummaryResponse{},
+			Handler:  s.v1ProjectSummary,
+		},
+	}
+}
+
+// V1Handler routes the versioned API, including its OpenAPI document at /v1/openapi.json.
+func (s *Server) V1Handler() http.Handler {
+	routes := s.V1Routes()
+	spec, err := json.Marshal(OpenAPI(routes))
+	if err != nil {
+		log.Fatalf("Failed to generate OpenAPI document: %v", err)
+	}
+
+	// Dispatch on the method inside each path, so that unsupported methods get the JSON error envelope
+	handlers := make(map[string]map[string]http.HandlerFunc)
+	for _, route := range routes {
+		if handlers[route.Path] == nil {
+			handlers[route.Path] = make(map[string]http.HandlerFunc)
+		}
+		handlers[route.Path][route.Method] = s.authenticate(route.Handler)
+	}
+
+	mux := http.NewServeMux()
+	for path, methods := range handlers {
+		mux.HandleFunc(path, dispatch(methods))
+	}
+	mux.HandleFunc(V1Prefix+"/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, ErrNotFound)
+	})
+	mux.HandleFunc(V1Prefix+"/openapi.json", dispatch(map[string]http.HandlerFunc{http.MethodGet: func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(spec); err != nil {
+			log.Printf("Error writing OpenAPI document: %v", err)
+		}
+	}}))
+	return mux
+}
+
+// dispatch routes a request to the handler of its method, answering other methods with 405 and an Allow header.
+func dispatch(methods map[string]http.HandlerFunc) http.HandlerFunc {
+	allowed := make([]string, 0, len(methods))
+	for method := range methods {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler, ok := methods[r.Method]
+		if !ok {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			writeError(w, r, ErrMethodNotAllowed)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// v1Metrics handles GET /v1/metrics.
+func (s *Server) v1Metrics(w http.ResponseWriter, r *http.Request) {
+	query, err := parseMetricsQuery(r.URL.Query())
+	if err == nil {
+		err = scope(r, &query)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	format, err := negotiateFormat(w, r, paginationParameters...)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if format != FormatJSON {
+		s.exportMetrics(w, r, format, query)
+		return
+	}
+	page, err := parsePage(r.URL.Query())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	s.serveCached(w, r, cacheKey(r.URL.Path, query, page), query, func() (any, map[string]string, error) {
+		metrics, err := s.Aggregator.QueryMetrics(r.Context(), s.Metrics, s.Prices, query)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error calculating metrics: %w", err)
+		}
+		metrics, next := page.apply(metrics)
+		return newMetricsResponse(metrics, query.Granularity, next), nil, nil
+	})
+}
+
+// v1Leaderboard handles GET /v1/leaderboard.
+func (s *Server) v1Leaderboard(w http.ResponseWriter, r *http.Request) {
+	query, err := parseLeaderboardQuery(r.URL.Query())
+	if err == nil {
+		err = scope(r, &query.Metrics)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	entries, err := s.Aggregator.Leaderboard(r.Context(), s.Metrics, s.Prices, query)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("error calculating leaderboard: %w", err))
+		return
+	}
+
+	writeJSON(w, newLeaderboardResponse(query.By, entries))
+}
+
+// v1TimeSeries handles GET /v1/timeseries.
+func (s *Server) v1TimeSeries(w http.ResponseWriter, r *http.Request) {
+	query, err := parseTimeSeriesQuery(r.URL.Query())
+	if err == nil {
+		err = scope(r, &query.Metrics)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	format, err := negotiateFormat(w, r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	points, err := s.Aggregator.TimeSeries(r.Context(), s.Metrics, s.Prices, query)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("error calculating time series: %w", err))
+		return
+	}
+	if format != FormatJSON {
+		s.exportTimeSeries(w, r, format, points)
+		return
+	}
+
+	writeJSON(w, newTimeSeriesResponse(query, points))
+}
+
+// v1Prices handles GET /v1/prices.
+func (s *Server) v1Prices(w http.ResponseWriter, r *http.Request) {
+	query, err := parsePriceQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	prices, err := s.Prices.FetchPriceHistory(r.Context(), query.From, query.To, query.Tokens)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("error fetching prices: %w", err))
+		return
+	}
+
+	writeJSON(w, newPricesResponse(prices))
+}
+
+// v1LatestPrices handles GET /v1/prices/latest.
+func (s *Server) v1LatestPrices(w http.ResponseWriter, r *http.Request) {
+	tokens, err := parseIDs(r.URL.Query(), "token")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	prices, err := s.Prices.FetchLatestPrices(r.Context(), tokens)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("error fetching latest prices: %w", err))
+		return
+	}
+
+	writeJSON(w, newPricesResponse(prices))
+}
+
+// v1ProjectSummary handles GET /v1/projects/{id}/summary.
+func (s *Server) v1ProjectSummary(w http.ResponseWriter, r *http.Request) {
+	query, err := parseSummaryQuery(r.PathValue("id"), r.URL.Query())
+	if err == nil {
+		err = scope(r, &aggregator.MetricsQuery{ProjectIDs: []string{query.ProjectID}})
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	summary, err := s.Aggregator.ProjectSummary(r.Context(), s.Metrics, s.Prices, query)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("error summarizing project: %w", err))
+		return
+	}
+
+	writeJSON(w, newProjectSummaryResponse(summary))
+}
+
+// v1Reprice handles POST /v1/reprice.
+func (s *Server) v1Reprice(w http.ResponseWriter, r *http.Request) {
+	if err := requireUnscoped(r); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	query, err := parseRepriceQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	entries, err := s.Repricer.Reprice(r.Context(), query.Token, query.From, query.To)
+	s.invalidate(query.From, query.To)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("error repricing analytics: %w", err))
+		return
+	}
+
+	writeJSON(w, newRepriceResponse(entries))
+}
+
+// writeJSON responds with v encoded as JSON.
+func writeJSON(w http.ResponseWriter, v any) {
+	writeJSONStatus(w, http.StatusOK, v)
+}
+
+// writeJSONStatus responds with status and v encoded as JSON.
+func writeJSONStatus(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
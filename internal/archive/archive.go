@@ -0,0 +1,235 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/estensen/marketplace-pipeline/internal/storage"
+)
+
+// Datasets archived in object storage.
+const (
+	DatasetPrices    = "prices"
+	DatasetAnalytics = "analytics"
+	DatasetRaw       = "raw"
+)
+
+// Predefined errors for reading and configuring archives.
+var (
+	ErrChecksumMismatch = errors.New("archive checksum mismatch")
+	ErrInvalidFormat    = errors.New("invalid archive format")
+	ErrInvalidPrices    = errors.New("invalid price snapshot")
+)
+
+// Format is the file format of an archived part.
+type Format string
+
+const (
+	FormatCSV     Format = "csv"
+	FormatParquet Format = "parquet"
+)
+
+// ParseFormats converts a comma-separated list such as "csv,parquet" to formats.
+func ParseFormats(s string) ([]Format, error) {
+	var formats []Format
+	for _, name := range strings.Split(s, ",") {
+		switch f := Format(strings.TrimSpace(name)); f {
+		case FormatCSV, FormatParquet:
+			formats = append(formats, f)
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrInvalidFormat, name)
+		}
+	}
+	return formats, nil
+}
+
+// extension returns the suffix of objects written in this format.
+func (f Format) extension() string {
+	if f == FormatParquet {
+		return ".parquet"
+	}
+	return ".csv.gz"
+}
+
+// Manifest describes an archived object and is stored next to it as JSON.
+type Manifest struct {
+	Object    string    `json:"object"`
+	Dataset   string    `json:"dataset"`
+	Format    Format    `json:"format"`
+	Date      string    `json:"date"`
+	Rows      int       `json:"rows"`
+	Bytes     int64     `json:"bytes"`
+	SHA256    string    `json:"sha256"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PartitionPrefix returns the Hive-style prefix holding the objects of dataset for date,
+// e.g. prices/date=2024-04-02/.
+func PartitionPrefix(dataset string, date time.Time) string {
+	return fmt.Sprintf("%s/date=%s/", dataset, date.Format("2006-01-02"))
+}
+
+// ObjectName returns the name of a part of dataset for date in the given format,
+// e.g. prices/date=2024-04-02/part-0.csv.gz.
+func ObjectName(dataset string, date time.Time, part int, format Format) string {
+	return fmt.Sprintf("%spart-%d%s", PartitionPrefix(dataset, date), part, format.extension())
+}
+
+// ManifestName returns the name of the sidecar manifest of objectName.
+func ManifestName(objectName string) string {
+	return objectName + ".manifest.json"
+}
+
+// Archiver writes dataset parts with sidecar manifests to object storage in each of its formats.
+type Archiver struct {
+	Storage storage.Storage
+	Formats []Format
+	now     func() time.Time
+}
+
+// NewArchiver creates a new Archiver writing the given formats, or gzip-compressed CSV when none are given.
+func NewArchiver(s storage.Storage, formats ...Format) *Archiver {
+	if len(formats) == 0 {
+		formats = []Format{FormatCSV}
+	}
+	return &Archiver{
+		Storage: s,
+		Formats: formats,
+		now:     time.Now,
+	}
+}
+
+// writes reports whether the archiver is configured to write format.
+func (a *Archiver) writes(format Format) bool {
+	for _, f := range a.Formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteCSV archives header and records as a CSV part of dataset for date.
+func (a *Archiver) WriteCSV(dataset string, date time.Time, part int, header []string, records [][]string) (Manifest, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+
+	if err := writer.Write(header); err != nil {
+		return Manifest{}, fmt.Errorf("error writing CSV header: %w", err)
+	}
+	if err := writer.WriteAll(records); err != nil {
+		return Manifest{}, fmt.Errorf("error writing CSV records: %w", err)
+	}
+
+	return a.Write(dataset, date, part, &buf, len(records))
+}
+
+// Write compresses the CSV content of r and archives it as a part of dataset for date.
+// rows is the number of records in r, excluding the header.
+func (a *Archiver) Write(dataset string, date time.Time, part int, r io.Reader, rows int) (Manifest, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := io.Copy(gz, r); err != nil {
+		return Manifest{}, fmt.Errorf("error compressing %s archive: %w", dataset, err)
+	}
+	if err := gz.Close(); err != nil {
+		return Manifest{}, fmt.Errorf("error compressing %s archive: %w", dataset, err)
+	}
+
+	return a.upload(dataset, date, part, FormatCSV, buf.Bytes(), rows)
+}
+
+// upload stores data as a part of dataset for date, followed by its manifest.
+func (a *Archiver) upload(dataset string, date time.Time, part int, format Format, data []byte, rows int) (Manifest, error) {
+	sum := sha256.Sum256(data)
+	manifest := Manifest{
+		Object:    ObjectName(dataset, date, part, format),
+		Dataset:   dataset,
+		Format:    format,
+		Date:      date.Format("2006-01-02"),
+		Rows:      rows,
+		Bytes:     int64(len(data)),
+		SHA256:    hex.EncodeToString(sum[:]),
+		CreatedAt: a.now().UTC(),
+	}
+
+	if err := a.Storage.UploadFile(manifest.Object, bytes.NewReader(data)); err != nil {
+		return Manifest{}, fmt.Errorf("error uploading %s archive: %w", dataset, err)
+	}
+
+	// The manifest is written last, so its presence means the part is complete
+	encoded, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return Manifest{}, fmt.Errorf("error encoding manifest: %w", err)
+	}
+	if err := a.Storage.UploadFile(ManifestName(manifest.Object), bytes.NewReader(encoded)); err != nil {
+		return Manifest{}, fmt.Errorf("error uploading %s manifest: %w", dataset, err)
+	}
+
+	return manifest, nil
+}
+
+// ReadManifest downloads and decodes the manifest of objectName.
+func (a *Archiver) ReadManifest(objectName string) (Manifest, error) {
+	reader, err := a.Storage.Download(ManifestName(objectName))
+	if err != nil {
+		return Manifest{}, fmt.Errorf("error downloading manifest: %w", err)
+	}
+	defer reader.Close()
+
+	var manifest Manifest
+	if err := json.NewDecoder(reader).Decode(&manifest); err != nil {
+		return Manifest{}, fmt.Errorf("error decoding manifest of %s: %w", objectName, err)
+	}
+	return manifest, nil
+}
+
+// Open verifies a CSV part of dataset for date against its manifest and returns its decompressed content.
+func (a *Archiver) Open(dataset string, date time.Time, part int) (io.Reader, error) {
+	data, err := a.download(dataset, date, part, FormatCSV)
+	if err != nil {
+		return nil, err
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing %s archive: %w", dataset, err)
+	}
+	return gz, nil
+}
+
+// download reads a part of dataset for date and verifies it against its manifest.
+func (a *Archiver) download(dataset string, date time.Time, part int, format Format) ([]byte, error) {
+	objectName := ObjectName(dataset, date, part, format)
+	manifest, err := a.ReadManifest(objectName)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := a.Storage.Download(objectName)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading %s archive: %w", dataset, err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s archive: %w", dataset, err)
+	}
+
+	sum := sha256.Sum256(data)
+	if int64(len(data)) != manifest.Bytes || hex.EncodeToString(sum[:]) != manifest.SHA256 {
+		return nil, fmt.Errorf("%w: %s", ErrChecksumMismatch, objectName)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,110 @@
+package archive
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/estensen/marketplace-pipeline/internal/storage"
+)
+
+func TestObjectName(t *testing.T) {
+	date := time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, "prices/date=2024-04-02/part-0.csv.gz", ObjectName(DatasetPrices, date, 0, FormatCSV))
+	assert.Equal(t, "analytics/date=2024-04-02/part-0.parquet", ObjectName(DatasetAnalytics, date, 0, FormatParquet))
+	assert.Equal(t, "raw/date=2024-04-02/part-3.csv.gz.manifest.json", ManifestName(ObjectName(DatasetRaw, date, 3, FormatCSV)))
+}
+
+func TestParseFormats(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    []Format
+		expectedErr bool
+	}{
+		{input: "csv", expected: []Format{FormatCSV}},
+		{input: "parquet", expected: []Format{FormatParquet}},
+		{input: "csv, parquet", expected: []Format{FormatCSV, FormatParquet}},
+		{input: "avro", expectedErr: true},
+		{input: "", expectedErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			formats, err := ParseFormats(tt.input)
+			if tt.expectedErr {
+				assert.ErrorIs(t, err, ErrInvalidFormat)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, formats)
+		})
+	}
+}
+
+func TestArchiverRoundTrip(t *testing.T) {
+	date := time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)
+	createdAt := time.Date(2024, 4, 3, 1, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		corrupt     bool
+		expectedErr error
+	}{
+		{
+			name: "Intact archive",
+		},
+		{
+			name:        "Object changed after archiving",
+			corrupt:     true,
+			expectedErr: ErrChecksumMismatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := storage.NewLocalFSStorage(t.TempDir())
+			require.NoError(t, err)
+			archiver := NewArchiver(s)
+			archiver.now = func() time.Time { return createdAt }
+
+			records := [][]string{{"matic-network", "0.85000000"}, {"usd-coin", "1.00000000"}}
+			manifest, err := archiver.WriteCSV(DatasetPrices, date, 0, []string{"token", "average_price_usd"}, records)
+			require.NoError(t, err)
+			assert.Equal(t, "prices/date=2024-04-02/part-0.csv.gz", manifest.Object)
+			assert.Equal(t, 2, manifest.Rows)
+			assert.Equal(t, "2024-04-02", manifest.Date)
+			assert.Equal(t, createdAt, manifest.CreatedAt)
+			assert.Len(t, manifest.SHA256, 64)
+
+			stored, err := archiver.ReadManifest(manifest.Object)
+			require.NoError(t, err)
+			assert.Equal(t, manifest, stored)
+
+			if tt.corrupt {
+				require.NoError(t, s.UploadFile(manifest.Object, strings.NewReader("tampered")))
+			}
+
+			reader, err := archiver.Open(DatasetPrices, date, 0)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			data, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, "token,average_price_usd\nmatic-network,0.85000000\nusd-coin,1.00000000\n", string(data))
+		})
+	}
+}
+
+func TestArchiverOpenMissing(t *testing.T) {
+	s, err := storage.NewLocalFSStorage(t.TempDir())
+	require.NoError(t, err)
+
+	_, err = NewArchiver(s).Open(DatasetAnalytics, time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC), 0)
+	assert.ErrorIs(t, err, storage.ErrObjectNotFound)
+}
@@ -0,0 +1,171 @@
+package archive
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/estensen/marketplace-pipeline/internal/models"
+	"github.com/estensen/marketplace-pipeline/internal/storage"
+)
+
+// ArchivePrices archives the token prices of date in every configured format.
+func (a *Archiver) ArchivePrices(date time.Time, prices map[string]float64) error {
+	tokens := make([]string, 0, len(prices))
+	for token := range prices {
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+
+	if a.writes(FormatCSV) {
+		records := make([][]string, 0, len(tokens))
+		for _, token := range tokens {
+			records = append(records, []string{token, fmt.Sprintf("%.8f", prices[token])})
+		}
+		manifest, err := a.WriteCSV(DatasetPrices, date, 0, []string{"token", "average_price_usd"}, records)
+		if err != nil {
+			return err
+		}
+		log.Printf("Archived %d prices to %s", manifest.Rows, manifest.Object)
+	}
+
+	if a.writes(FormatParquet) {
+		rows := make([]PriceRecord, 0, len(tokens))
+		for _, token := range tokens {
+			rows = append(rows, PriceRecord{
+				Token:           token,
+				Date:            daysSinceEpoch(date),
+				AveragePriceUSD: decimalFromFloat(prices[token]),
+			})
+		}
+		manifest, err := writeParquet(a, DatasetPrices, date, 0, rows)
+		if err != nil {
+			return err
+		}
+		log.Printf("Archived %d prices to %s", manifest.Rows, manifest.Object)
+	}
+
+	return nil
+}
+
+// ReadPrices reads the token prices archived for date, preferring CSV over Parquet.
+func (a *Archiver) ReadPrices(date time.Time) (map[string]float64, error) {
+	reader, err := a.Open(DatasetPrices, date, 0)
+	if err == nil {
+		return ParsePrices(reader)
+	}
+	if !errors.Is(err, storage.ErrObjectNotFound) {
+		return nil, err
+	}
+
+	rows, err := readParquet[PriceRecord](a, DatasetPrices, date, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	prices := make(map[string]float64, len(rows))
+	for _, row := range rows {
+		prices[row.Token] = floatFromDecimal(row.AveragePriceUSD)
+	}
+	return prices, nil
+}
+
+// ParsePrices reads a token,average_price_usd CSV into a map of coin ID to price.
+func ParsePrices(r io.Reader) (map[string]float64, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPrices, err)
+	}
+	if len(records) == 0 || len(records[0]) != 2 || records[0][0] != "token" || records[0][1] != "average_price_usd" {
+		return nil, fmt.Errorf("%w: unexpected header", ErrInvalidPrices)
+	}
+
+	prices := make(map[string]float64, len(records)-1)
+	for _, record := range records[1:] {
+		price, err := strconv.ParseFloat(record[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid price for %s: %v", ErrInvalidPrices, record[0], err)
+		}
+		prices[record[0]] = price
+	}
+
+	return prices, nil
+}
+
+// ArchiveAnalytics archives the aggregated analytics as one partition per day in every configured format.
+func (a *Archiver) ArchiveAnalytics(data []models.AggregatedData) error {
+	byDate := make(map[time.Time][]models.AggregatedData)
+	var dates []time.Time
+	for _, d := range data {
+		if _, exists := byDate[d.Date]; !exists {
+			dates = append(dates, d.Date)
+		}
+		byDate[d.Date] = append(byDate[d.Date], d)
+	}
+
+	header := []string{"date", "project_id", "transaction_count", "total_volume_usd"}
+	for _, date := range dates {
+		if a.writes(FormatCSV) {
+			var records [][]string
+			for _, d := range byDate[date] {
+				records = append(records, []string{
+					d.Date.Format("2006-01-02"),
+					d.ProjectID,
+					strconv.FormatUint(d.TransactionCount, 10),
+					strconv.FormatFloat(d.TotalVolumeUSD, 'f', -1, 64),
+				})
+			}
+			if _, err := a.WriteCSV(DatasetAnalytics, date, 0, header, records); err != nil {
+				return err
+			}
+		}
+
+		if a.writes(FormatParquet) {
+			var rows []AnalyticsRecord
+			for _, d := range byDate[date] {
+				rows = append(rows, AnalyticsRecord{
+					Date:             daysSinceEpoch(d.Date),
+					ProjectID:        d.ProjectID,
+					TransactionCount: int64(d.TransactionCount),
+					TotalVolumeUSD:   decimalFromFloat(d.TotalVolumeUSD),
+				})
+			}
+			if _, err := writeParquet(a, DatasetAnalytics, date, 0, rows); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ArchiveRaw archives an input file as a raw part for date. CSV parts keep the input as is,
+// Parquet parts hold its parsed transactions.
+func (a *Archiver) ArchiveRaw(date time.Time, part int, raw io.Reader, transactions []models.Transaction) error {
+	if a.writes(FormatCSV) {
+		if _, err := a.Write(DatasetRaw, date, part, raw, len(transactions)); err != nil {
+			return err
+		}
+	}
+
+	if a.writes(FormatParquet) {
+		rows := make([]TransactionRecord, 0, len(transactions))
+		for _, txn := range transactions {
+			row, err := newTransactionRecord(txn)
+			if err != nil {
+				return err
+			}
+			rows = append(rows, row)
+		}
+		if _, err := writeParquet(a, DatasetRaw, date, part, rows); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/estensen/marketplace-pipeline/internal/models"
+)
+
+// usdScale is the number of fractional digits kept in USD decimal columns.
+const usdScale = 8
+
+// nativeScale is the number of fractional digits kept in native currency amounts, enough for 18-decimal tokens.
+const nativeScale = 18
+
+// ErrDecimalOverflow is returned for values that do not fit their decimal column.
+var ErrDecimalOverflow = errors.New("decimal value out of range")
+
+// PriceRecord is the Parquet schema of archived token prices.
+type PriceRecord struct {
+	Token           string `parquet:"token"`
+	Date            int32  `parquet:"date,date"`
+	AveragePriceUSD int64  `parquet:"average_price_usd,decimal(8:18)"`
+}
+
+// AnalyticsRecord is the Parquet schema of archived daily analytics.
+type AnalyticsRecord struct {
+	Date             int32  `parquet:"date,date"`
+	ProjectID        string `parquet:"project_id"`
+	TransactionCount int64  `parquet:"transaction_count"`
+	TotalVolumeUSD   int64  `parquet:"total_volume_usd,decimal(8:18)"`
+}
+
+// TransactionRecord is the Parquet schema of archived raw transactions.
+type TransactionRecord struct {
+	Timestamp            int64    `parquet:"ts,timestamp(millisecond)"`
+	Event                string   `parquet:"event"`
+	ProjectID            string   `parquet:"project_id"`
+	UserID               string   `parquet:"user_id"`
+	CurrencySymbol       string   `parquet:"currency_symbol"`
+	ChainID              string   `parquet:"chain_id"`
+	CollectionAddress    string   `parquet:"collection_address"`
+	CurrencyAddress      string   `parquet:"currency_address"`
+	TokenID              string   `parquet:"token_id"`
+	TxnHash              string   `parquet:"txn_hash"`
+	MarketplaceType      string   `parquet:"marketplace_type"`
+	RequestID            string   `parquet:"request_id"`
+	CurrencyValueDecimal [16]byte `parquet:"currency_value_decimal,decimal(18:38)"`
+	CurrencyValueRaw     string   `parquet:"currency_value_raw"`
+}
+
+// writeParquet encodes rows as a Parquet part of dataset for date.
+func writeParquet[T any](a *Archiver, dataset string, date time.Time, part int, rows []T) (Manifest, error) {
+	var buf bytes.Buffer
+	if err := parquet.Write(&buf, rows, parquet.Compression(&parquet.Zstd)); err != nil {
+		return Manifest{}, fmt.Errorf("error writing %s Parquet: %w", dataset, err)
+	}
+	return a.upload(dataset, date, part, FormatParquet, buf.Bytes(), len(rows))
+}
+
+// readParquet verifies a Parquet part of dataset for date against its manifest and decodes its rows.
+func readParquet[T any](a *Archiver, dataset string, date time.Time, part int) ([]T, error) {
+	data, err := a.download(dataset, date, part, FormatParquet)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := parquet.Read[T](bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s Parquet: %w", dataset, err)
+	}
+	return rows, nil
+}
+
+// newTransactionRecord converts a transaction to its Parquet schema.
+func newTransactionRecord(txn models.Transaction) (TransactionRecord, error) {
+	value, err := decimalBytes(txn.Nums.CurrencyValueDecimal, nativeScale)
+	if err != nil {
+		return TransactionRecord{}, fmt.Errorf("error converting value of %s: %w", txn.Props.TxnHash, err)
+	}
+
+	return TransactionRecord{
+		Timestamp:            txn.Timestamp.UnixMilli(),
+		Event:                txn.Event,
+		ProjectID:            txn.ProjectID,
+		UserID:               txn.UserID,
+		CurrencySymbol:       txn.Props.CurrencySymbol,
+		ChainID:              txn.Props.ChainID,
+		CollectionAddress:    txn.Props.CollectionAddress,
+		CurrencyAddress:      txn.Props.CurrencyAddress,
+		TokenID:              txn.Props.TokenID,
+		TxnHash:              txn.Props.TxnHash,
+		MarketplaceType:      txn.Props.MarketplaceType,
+		RequestID:            txn.Props.RequestID,
+		CurrencyValueDecimal: value,
+		CurrencyValueRaw:     txn.Nums.CurrencyValueRaw,
+	}, nil
+}
+
+// daysSinceEpoch converts a date to the Parquet DATE representation.
+func daysSinceEpoch(date time.Time) int32 {
+	return int32(date.Unix() / 86400)
+}
+
+// decimalFromFloat scales a USD value to an integer with usdScale fractional digits.
+func decimalFromFloat(v float64) int64 {
+	return int64(math.Round(v * math.Pow10(usdScale)))
+}
+
+// floatFromDecimal converts a USD decimal back to a float.
+func floatFromDecimal(v int64) float64 {
+	return float64(v) / math.Pow10(usdScale)
+}
+
+// decimalBytes encodes a decimal string as a 16-byte big-endian two's complement integer with scale
+// fractional digits. Extra fractional digits are truncated.
+func decimalBytes(s string, scale int) ([16]byte, error) {
+	var out [16]byte
+	if s == "" {
+		return out, nil
+	}
+
+	negative := strings.HasPrefix(s, "-")
+	whole, frac, _ := strings.Cut(strings.TrimPrefix(s, "-"), ".")
+	if len(frac) > scale {
+		frac = frac[:scale]
+	}
+	frac += strings.Repeat("0", scale-len(frac))
+
+	unscaled, ok := new(big.Int).SetString(whole+frac, 10)
+	if !ok {
+		return out, fmt.Errorf("invalid decimal %q", s)
+	}
+	if unscaled.BitLen() > 127 {
+		return out, fmt.Errorf("%w: %s", ErrDecimalOverflow, s)
+	}
+	if negative {
+		// Two's complement of a 128-bit value
+		unscaled.Sub(new(big.Int).Lsh(big.NewInt(1), 128), unscaled)
+	}
+
+	unscaled.FillBytes(out[:])
+	return out, nil
+}
@@ -0,0 +1,129 @@
+package archive
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/estensen/marketplace-pipeline/internal/models"
+	"github.com/estensen/marketplace-pipeline/internal/storage"
+)
+
+func TestDecimalBytes(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		expected    [16]byte
+		expectedErr bool
+	}{
+		{
+			name:     "Empty value",
+			input:    "",
+			expected: [16]byte{},
+		},
+		{
+			name:     "One unit",
+			input:    "0.000000000000000001",
+			expected: [16]byte{15: 1},
+		},
+		{
+			name:     "Extra digits truncated",
+			input:    "0.0000000000000000019",
+			expected: [16]byte{15: 1},
+		},
+		{
+			name:     "Negative",
+			input:    "-0.000000000000000001",
+			expected: [16]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		},
+		{
+			name:        "Not a number",
+			input:       "1.2.3",
+			expectedErr: true,
+		},
+		{
+			name:        "Too large",
+			input:       "1000000000000000000000",
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := decimalBytes(tt.input, nativeScale)
+			if tt.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, value)
+		})
+	}
+}
+
+func TestArchivePricesParquet(t *testing.T) {
+	date := time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)
+	s, err := storage.NewLocalFSStorage(t.TempDir())
+	require.NoError(t, err)
+
+	archiver := NewArchiver(s, FormatParquet)
+	prices := map[string]float64{"matic-network": 0.85, "usd-coin": 1.0001}
+	require.NoError(t, archiver.ArchivePrices(date, prices))
+
+	// Only the Parquet part is written
+	objects, err := s.List(PartitionPrefix(DatasetPrices, date))
+	require.NoError(t, err)
+	require.Len(t, objects, 2)
+	assert.Equal(t, "prices/date=2024-04-02/part-0.parquet", objects[0].Name)
+
+	manifest, err := archiver.ReadManifest(objects[0].Name)
+	require.NoError(t, err)
+	assert.Equal(t, FormatParquet, manifest.Format)
+	assert.Equal(t, 2, manifest.Rows)
+
+	stored, err := archiver.ReadPrices(date)
+	require.NoError(t, err)
+	assert.Equal(t, prices, stored)
+
+	rows, err := readParquet[PriceRecord](archiver, DatasetPrices, date, 0)
+	require.NoError(t, err)
+	assert.Equal(t, PriceRecord{Token: "matic-network", Date: 19815, AveragePriceUSD: 85000000}, rows[0])
+}
+
+func TestArchiveRawParquet(t *testing.T) {
+	date := time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)
+	s, err := storage.NewLocalFSStorage(t.TempDir())
+	require.NoError(t, err)
+
+	transactions := []models.Transaction{
+		{
+			Timestamp: time.Date(2024, 4, 2, 11, 5, 20, 123000000, time.UTC),
+			Event:     "BUY_ITEMS",
+			ProjectID: "4974",
+			Props:     models.Props{CurrencySymbol: "SFL", TxnHash: "0xabc", TokenID: "1"},
+			Nums:      models.Nums{CurrencyValueDecimal: "0.5", CurrencyValueRaw: "500000000000000000"},
+		},
+	}
+
+	archiver := NewArchiver(s, FormatCSV, FormatParquet)
+	require.NoError(t, archiver.ArchiveRaw(date, 0, bytes.NewReader([]byte("header\nrow\n")), transactions))
+
+	for _, format := range []Format{FormatCSV, FormatParquet} {
+		manifest, err := archiver.ReadManifest(ObjectName(DatasetRaw, date, 0, format))
+		require.NoError(t, err)
+		assert.Equal(t, 1, manifest.Rows)
+	}
+
+	rows, err := readParquet[TransactionRecord](archiver, DatasetRaw, date, 0)
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	assert.Equal(t, transactions[0].Timestamp.UnixMilli(), rows[0].Timestamp)
+	assert.Equal(t, "0xabc", rows[0].TxnHash)
+
+	expected, err := decimalBytes("0.5", nativeScale)
+	require.NoError(t, err)
+	assert.Equal(t, expected, rows[0].CurrencyValueDecimal)
+}
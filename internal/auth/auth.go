@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/estensen/marketplace-pipeline/internal/models"
+)
+
+// KeyPrefix starts every generated API key, so leaked keys are easy to recognize.
+const KeyPrefix = "mp_"
+
+// ErrInvalidKeyFile is returned when the API key file can't be parsed.
+var ErrInvalidKeyFile = errors.New("invalid API key file")
+
+// KeyStore looks up API keys by the SHA-256 hash of the key.
+type KeyStore interface {
+	FindAPIKey(ctx context.Context, keyHash string) (models.APIKey, bool, error)
+}
+
+// FileKeyStore implements KeyStore with the keys listed in a JSON file.
+type FileKeyStore struct {
+	keys map[string]models.APIKey
+}
+
+// NewFileKeyStore creates a FileKeyStore serving keys.
+func NewFileKeyStore(keys []models.APIKey) *FileKeyStore {
+	store := &FileKeyStore{keys: make(map[string]models.APIKey, len(keys))}
+	for _, key := range keys {
+		store.keys[key.KeyHash] = key
+	}
+	return store
+}
+
+// LoadKeyFile reads a JSON array of API keys, each holding the hex-encoded SHA-256 hash of the key.
+func LoadKeyFile(path string) (*FileKeyStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading API key file: %w", err)
+	}
+
+	var keys []models.APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKeyFile, err)
+	}
+	for i, key := range keys {
+		if key.Name == "" {
+			return nil, fmt.Errorf("%w: key %d has no name", ErrInvalidKeyFile, i)
+		}
+		if hash, err := hex.DecodeString(key.KeyHash); err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("%w: key %s has no hex-encoded SHA-256 key_sha256", ErrInvalidKeyFile, key.Name)
+		}
+	}
+
+	return NewFileKeyStore(keys), nil
+}
+
+// FindAPIKey returns the key with the given hash.
+func (s *FileKeyStore) FindAPIKey(ctx context.Context, keyHash string) (models.APIKey, bool, error) {
+	key, ok := s.keys[keyHash]
+	return key, ok, nil
+}
+
+// HashKey returns the hex-encoded SHA-256 hash under which key is stored.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateKey returns a new random API key.
+func GenerateKey() (string, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("error generating API key: %w", err)
+	}
+	return KeyPrefix + hex.EncodeToString(secret), nil
+}
+
+// Allows reports whether key may read the data of projectID. Keys without project IDs may read every project.
+func Allows(key models.APIKey, projectID string) bool {
+	return len(key.ProjectIDs) == 0 || slices.Contains(key.ProjectIDs, projectID)
+}
+
+// keyContextKey is the context key of the authenticated API key.
+type keyContextKey struct{}
+
+// WithKey returns a copy of ctx carrying the authenticated key.
+func WithKey(ctx context.Context, key models.APIKey) context.Context {
+	return context.WithValue(ctx, keyContextKey{}, key)
+}
+
+// KeyFromContext returns the key stored by WithKey.
+func KeyFromContext(ctx context.Context) (models.APIKey, bool) {
+	key, ok := ctx.Value(keyContextKey{}).(models.APIKey)
+	return key, ok
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/estensen/marketplace-pipeline/internal/models"
+)
+
+func TestLoadKeyFile(t *testing.T) {
+	hash := HashKey("mp_secret")
+
+	tests := []struct {
+		name        string
+		content     string
+		expected    models.APIKey
+		expectedErr error
+	}{
+		{
+			name:     "Valid keys",
+			content:  `[{"name": "partner", "key_sha256": "` + hash + `", "project_ids": ["4974"], "rate_per_second": 2, "burst": 5}]`,
+			expected: models.APIKey{Name: "partner", KeyHash: hash, ProjectIDs: []string{"4974"}, RatePerSecond: 2, Burst: 5},
+		},
+		{
+			name:        "Malformed JSON",
+			content:     `{"name":`,
+			expectedErr: ErrInvalidKeyFile,
+		},
+		{
+			name:        "Missing name",
+			content:     `[{"key_sha256": "` + hash + `"}]`,
+			expectedErr: ErrInvalidKeyFile,
+		},
+		{
+			name:        "Plaintext key instead of a hash",
+			content:     `[{"name": "partner", "key_sha256": "mp_secret"}]`,
+			expectedErr: ErrInvalidKeyFile,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keys.json")
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0o600))
+
+			store, err := LoadKeyFile(path)
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+
+			key, found, err := store.FindAPIKey(context.Background(), hash)
+			require.NoError(t, err)
+			require.True(t, found)
+			assert.Equal(t, tt.expected, key)
+
+			_, found, err = store.FindAPIKey(context.Background(), HashKey("mp_other"))
+			require.NoError(t, err)
+			assert.False(t, found)
+		})
+	}
+}
+
+func TestGenerateKey(t *testing.T) {
+	first, err := GenerateKey()
+	require.NoError(t, err)
+	second, err := GenerateKey()
+	require.NoError(t, err)
+
+	assert.True(t, len(first) > len(KeyPrefix) && first[:len(KeyPrefix)] == KeyPrefix)
+	assert.NotEqual(t, first, second)
+	assert.Len(t, HashKey(first), 64)
+}
+
+func TestAllows(t *testing.T) {
+	assert.True(t, Allows(models.APIKey{}, "4974"))
+	assert.True(t, Allows(models.APIKey{ProjectIDs: []string{"4974"}}, "4974"))
+	assert.False(t, Allows(models.APIKey{ProjectIDs: []string{"4974"}}, "1609"))
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/estensen/marketplace-pipeline/internal/models"
+)
+
+// Rate limits of keys that don't set their own.
+const (
+	DefaultRatePerSecond = 10
+	DefaultBurst         = 20
+)
+
+// Decision is the outcome of a rate-limited request.
+type Decision struct {
+	Allowed bool
+	// Limit is the bucket capacity, the most requests that can be made at once.
+	Limit int
+	// Remaining is the number of requests that can be made right away.
+	Remaining int
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed, zero when it is allowed now.
+	RetryAfter time.Duration
+}
+
+// Limiter rate-limits each API key with its own token bucket.
+type Limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+// bucket holds the tokens of a key as of last.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter creates a Limiter with full buckets.
+func NewLimiter() *Limiter {
+	return &Limiter{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+// Allow takes a token from the bucket of key, refilled at the key's rate since the last request.
+func (l *Limiter) Allow(key models.APIKey) Decision {
+	rate, burst := limits(key)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[key.KeyHash]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[key.KeyHash] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	decision := Decision{Limit: int(burst)}
+	if b.tokens >= 1 {
+		b.tokens--
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = seconds((1 - b.tokens) / rate)
+	}
+	decision.Remaining = int(b.tokens)
+	decision.Reset = seconds((burst - b.tokens) / rate)
+	return decision
+}
+
+// limits returns the refill rate per second and the capacity of the bucket of key.
+func limits(key models.APIKey) (float64, float64) {
+	rate, burst := key.RatePerSecond, float64(key.Burst)
+	if rate <= 0 {
+		rate = DefaultRatePerSecond
+	}
+	if burst < 1 {
+		burst = DefaultBurst
+	}
+	return rate, burst
+}
+
+// seconds converts a number of seconds to a duration.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
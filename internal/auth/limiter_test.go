@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/estensen/marketplace-pipeline/internal/models"
+)
+
+func TestLimiterAllow(t *testing.T) {
+	now := time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)
+	limiter := NewLimiter()
+	limiter.now = func() time.Time { return now }
+	key := models.APIKey{KeyHash: "a", RatePerSecond: 1, Burst: 2}
+
+	// The bucket starts full
+	assert.Equal(t, Decision{Allowed: true, Limit: 2, Remaining: 1, Reset: time.Second}, limiter.Allow(key))
+	assert.Equal(t, Decision{Allowed: true, Limit: 2, Remaining: 0, Reset: 2 * time.Second}, limiter.Allow(key))
+
+	// Empty buckets reject until a token is refilled
+	assert.Equal(t, Decision{Limit: 2, Remaining: 0, Reset: 2 * time.Second, RetryAfter: time.Second}, limiter.Allow(key))
+	now = now.Add(500 * time.Millisecond)
+	assert.Equal(t, Decision{Limit: 2, Remaining: 0, Reset: 1500 * time.Millisecond, RetryAfter: 500 * time.Millisecond}, limiter.Allow(key))
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, limiter.Allow(key).Allowed)
+
+	// Buckets never exceed their capacity, and each key has its own
+	now = now.Add(time.Hour)
+	assert.Equal(t, 1, limiter.Allow(key).Remaining)
+	assert.Equal(t, DefaultBurst-1, limiter.Allow(models.APIKey{KeyHash: "b"}).Remaining)
+}
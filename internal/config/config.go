@@ -0,0 +1,168 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/estensen/marketplace-pipeline/internal/archive"
+)
+
+// Supported database backends.
+const (
+	DatabaseClickHouse = "clickhouse"
+	DatabaseSQLite     = "sqlite"
+)
+
+// Supported object storage backends.
+const (
+	StorageMinIO = "minio"
+	StorageLocal = "local"
+)
+
+// Supported API key stores.
+const (
+	AuthNone       = "none"
+	AuthFile       = "file"
+	AuthClickHouse = "clickhouse"
+)
+
+// Config holds the runtime settings of the pipeline, read from the environment.
+type Config struct {
+	// DatabaseBackend selects where analytics and prices are stored: "clickhouse" or "sqlite".
+	DatabaseBackend string
+	// SQLitePath is the database file used by the SQLite backend.
+	SQLitePath string
+	// StorageBackend selects where price snapshots and archives are written: "minio" or "local".
+	StorageBackend string
+	// StorageRoot is the directory used by the local storage backend.
+	StorageRoot string
+	// InputURI names the transaction exports to process: a path, file:// or s3:// URI, optionally with globs, or "-" for stdin.
+	InputURI string
+	// ArchiveFormats lists the formats archives are written in: csv, parquet or both.
+	ArchiveFormats []archive.Format
+	// HTTP configures the API server.
+	HTTP HTTPConfig
+	// AuthBackend selects where API keys are looked up: "none" leaves the API open, "file" or "clickhouse".
+	AuthBackend string
+	// APIKeysFile is the JSON file of hashed API keys used by the file auth backend.
+	APIKeysFile string
+}
+
+// HTTPConfig holds the listen address and timeouts of the API server.
+type HTTPConfig struct {
+	// Addr is the TCP address the API listens on.
+	Addr string
+	// ReadHeaderTimeout bounds reading the request headers.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout bounds reading the whole request, including the body.
+	ReadTimeout time.Duration
+	// WriteTimeout bounds handling a request and writing its response.
+	WriteTimeout time.Duration
+	// IdleTimeout bounds how long keep-alive connections wait for the next request.
+	IdleTimeout time.Duration
+	// ShutdownTimeout bounds how long in-flight requests are drained on shutdown.
+	ShutdownTimeout time.Duration
+	// CacheSize is the number of metrics responses cached in memory, zero to disable the cache.
+	CacheSize int
+}
+
+// Load reads the configuration from environment variables, falling back to defaults.
+func Load() (Config, error) {
+	cfg := Config{
+		DatabaseBackend: getEnv("PIPELINE_DATABASE", DatabaseClickHouse),
+		SQLitePath:      getEnv("PIPELINE_SQLITE_PATH", "data/pipeline.db"),
+		StorageBackend:  getEnv("PIPELINE_STORAGE", StorageMinIO),
+		StorageRoot:     getEnv("PIPELINE_STORAGE_ROOT", "data/objects"),
+		InputURI:        getEnv("PIPELINE_INPUT", "data/sample.csv"),
+		AuthBackend:     getEnv("PIPELINE_AUTH", AuthNone),
+		APIKeysFile:     getEnv("PIPELINE_API_KEYS_FILE", "config/api_keys.json"),
+	}
+
+	switch cfg.DatabaseBackend {
+	case DatabaseClickHouse, DatabaseSQLite:
+	default:
+		return Config{}, fmt.Errorf("unsupported database backend %q, use %q or %q", cfg.DatabaseBackend, DatabaseClickHouse, DatabaseSQLite)
+	}
+
+	formats, err := archive.ParseFormats(getEnv("PIPELINE_ARCHIVE_FORMATS", string(archive.FormatCSV)))
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.ArchiveFormats = formats
+
+	switch cfg.AuthBackend {
+	case AuthNone, AuthFile:
+	case AuthClickHouse:
+		if cfg.DatabaseBackend != DatabaseClickHouse {
+			return Config{}, fmt.Errorf("auth backend %q requires the %q database backend", AuthClickHouse, DatabaseClickHouse)
+		}
+	default:
+		return Config{}, fmt.Errorf("unsupported auth backend %q, use %q, %q or %q", cfg.AuthBackend, AuthNone, AuthFile, AuthClickHouse)
+	}
+
+	httpCfg, err := loadHTTP()
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.HTTP = httpCfg
+
+	switch cfg.StorageBackend {
+	case StorageMinIO, StorageLocal:
+	default:
+		return Config{}, fmt.Errorf("unsupported storage backend %q, use %q or %q", cfg.StorageBackend, StorageMinIO, StorageLocal)
+	}
+
+	return cfg, nil
+}
+
+// loadHTTP reads the API server settings. Timeouts are Go durations such as "30s".
+func loadHTTP() (HTTPConfig, error) {
+	cacheSize, err := strconv.Atoi(getEnv("PIPELINE_HTTP_CACHE_SIZE", "256"))
+	if err != nil || cacheSize < 0 {
+		return HTTPConfig{}, fmt.Errorf("invalid PIPELINE_HTTP_CACHE_SIZE %q, use a number of responses", os.Getenv("PIPELINE_HTTP_CACHE_SIZE"))
+	}
+
+	cfg := HTTPConfig{Addr: getEnv("PIPELINE_HTTP_ADDR", ":8080"), CacheSize: cacheSize}
+	timeouts := []struct {
+		key      string
+		fallback time.Duration
+		target   *time.Duration
+	}{
+		{"PIPELINE_HTTP_READ_HEADER_TIMEOUT", 5 * time.Second, &cfg.ReadHeaderTimeout},
+		{"PIPELINE_HTTP_READ_TIMEOUT", 10 * time.Second, &cfg.ReadTimeout},
+		{"PIPELINE_HTTP_WRITE_TIMEOUT", 30 * time.Second, &cfg.WriteTimeout},
+		{"PIPELINE_HTTP_IDLE_TIMEOUT", 60 * time.Second, &cfg.IdleTimeout},
+		{"PIPELINE_HTTP_SHUTDOWN_TIMEOUT", 15 * time.Second, &cfg.ShutdownTimeout},
+	}
+	for _, timeout := range timeouts {
+		value, err := getDuration(timeout.key, timeout.fallback)
+		if err != nil {
+			return HTTPConfig{}, err
+		}
+		*timeout.target = value
+	}
+	return cfg, nil
+}
+
+// getDuration parses the environment variable key as a positive duration, or returns fallback when it is unset or empty.
+func getDuration(key string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s %q, use a positive duration such as \"30s\"", key, value)
+	}
+	return d, nil
+}
+
+// getEnv returns the value of the environment variable key, or fallback when it is unset or empty.
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
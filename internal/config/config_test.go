@@ -0,0 +1,217 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/estensen/marketplace-pipeline/internal/archive"
+)
+
+var defaultHTTP = HTTPConfig{
+	Addr:              ":8080",
+	ReadHeaderTimeout: 5 * time.Second,
+	ReadTimeout:       10 * time.Second,
+	WriteTimeout:      30 * time.Second,
+	IdleTimeout:       60 * time.Second,
+	ShutdownTimeout:   15 * time.Second,
+	CacheSize:         256,
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         map[string]string
+		expected    Config
+		expectedErr bool
+	}{
+		{
+			name: "Defaults",
+			env:  map[string]string{},
+			expected: Config{
+				DatabaseBackend: DatabaseClickHouse,
+				SQLitePath:      "data/pipeline.db",
+				StorageBackend:  StorageMinIO,
+				StorageRoot:     "data/objects",
+				InputURI:        "data/sample.csv",
+				ArchiveFormats:  []archive.Format{archive.FormatCSV},
+				HTTP:            defaultHTTP,
+				AuthBackend:     AuthNone,
+				APIKeysFile:     "config/api_keys.json",
+			},
+		},
+		{
+			name: "SQLite backend",
+			env: map[string]string{
+				"PIPELINE_DATABASE":    "sqlite",
+				"PIPELINE_SQLITE_PATH": "/tmp/pipeline.db",
+			},
+			expected: Config{
+				DatabaseBackend: DatabaseSQLite,
+				SQLitePath:      "/tmp/pipeline.db",
+				StorageBackend:  StorageMinIO,
+				StorageRoot:     "data/objects",
+				InputURI:        "data/sample.csv",
+				ArchiveFormats:  []archive.Format{archive.FormatCSV},
+				HTTP:            defaultHTTP,
+				AuthBackend:     AuthNone,
+				APIKeysFile:     "config/api_keys.json",
+			},
+		},
+		{
+			name: "Local storage backend",
+			env: map[string]string{
+				"PIPELINE_STORAGE":      "local",
+				"PIPELINE_STORAGE_ROOT": "/tmp/objects",
+			},
+			expected: Config{
+				DatabaseBackend: DatabaseClickHouse,
+				SQLitePath:      "data/pipeline.db",
+				StorageBackend:  StorageLocal,
+				StorageRoot:     "/tmp/objects",
+				InputURI:        "data/sample.csv",
+				ArchiveFormats:  []archive.Format{archive.FormatCSV},
+				HTTP:            defaultHTTP,
+				AuthBackend:     AuthNone,
+				APIKeysFile:     "config/api_keys.json",
+			},
+		},
+		{
+			name: "Input from object storage",
+			env:  map[string]string{"PIPELINE_INPUT": "s3://exports/2024/04/*.csv.gz"},
+			expected: Config{
+				DatabaseBackend: DatabaseClickHouse,
+				SQLitePath:      "data/pipeline.db",
+				StorageBackend:  StorageMinIO,
+				StorageRoot:     "data/objects",
+				InputURI:        "s3://exports/2024/04/*.csv.gz",
+				ArchiveFormats:  []archive.Format{archive.FormatCSV},
+				HTTP:            defaultHTTP,
+				AuthBackend:     AuthNone,
+				APIKeysFile:     "config/api_keys.json",
+			},
+		},
+		{
+			name: "Parquet archives alongside CSV",
+			env:  map[string]string{"PIPELINE_ARCHIVE_FORMATS": "csv,parquet"},
+			expected: Config{
+				DatabaseBackend: DatabaseClickHouse,
+				SQLitePath:      "data/pipeline.db",
+				StorageBackend:  StorageMinIO,
+				StorageRoot:     "data/objects",
+				InputURI:        "data/sample.csv",
+				ArchiveFormats:  []archive.Format{archive.FormatCSV, archive.FormatParquet},
+				HTTP:            defaultHTTP,
+				AuthBackend:     AuthNone,
+				APIKeysFile:     "config/api_keys.json",
+			},
+		},
+		{
+			name: "HTTP server settings",
+			env: map[string]string{
+				"PIPELINE_HTTP_ADDR":             "127.0.0.1:9090",
+				"PIPELINE_HTTP_WRITE_TIMEOUT":    "2m",
+				"PIPELINE_HTTP_SHUTDOWN_TIMEOUT": "500ms",
+				"PIPELINE_HTTP_CACHE_SIZE":       "0",
+			},
+			expected: Config{
+				DatabaseBackend: DatabaseClickHouse,
+				SQLitePath:      "data/pipeline.db",
+				StorageBackend:  StorageMinIO,
+				StorageRoot:     "data/objects",
+				InputURI:        "data/sample.csv",
+				ArchiveFormats:  []archive.Format{archive.FormatCSV},
+				HTTP: HTTPConfig{
+					Addr:              "127.0.0.1:9090",
+					ReadHeaderTimeout: 5 * time.Second,
+					ReadTimeout:       10 * time.Second,
+					WriteTimeout:      2 * time.Minute,
+					IdleTimeout:       60 * time.Second,
+					ShutdownTimeout:   500 * time.Millisecond,
+					CacheSize:         0,
+				},
+				AuthBackend: AuthNone,
+				APIKeysFile: "config/api_keys.json",
+			},
+		},
+		{
+			name: "API keys from a file",
+			env: map[string]string{
+				"PIPELINE_AUTH":          "file",
+				"PIPELINE_API_KEYS_FILE": "/etc/pipeline/keys.json",
+			},
+			expected: Config{
+				DatabaseBackend: DatabaseClickHouse,
+				SQLitePath:      "data/pipeline.db",
+				StorageBackend:  StorageMinIO,
+				StorageRoot:     "data/objects",
+				InputURI:        "data/sample.csv",
+				ArchiveFormats:  []archive.Format{archive.FormatCSV},
+				HTTP:            defaultHTTP,
+				AuthBackend:     AuthFile,
+				APIKeysFile:     "/etc/pipeline/keys.json",
+			},
+		},
+		{
+			name:        "Unsupported auth backend",
+			env:         map[string]string{"PIPELINE_AUTH": "oauth"},
+			expectedErr: true,
+		},
+		{
+			name:        "ClickHouse API keys with SQLite",
+			env:         map[string]string{"PIPELINE_AUTH": "clickhouse", "PIPELINE_DATABASE": "sqlite"},
+			expectedErr: true,
+		},
+		{
+			name:        "Invalid HTTP timeout",
+			env:         map[string]string{"PIPELINE_HTTP_READ_TIMEOUT": "ten"},
+			expectedErr: true,
+		},
+		{
+			name:        "Invalid cache size",
+			env:         map[string]string{"PIPELINE_HTTP_CACHE_SIZE": "-1"},
+			expectedErr: true,
+		},
+		{
+			name:        "Non-positive HTTP timeout",
+			env:         map[string]string{"PIPELINE_HTTP_IDLE_TIMEOUT": "0s"},
+			expectedErr: true,
+		},
+		{
+			name:        "Unsupported database backend",
+			env:         map[string]string{"PIPELINE_DATABASE": "postgres"},
+			expectedErr: true,
+		},
+		{
+			name:        "Unsupported archive format",
+			env:         map[string]string{"PIPELINE_ARCHIVE_FORMATS": "avro"},
+			expectedErr: true,
+		},
+		{
+			name:        "Unsupported storage backend",
+			env:         map[string]string{"PIPELINE_STORAGE": "gcs"},
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"PIPELINE_DATABASE", "PIPELINE_SQLITE_PATH", "PIPELINE_STORAGE", "PIPELINE_STORAGE_ROOT", "PIPELINE_INPUT", "PIPELINE_ARCHIVE_FORMATS",
+				"PIPELINE_HTTP_ADDR", "PIPELINE_HTTP_READ_HEADER_TIMEOUT", "PIPELINE_HTTP_READ_TIMEOUT", "PIPELINE_HTTP_WRITE_TIMEOUT",
+				"PIPELINE_HTTP_IDLE_TIMEOUT", "PIPELINE_HTTP_SHUTDOWN_TIMEOUT", "PIPELINE_HTTP_CACHE_SIZE", "PIPELINE_AUTH", "PIPELINE_API_KEYS_FILE"} {
+				t.Setenv(key, tt.env[key])
+			}
+
+			cfg, err := Load()
+			if tt.expectedErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, cfg)
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/estensen/marketplace-pipeline/internal/models"
+)
+
+// FindAPIKey returns the active API key with the given hash.
+func (r *ClickHouseRepository) FindAPIKey(ctx context.Context, keyHash string) (models.APIKey, bool, error) {
+	query := `
+        SELECT name, key_hash, project_ids, rate_per_second, burst
+        FROM api_keys FINAL
+        WHERE key_hash = ? AND revoked = 0
+        `
+	var keys []models.APIKey
+	if err := r.Conn.Select(ctx, &keys, query, keyHash); err != nil {
+		return models.APIKey{}, false, fmt.Errorf("error executing API key query: %w", err)
+	}
+	if len(keys) == 0 {
+		return models.APIKey{}, false, nil
+	}
+	return keys[0], true, nil
+}
+
+// StoreAPIKey inserts or replaces an API key.
+func (r *ClickHouseRepository) StoreAPIKey(ctx context.Context, key models.APIKey) error {
+	query := `
+        INSERT INTO api_keys (name, key_hash, project_ids, rate_per_second, burst, revoked, updated_at)
+        VALUES (?, ?, ?, ?, ?, 0, ?)
+        `
+	if err := r.Conn.Exec(ctx, query, key.Name, key.KeyHash, key.ProjectIDs, key.RatePerSecond, key.Burst, time.Now().UTC()); err != nil {
+		return fmt.Errorf("error storing API key: %w", err)
+	}
+	return nil
+}
@@ -1,112 +1,94 @@
 package database
 
 import (
-	"bytes"
 	"context"
-	"encoding/csv"
 	"fmt"
+	"log"
 	"time"
 
-	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/estensen/marketplace-pipeline/internal/archive"
 	"github.com/estensen/marketplace-pipeline/internal/price"
-	"github.com/estensen/marketplace-pipeline/internal/storage"
 )
 
 // BatchJob represents a job to fetch and store token prices.
 type BatchJob struct {
-	CoinAPI price.CoinAPI
-	Conn    clickhouse.Conn
-	Storage storage.Storage
+	CoinAPI  price.CoinAPI
+	Prices   PriceRepository
+	Archiver *archive.Archiver
+	// Overwrite fetches the prices of every token again, storing them as corrections of the prices already stored.
+	// Otherwise only the tokens without a price for the date are fetched.
+	Overwrite bool
 }
 
 // NewBatchJob creates a new BatchJob.
-func NewBatchJob(coinAPI price.CoinAPI, conn clickhouse.Conn, storage storage.Storage) *BatchJob {
+func NewBatchJob(coinAPI price.CoinAPI, prices PriceRepository, archiver *archive.Archiver) *BatchJob {
 	return &BatchJob{
-		CoinAPI: coinAPI,
-		Conn:    conn,
-		Storage: storage,
+		CoinAPI:  coinAPI,
+		Prices:   prices,
+		Archiver: archiver,
 	}
 }
 
-// RunDailyBatchJob fetches prices and stores them in ClickHouse and MinIO.
+// RunDailyBatchJob fetches the prices of coinIDs for date, stores them in the price repository and archives every
+// price stored for the date in object storage. Tokens already priced for the date are skipped unless Overwrite is set.
 func (b *BatchJob) RunDailyBatchJob(ctx context.Context, coinIDs []string, date time.Time) error {
-	// Check if prices for the given date already exist in ClickHouse
-	var count uint64
-	query := "SELECT COUNT(*) FROM token_prices WHERE date = ?"
-	if err := b.Conn.QueryRow(ctx, query, date).Scan(&count); err != nil {
-		return fmt.Errorf("error querying ClickHouse: %v", err)
+	missing, err := b.missingCoinIDs(ctx, coinIDs, date)
+	if err != nil {
+		return err
 	}
-
-	if count > 0 {
-		return fmt.Errorf("prices for the date %s already exist, skipping batch insertion", date.Format("2006-01-02"))
+	if len(missing) == 0 {
+		log.Printf("Prices for %s are already stored", date.Format("2006-01-02"))
+		return nil
 	}
 
-	// Fetch prices for all tokens
-	prices, err := b.CoinAPI.GetHistoricalPrices(coinIDs, date)
+	// Fetch prices for the missing tokens, grouped by where they came from
+	bySource, err := price.HistoricalPricesBySource(b.CoinAPI, missing, date)
 	if err != nil {
 		return fmt.Errorf("error fetching prices: %w", err)
 	}
 
-	// Prepare batch insertion
-	batch, err := b.Conn.PrepareBatch(ctx, "INSERT INTO token_prices (token, date, average_price_usd)")
-	if err != nil {
-		return fmt.Errorf("error preparing ClickHouse batch: %w", err)
-	}
-
-	// Insert each token's price into ClickHouse
-	for coinID, priceUSD := range prices {
-		err := batch.Append(coinID, date, priceUSD)
-		if err != nil {
-			return fmt.Errorf("error appending to ClickHouse batch: %w", err)
+	// Store each token's price along with its source
+	for source, sourcePrices := range bySource {
+		if len(sourcePrices) == 0 {
+			continue
+		}
+		if err := b.Prices.StorePrices(ctx, date, source, sourcePrices); err != nil {
+			return err
 		}
 	}
 
-	// Send the batch to ClickHouse
-	if err := batch.Send(); err != nil {
-		return fmt.Errorf("error sending batch to ClickHouse: %w", err)
-	}
-
-	// Store the prices in MinIO as a CSV file
-	err = b.StorePricesInMinIO(prices, date)
+	// Archive the prices in object storage, including those stored by earlier jobs, as the archive replaces the date
+	history, err := b.Prices.FetchPriceHistory(ctx, date, date, nil)
 	if err != nil {
-		return fmt.Errorf("error storing prices in MinIO: %w", err)
+		return fmt.Errorf("error fetching stored prices: %w", err)
+	}
+	prices := make(map[string]float64, len(history))
+	for _, p := range history {
+		prices[p.Token] = p.AveragePriceUSD
+	}
+	if err := b.Archiver.ArchivePrices(date, prices); err != nil {
+		return fmt.Errorf("error archiving prices: %w", err)
 	}
 
 	return nil
 }
 
-// StorePricesInMinIO saves the token prices to MinIO in CSV format.
-func (b *BatchJob) StorePricesInMinIO(prices map[string]float64, date time.Time) error {
-	// Create a CSV in memory
-	var buf bytes.Buffer
-	writer := csv.NewWriter(&buf)
-
-	// Write the CSV header
-	if err := writer.Write([]string{"token", "average_price_usd"}); err != nil {
-		return fmt.Errorf("error writing CSV header: %w", err)
+// missingCoinIDs returns the coin IDs to fetch prices of: those without a stored price for date, or all of them
+// when overwriting.
+func (b *BatchJob) missingCoinIDs(ctx context.Context, coinIDs []string, date time.Time) ([]string, error) {
+	if b.Overwrite {
+		return coinIDs, nil
 	}
 
-	// Write the price records
-	for token, price := range prices {
-		record := []string{token, fmt.Sprintf("%.8f", price)}
-		if err := writer.Write(record); err != nil {
-			return fmt.Errorf("error writing CSV record: %w", err)
-		}
-	}
-
-	writer.Flush()
-	if err := writer.Error(); err != nil {
-		return fmt.Errorf("error flushing CSV writer: %w", err)
-	}
-
-	// Define the object name
-	objectName := fmt.Sprintf("prices-%s.csv", date.Format("2006-01-02"))
-
-	// Upload the CSV to MinIO
-	err := b.Storage.UploadFile(objectName, bytes.NewReader(buf.Bytes()))
+	stored, err := b.Prices.FetchPrices(ctx, coinIDs, date)
 	if err != nil {
-		return fmt.Errorf("error uploading file to MinIO: %w", err)
+		return nil, fmt.Errorf("error fetching stored prices: %w", err)
 	}
-
-	return nil
+	var missing []string
+	for _, coinID := range coinIDs {
+		if _, found := stored[coinID]; !found {
+			missing = append(missing, coinID)
+		}
+	}
+	return missing, nil
 }
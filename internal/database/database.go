@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/estensen/marketplace-pipeline/internal/models"
 )
 
 // NewClickHouseConnection initializes and returns a ClickHouse connection.
@@ -29,12 +30,54 @@ func NewClickHouseConnection(ctx context.Context) clickhouse.Conn {
 	return conn
 }
 
-// FetchPrices retrieves token prices from ClickHouse for the given coin IDs and date.
-func FetchPrices(ctx context.Context, conn clickhouse.Conn, coinIDs []string, date time.Time) (map[string]float64, error) {
+// HasPrices reports whether any token prices are stored in ClickHouse for the given date.
+func (r *ClickHouseRepository) HasPrices(ctx context.Context, date time.Time) (bool, error) {
+	var count uint64
+	query := "SELECT COUNT(*) FROM token_prices WHERE date = ?"
+	if err := r.Conn.QueryRow(ctx, query, date).Scan(&count); err != nil {
+		return false, fmt.Errorf("error querying ClickHouse: %v", err)
+	}
+	return count > 0, nil
+}
+
+// StorePrices inserts the token prices for the given date, as fetched from source, into ClickHouse.
+func (r *ClickHouseRepository) StorePrices(ctx context.Context, date time.Time, source string, prices map[string]float64) error {
+	// Prepare batch insertion
+	batch, err := r.Conn.PrepareBatch(ctx, "INSERT INTO token_prices (token, date, average_price_usd, source, fetched_at)")
+	if err != nil {
+		return fmt.Errorf("error preparing ClickHouse batch: %w", err)
+	}
+
+	// Insert each token's price into ClickHouse
+	fetchedAt := time.Now()
+	for coinID, priceUSD := range prices {
+		err := batch.Append(coinID, date, priceUSD, source, fetchedAt)
+		if err != nil {
+			return fmt.Errorf("error appending to ClickHouse batch: %w", err)
+		}
+	}
+
+	// Send the batch to ClickHouse
+	if err := r.sendBatch("token_prices", batch); err != nil {
+		return fmt.Errorf("error sending batch to ClickHouse: %w", err)
+	}
+
+	return nil
+}
+
+// FetchPrices retrieves the latest token prices from ClickHouse for the given coin IDs and date.
+func (r *ClickHouseRepository) FetchPrices(ctx context.Context, coinIDs []string, date time.Time) (map[string]float64, error) {
 	prices := make(map[string]float64)
-	query := "SELECT token, average_price_usd FROM token_prices WHERE token IN (?) AND date = ?"
+	query := `
+        SELECT
+            token,
+            argMax(average_price_usd, fetched_at) AS average_price_usd
+        FROM token_prices
+        WHERE token IN (?) AND date = ?
+        GROUP BY token
+        `
 
-	rows, err := conn.Query(ctx, query, coinIDs, date)
+	rows, err := r.Conn.Query(ctx, query, coinIDs, date)
 	if err != nil {
 		return nil, fmt.Errorf("error executing price query: %w", err)
 	}
@@ -55,3 +98,55 @@ func FetchPrices(ctx context.Context, conn clickhouse.Conn, coinIDs []string, da
 
 	return prices, nil
 }
+
+// FetchPriceHistory retrieves the latest price of each of tokens, or of every token when tokens is empty,
+// for each day between from and to, inclusive, ordered by date and token.
+func (r *ClickHouseRepository) FetchPriceHistory(ctx context.Context, from, to time.Time, tokens []string) ([]models.TokenPrice, error) {
+	var prices []models.TokenPrice
+	query := `
+        SELECT
+            token,
+            date,
+            argMax(average_price_usd, fetched_at) AS average_price_usd,
+            argMax(source, fetched_at) AS source,
+            max(fetched_at) AS fetched_at
+        FROM token_prices
+        WHERE date BETWEEN ? AND ?`
+	args := []any{from, to}
+	if len(tokens) > 0 {
+		query += " AND token IN ?"
+		args = append(args, tokens)
+	}
+	query += " GROUP BY token, date ORDER BY date, token"
+
+	if err := r.Conn.Select(ctx, &prices, query, args...); err != nil {
+		return nil, fmt.Errorf("error executing price history query: %w", err)
+	}
+
+	return prices, nil
+}
+
+// FetchLatestPrices retrieves the most recent price of each of tokens, or of every token when tokens is empty.
+func (r *ClickHouseRepository) FetchLatestPrices(ctx context.Context, tokens []string) ([]models.TokenPrice, error) {
+	var prices []models.TokenPrice
+	query := `
+        SELECT
+            token,
+            argMax(date, (date, fetched_at)) AS date,
+            argMax(average_price_usd, (date, fetched_at)) AS average_price_usd,
+            argMax(source, (date, fetched_at)) AS source,
+            argMax(fetched_at, (date, fetched_at)) AS fetched_at
+        FROM token_prices`
+	var args []any
+	if len(tokens) > 0 {
+		query += " WHERE token IN ?"
+		args = append(args, tokens)
+	}
+	query += " GROUP BY token ORDER BY token"
+
+	if err := r.Conn.Select(ctx, &prices, query, args...); err != nil {
+		return nil, fmt.Errorf("error executing latest prices query: %w", err)
+	}
+
+	return prices, nil
+}
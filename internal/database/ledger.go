@@ -0,0 +1,75 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/estensen/marketplace-pipeline/internal/models"
+)
+
+// FindProcessedInput returns the most recent ledger entry of an input with the given content checksum.
+func (r *ClickHouseRepository) FindProcessedInput(ctx context.Context, sha256 string) (models.ProcessedInput, bool, error) {
+	query := `
+        SELECT uri, size, sha256, row_count, run_id, processed_at
+        FROM processed_inputs
+        WHERE sha256 = ?
+        ORDER BY processed_at DESC
+        LIMIT 1
+        `
+	return r.queryProcessedInput(ctx, query, sha256)
+}
+
+// LatestProcessedInput returns the most recent ledger entry of the input at uri.
+func (r *ClickHouseRepository) LatestProcessedInput(ctx context.Context, uri string) (models.ProcessedInput, bool, error) {
+	query := `
+        SELECT uri, size, sha256, row_count, run_id, processed_at
+        FROM processed_inputs
+        WHERE uri = ?
+        ORDER BY processed_at DESC
+        LIMIT 1
+        `
+	return r.queryProcessedInput(ctx, query, uri)
+}
+
+// LastProcessedInput returns the most recent ledger entry of any input, which marks the last successful pipeline run.
+func (r *ClickHouseRepository) LastProcessedInput(ctx context.Context) (models.ProcessedInput, bool, error) {
+	query := `
+        SELECT uri, size, sha256, row_count, run_id, processed_at
+        FROM processed_inputs
+        ORDER BY processed_at DESC
+        LIMIT 1
+        `
+	return r.queryProcessedInput(ctx, query)
+}
+
+// RecordProcessedInputs appends entries to the input ledger.
+func (r *ClickHouseRepository) RecordProcessedInputs(ctx context.Context, inputs []models.ProcessedInput) error {
+	batch, err := r.Conn.PrepareBatch(ctx, "INSERT INTO processed_inputs (uri, size, sha256, row_count, run_id, processed_at)")
+	if err != nil {
+		return fmt.Errorf("error preparing ClickHouse batch: %w", err)
+	}
+
+	for _, input := range inputs {
+		if err := batch.Append(input.URI, input.Size, input.SHA256, input.RowCount, input.RunID, input.ProcessedAt); err != nil {
+			return fmt.Errorf("error appending to ClickHouse batch: %w", err)
+		}
+	}
+
+	if err := r.sendBatch("processed_inputs", batch); err != nil {
+		return fmt.Errorf("error sending batch to ClickHouse: %w", err)
+	}
+
+	return nil
+}
+
+// queryProcessedInput runs a ledger query returning at most one entry.
+func (r *ClickHouseRepository) queryProcessedInput(ctx context.Context, query string, args ...any) (models.ProcessedInput, bool, error) {
+	var inputs []models.ProcessedInput
+	if err := r.Conn.Select(ctx, &inputs, query, args...); err != nil {
+		return models.ProcessedInput{}, false, fmt.Errorf("error executing input ledger query: %w", err)
+	}
+	if len(inputs) == 0 {
+		return models.ProcessedInput{}, false, nil
+	}
+	return inputs[0], true, nil
+}
@@ -2,37 +2,56 @@ package database
 
 import (
 	"context"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
 
-	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/estensen/marketplace-pipeline/internal/models"
+	"github.com/estensen/marketplace-pipeline/internal/telemetry"
 )
 
-// ClickHouseLoader loads aggregated data into ClickHouse.
-type ClickHouseLoader struct {
-	Conn clickhouse.Conn
-}
+// LoadAggregates inserts aggregated data into the database.
+func (r *ClickHouseRepository) LoadAggregates(ctx context.Context, data []models.AggregatedData) error {
+	batch, err := r.Conn.PrepareBatch(ctx, "INSERT INTO marketplace_analytics (date, project_id, transaction_count, total_volume_usd)")
+	if err != nil {
+		return err
+	}
 
-// NewClickHouseLoader creates a new ClickHouseLoader.
-func NewClickHouseLoader(conn clickhouse.Conn) *ClickHouseLoader {
-	return &ClickHouseLoader{
-		Conn: conn,
+	for _, record := range data {
+		err := batch.Append(record.Date, record.ProjectID, record.TransactionCount, record.TotalVolumeUSD)
+		if err != nil {
+			return err
+		}
 	}
+
+	return r.sendBatch("marketplace_analytics", batch)
 }
 
-// Load inserts aggregated data into the database.
-func (l *ClickHouseLoader) Load(data []models.AggregatedData) error {
-	ctx := context.Background()
-	batch, err := l.Conn.PrepareBatch(ctx, "INSERT INTO marketplace_analytics (date, project_id, transaction_count, total_volume_usd)")
+// LoadNativeVolumes inserts per-currency native volumes into the database.
+func (r *ClickHouseRepository) LoadNativeVolumes(ctx context.Context, data []models.NativeVolume) error {
+	batch, err := r.Conn.PrepareBatch(ctx, "INSERT INTO marketplace_volume_native (date, project_id, currency_symbol, token, transaction_count, total_volume_native)")
 	if err != nil {
 		return err
 	}
 
 	for _, record := range data {
-		err := batch.Append(record.Date, record.ProjectID, record.TransactionCount, record.TotalVolumeUSD)
+		err := batch.Append(record.Date, record.ProjectID, record.CurrencySymbol, record.Token, record.TransactionCount, record.TotalVolumeNative)
 		if err != nil {
 			return err
 		}
 	}
 
-	return batch.Send()
+	return r.sendBatch("marketplace_volume_native", batch)
+}
+
+// sendBatch sends a prepared batch into table, recording its size and insert duration.
+func (r *ClickHouseRepository) sendBatch(table string, batch driver.Batch) error {
+	r.sending.RLock()
+	defer r.sending.RUnlock()
+
+	start := time.Now()
+	rows := batch.Rows()
+	err := batch.Send()
+	telemetry.ObserveClickHouseBatch(table, rows, start)
+	return err
 }
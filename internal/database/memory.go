@@ -0,0 +1,402 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/estensen/marketplace-pipeline/internal/models"
+)
+
+// MemoryRepository implements MetricsRepository and PriceRepository in memory.
+// It mirrors the ClickHouse implementation closely enough for hermetic tests.
+type MemoryRepository struct {
+	mu           sync.RWMutex
+	aggregates   []models.AggregatedData
+	nativeVols   []models.NativeVolume
+	transactions []models.Transaction
+	txnKeys      map[string]struct{}
+	prices       []models.TokenPrice
+	audit        []models.RepriceAudit
+	inputs       []models.ProcessedInput
+}
+
+// NewMemoryRepository creates a new, empty MemoryRepository.
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		txnKeys: make(map[string]struct{}),
+	}
+}
+
+// LoadAggregates stores aggregated data.
+func (m *MemoryRepository) LoadAggregates(ctx context.Context, data []models.AggregatedData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.aggregates = append(m.aggregates, data...)
+	return nil
+}
+
+// LoadNativeVolumes stores per-currency native volumes.
+func (m *MemoryRepository) LoadNativeVolumes(ctx context.Context, data []models.NativeVolume) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nativeVols = append(m.nativeVols, data...)
+	return nil
+}
+
+// LoadTransactions stores raw transactions, skipping items that were already loaded.
+func (m *MemoryRepository) LoadTransactions(ctx context.Context, transactions []models.Transaction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, txn := range dedupTransactions(transactions) {
+		if key := transactionKey(txn); key != "" {
+			if _, exists := m.txnKeys[key]; exists {
+				continue
+			}
+			m.txnKeys[key] = struct{}{}
+		}
+		m.transactions = append(m.transactions, txn)
+	}
+	return nil
+}
+
+// FetchTransactions returns the raw transactions matching filter, ordered by timestamp.
+func (m *MemoryRepository) FetchTransactions(ctx context.Context, filter TransactionFilter) ([]models.Transaction, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var transactions []models.Transaction
+	for _, txn := range m.transactions {
+		if filter.Matches(txn) {
+			transactions = append(transactions, txn)
+		}
+	}
+	sort.SliceStable(transactions, func(i, j int) bool {
+		return transactions[i].Timestamp.Before(transactions[j].Timestamp)
+	})
+	return transactions, nil
+}
+
+// Transactions returns the raw transactions loaded so far.
+func (m *MemoryRepository) Transactions() []models.Transaction {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]models.Transaction(nil), m.transactions...)
+}
+
+// FetchMetrics returns the stored analytics rows for the given date.
+func (m *MemoryRepository) FetchMetrics(ctx context.Context, date time.Time) ([]models.AggregatedData, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var metrics []models.AggregatedData
+	for _, data := range m.aggregates {
+		if data.Date.Equal(date) {
+			metrics = append(metrics, data)
+		}
+	}
+	return metrics, nil
+}
+
+// FetchMetricsRange returns aggregated metrics per day and project between from and to, inclusive.
+func (m *MemoryRepository) FetchMetricsRange(ctx context.Context, from, to time.Time) ([]models.AggregatedData, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return sumAggregates(m.aggregates, func(data models.AggregatedData) (time.Time, bool) {
+		return data.Date, !data.Date.Before(from) && !data.Date.After(to)
+	}), nil
+}
+
+// FetchRollup returns the metrics per period and project at granularity g, for the periods starting between from
+// and to, inclusive, of projectIDs or of every project when empty, ordered by period and project.
+func (m *MemoryRepository) FetchRollup(ctx context.Context, g Granularity, from, to time.Time, projectIDs []string) ([]models.AggregatedData, error) {
+	if !g.HasRollup() {
+		return nil, fmt.Errorf("%w: no %s rollup", ErrInvalidGranularity, g)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return sumAggregates(m.aggregates, func(data models.AggregatedData) (time.Time, bool) {
+		if len(projectIDs) > 0 && !slices.Contains(projectIDs, data.ProjectID) {
+			return time.Time{}, false
+		}
+		periodStart := g.PeriodStart(data.Date)
+		return periodStart, !periodStart.Before(from) && !periodStart.After(to)
+	}), nil
+}
+
+// StreamRollup calls fn with the metrics per period and project at granularity g, for the periods starting between
+// from and to, inclusive, of projectIDs or of every project when empty, ordered by period and project.
+func (m *MemoryRepository) StreamRollup(ctx context.Context, g Granularity, from, to time.Time, projectIDs []string, fn func(models.AggregatedData) error) error {
+	metrics, err := m.FetchRollup(ctx, g, from, to, projectIDs)
+	if err != nil {
+		return err
+	}
+	for _, data := range metrics {
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FetchNativeVolumes returns native currency volumes between from and to, inclusive.
+func (m *MemoryRepository) FetchNativeVolumes(ctx context.Context, from, to time.Time) ([]models.NativeVolume, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	dataMap := make(map[string]*models.NativeVolume)
+	var keys []string
+	for _, v := range m.nativeVols {
+		if v.Date.Before(from) || v.Date.After(to) {
+			continue
+		}
+		key := v.Date.Format("2006-01-02") + v.ProjectID + v.CurrencySymbol + v.Token
+		if data, exists := dataMap[key]; exists {
+			data.TransactionCount += v.TransactionCount
+			data.TotalVolumeNative += v.TotalVolumeNative
+			continue
+		}
+		data := v
+		dataMap[key] = &data
+		keys = append(keys, key)
+	}
+
+	volumes := make([]models.NativeVolume, 0, len(keys))
+	for _, key := range keys {
+		volumes = append(volumes, *dataMap[key])
+	}
+	return volumes, nil
+}
+
+// ReplaceAggregates removes the analytics rows for each day and project in keys and stores data in their place.
+func (m *MemoryRepository) ReplaceAggregates(ctx context.Context, keys []models.AggregatedData, data []models.AggregatedData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	replaced := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		replaced[key.Date.Format("2006-01-02")+key.ProjectID] = struct{}{}
+	}
+
+	kept := m.aggregates[:0]
+	for _, row := range m.aggregates {
+		if _, exists := replaced[row.Date.Format("2006-01-02")+row.ProjectID]; !exists {
+			kept = append(kept, row)
+		}
+	}
+	m.aggregates = append(kept, data...)
+	return nil
+}
+
+// ReplaceNativeVolumes removes the native volumes for each day and project in keys and stores data in their place.
+func (m *MemoryRepository) ReplaceNativeVolumes(ctx context.Context, keys []models.AggregatedData, data []models.NativeVolume) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	replaced := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		replaced[key.Date.Format("2006-01-02")+key.ProjectID] = struct{}{}
+	}
+
+	kept := m.nativeVols[:0]
+	for _, row := range m.nativeVols {
+		if _, exists := replaced[row.Date.Format("2006-01-02")+row.ProjectID]; !exists {
+			kept = append(kept, row)
+		}
+	}
+	m.nativeVols = append(kept, data...)
+	return nil
+}
+
+// StoreRepriceAudit stores the before and after values of repriced analytics rows.
+func (m *MemoryRepository) StoreRepriceAudit(ctx context.Context, entries []models.RepriceAudit) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.audit = append(m.audit, entries...)
+	return nil
+}
+
+// RepriceAudit returns the reprice audit entries stored so far.
+func (m *MemoryRepository) RepriceAudit() []models.RepriceAudit {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]models.RepriceAudit(nil), m.audit...)
+}
+
+// HasPrices reports whether any token prices are stored for the given date.
+func (m *MemoryRepository) HasPrices(ctx context.Context, date time.Time) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, p := range m.prices {
+		if p.Date.Equal(date) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// StorePrices stores the token prices for the given date, as fetched from source.
+func (m *MemoryRepository) StorePrices(ctx context.Context, date time.Time, source string, prices map[string]float64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fetchedAt := time.Now()
+	for coinID, priceUSD := range prices {
+		m.prices = append(m.prices, models.TokenPrice{
+			Token:           coinID,
+			Date:            date,
+			AveragePriceUSD: priceUSD,
+			Source:          source,
+			FetchedAt:       fetchedAt,
+		})
+	}
+	return nil
+}
+
+// FetchPrices returns the latest token prices for the given coin IDs and date.
+func (m *MemoryRepository) FetchPrices(ctx context.Context, coinIDs []string, date time.Time) (map[string]float64, error) {
+	prices := make(map[string]float64)
+	if len(coinIDs) == 0 {
+		return prices, nil
+	}
+
+	history, err := m.FetchPriceHistory(ctx, date, date, coinIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range history {
+		prices[p.Token] = p.AveragePriceUSD
+	}
+	return prices, nil
+}
+
+// FetchPriceHistory returns the latest price of each of tokens, or of every token when tokens is empty,
+// for each day between from and to, inclusive, ordered by date and token.
+func (m *MemoryRepository) FetchPriceHistory(ctx context.Context, from, to time.Time, tokens []string) ([]models.TokenPrice, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	latest := make(map[string]models.TokenPrice)
+	for _, p := range m.prices {
+		if p.Date.Before(from) || p.Date.After(to) {
+			continue
+		}
+		if len(tokens) > 0 && !slices.Contains(tokens, p.Token) {
+			continue
+		}
+		key := p.Date.Format("2006-01-02") + p.Token
+		if current, exists := latest[key]; !exists || !p.FetchedAt.Before(current.FetchedAt) {
+			latest[key] = p
+		}
+	}
+
+	prices := make([]models.TokenPrice, 0, len(latest))
+	for _, p := range latest {
+		prices = append(prices, p)
+	}
+	sort.Slice(prices, func(i, j int) bool {
+		if !prices[i].Date.Equal(prices[j].Date) {
+			return prices[i].Date.Before(prices[j].Date)
+		}
+		return prices[i].Token < prices[j].Token
+	})
+	return prices, nil
+}
+
+// FetchLatestPrices returns the most recent price of each of tokens, or of every token when tokens is empty.
+func (m *MemoryRepository) FetchLatestPrices(ctx context.Context, tokens []string) ([]models.TokenPrice, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	latest := make(map[string]models.TokenPrice)
+	for _, p := range m.prices {
+		if len(tokens) > 0 && !slices.Contains(tokens, p.Token) {
+			continue
+		}
+		current, exists := latest[p.Token]
+		if !exists || p.Date.After(current.Date) || (p.Date.Equal(current.Date) && !p.FetchedAt.Before(current.FetchedAt)) {
+			latest[p.Token] = p
+		}
+	}
+
+	prices := make([]models.TokenPrice, 0, len(latest))
+	for _, p := range latest {
+		prices = append(prices, p)
+	}
+	sort.Slice(prices, func(i, j int) bool { return prices[i].Token < prices[j].Token })
+	return prices, nil
+}
+
+// FindProcessedInput returns the most recent ledger entry of an input with the given content checksum.
+func (m *MemoryRepository) FindProcessedInput(ctx context.Context, sha256 string) (models.ProcessedInput, bool, error) {
+	return m.latestInput(func(input models.ProcessedInput) bool { return input.SHA256 == sha256 })
+}
+
+// LatestProcessedInput returns the most recent ledger entry of the input at uri.
+func (m *MemoryRepository) LatestProcessedInput(ctx context.Context, uri string) (models.ProcessedInput, bool, error) {
+	return m.latestInput(func(input models.ProcessedInput) bool { return input.URI == uri })
+}
+
+// Ping always succeeds, since the repository lives in memory.
+func (m *MemoryRepository) Ping(ctx context.Context) error {
+	return nil
+}
+
+// LastProcessedInput returns the most recent ledger entry of any input.
+func (m *MemoryRepository) LastProcessedInput(ctx context.Context) (models.ProcessedInput, bool, error) {
+	return m.latestInput(func(models.ProcessedInput) bool { return true })
+}
+
+// RecordProcessedInputs appends entries to the input ledger.
+func (m *MemoryRepository) RecordProcessedInputs(ctx context.Context, inputs []models.ProcessedInput) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inputs = append(m.inputs, inputs...)
+	return nil
+}
+
+// latestInput returns the most recently processed ledger entry accepted by match.
+func (m *MemoryRepository) latestInput(match func(models.ProcessedInput) bool) (models.ProcessedInput, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var latest models.ProcessedInput
+	found := false
+	for _, input := range m.inputs {
+		if match(input) && (!found || !input.ProcessedAt.Before(latest.ProcessedAt)) {
+			latest = input
+			found = true
+		}
+	}
+	return latest, found, nil
+}
+
+// sumAggregates sums the rows accepted by bucket per returned date and project, ordered by date and project.
+func sumAggregates(rows []models.AggregatedData, bucket func(models.AggregatedData) (time.Time, bool)) []models.AggregatedData {
+	dataMap := make(map[string]*models.AggregatedData)
+	for _, row := range rows {
+		date, ok := bucket(row)
+		if !ok {
+			continue
+		}
+		key := date.Format("2006-01-02") + row.ProjectID
+		if data, exists := dataMap[key]; exists {
+			data.TransactionCount += row.TransactionCount
+			data.TotalVolumeUSD += row.TotalVolumeUSD
+			continue
+		}
+		dataMap[key] = &models.AggregatedData{
+			Date:             date,
+			ProjectID:        row.ProjectID,
+			TransactionCount: row.TransactionCount,
+			TotalVolumeUSD:   row.TotalVolumeUSD,
+		}
+	}
+
+	metrics := make([]models.AggregatedData, 0, len(dataMap))
+	for _, data := range dataMap {
+		metrics = append(metrics, *data)
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		if !metrics[i].Date.Equal(metrics[j].Date) {
+			return metrics[i].Date.Before(metrics[j].Date)
+		}
+		return metrics[i].ProjectID < metrics[j].ProjectID
+	})
+	return metrics
+}
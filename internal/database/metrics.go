@@ -5,12 +5,11 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/estensen/marketplace-pipeline/internal/models"
 )
 
 // FetchMetrics retrieves aggregated metrics from ClickHouse for the given date.
-func FetchMetrics(ctx context.Context, conn clickhouse.Conn, date time.Time) ([]models.AggregatedData, error) {
+func (r *ClickHouseRepository) FetchMetrics(ctx context.Context, date time.Time) ([]models.AggregatedData, error) {
 	var metrics []models.AggregatedData
 	query := `
         SELECT 
@@ -22,9 +21,30 @@ func FetchMetrics(ctx context.Context, conn clickhouse.Conn, date time.Time) ([]
         WHERE date = ?
         `
 
-	if err := conn.Select(ctx, &metrics, query, date); err != nil {
+	if err := r.Conn.Select(ctx, &metrics, query, date); err != nil {
 		return nil, fmt.Errorf("error executing query '%s': %v", query, err)
 	}
 
 	return metrics, nil
 }
+
+// FetchMetricsRange retrieves aggregated metrics per day and project between from and to, inclusive.
+func (r *ClickHouseRepository) FetchMetricsRange(ctx context.Context, from, to time.Time) ([]models.AggregatedData, error) {
+	var metrics []models.AggregatedData
+	query := `
+        SELECT
+            date,
+            project_id,
+            SUM(transaction_count) AS transaction_count,
+            SUM(total_volume_usd) AS total_volume_usd
+        FROM marketplace_analytics
+        WHERE date BETWEEN ? AND ?
+        GROUP BY date, project_id
+        `
+
+	if err := r.Conn.Select(ctx, &metrics, query, from, to); err != nil {
+		return nil, fmt.Errorf("error executing metrics range query: %w", err)
+	}
+
+	return metrics, nil
+}
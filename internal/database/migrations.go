@@ -0,0 +1,418 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+// ErrSchemaOutdated is returned when the ClickHouse schema is behind the migrations of this build.
+var ErrSchemaOutdated = errors.New("schema outdated")
+
+// Rollup rebuilds switch from backfilled rows to rows rolled up by the views at a cutoff this far ahead, leaving
+// time to create the views. Backfills wait rollupCommitMargin past the cutoff, so inserts stamped before it are
+// committed when they run.
+const (
+	rollupCutoverDelay = 5 * time.Second
+	rollupCommitMargin = time.Second
+)
+
+// Migration is a versioned set of schema statements applied in order. Apply, when set, runs after the statements
+// for steps that depend on the state of the database.
+type Migration struct {
+	Version     uint32
+	Description string
+	Statements  []string
+	Apply       func(ctx context.Context, conn clickhouse.Conn) error
+}
+
+// Migrations lists every schema change of the pipeline, oldest first.
+var Migrations = []Migration{
+	{
+		Version:     1,
+		Description: "create marketplace_analytics and token_prices",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS marketplace_analytics (
+                date Date,
+                project_id String,
+                transaction_count UInt64,
+                total_volume_usd Float64
+            ) ENGINE = MergeTree()
+            ORDER BY (date, project_id)`,
+			`CREATE TABLE IF NOT EXISTS token_prices (
+                token String,
+                date Date,
+                average_price_usd Float64,
+                fetched_at DateTime DEFAULT now()
+            ) ENGINE = MergeTree()
+            ORDER BY (token, date)`,
+		},
+	},
+	{
+		Version:     2,
+		Description: "create marketplace_volume_native and marketplace_volume_usd",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS marketplace_volume_native (
+                date Date,
+                project_id String,
+                currency_symbol String,
+                token String,
+                transaction_count UInt64,
+                total_volume_native Float64
+            ) ENGINE = MergeTree()
+            ORDER BY (date, project_id, currency_symbol)`,
+			`CREATE VIEW IF NOT EXISTS marketplace_volume_usd AS
+            SELECT
+                v.date AS date,
+                v.project_id AS project_id,
+                SUM(v.transaction_count) AS transaction_count,
+                SUM(v.total_volume_native * p.average_price_usd) AS total_volume_usd
+            FROM marketplace_volume_native AS v
+            INNER JOIN (
+                SELECT token, date, argMax(average_price_usd, fetched_at) AS average_price_usd
+                FROM token_prices
+                GROUP BY token, date
+            ) AS p ON v.token = p.token AND v.date = p.date
+            GROUP BY date, project_id`,
+		},
+	},
+	{
+		Version:     3,
+		Description: "create reprice_audit",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS reprice_audit (
+                repriced_at DateTime,
+                token String,
+                date Date,
+                project_id String,
+                old_transaction_count UInt64,
+                new_transaction_count UInt64,
+                old_volume_usd Float64,
+                new_volume_usd Float64
+            ) ENGINE = MergeTree()
+            ORDER BY (token, date, project_id, repriced_at)`,
+		},
+	},
+	{
+		Version:     4,
+		Description: "create marketplace_transactions",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS marketplace_transactions (
+                ts DateTime64(3),
+                event String,
+                project_id String,
+                currency_symbol String,
+                chain_id String,
+                collection_address String,
+                currency_address String,
+                token_id String,
+                txn_hash String,
+                marketplace_type String,
+                request_id String,
+                currency_value_decimal String,
+                currency_value_raw String,
+                inserted_at DateTime DEFAULT now()
+            ) ENGINE = ReplacingMergeTree(inserted_at)
+            PARTITION BY toYYYYMM(ts)
+            ORDER BY (project_id, ts, txn_hash)`,
+		},
+	},
+	{
+		Version:     5,
+		Description: "create daily, weekly and monthly analytics rollups",
+		Statements:  rollupStatements(),
+	},
+	{
+		Version:     6,
+		Description: "create processed_inputs",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS processed_inputs (
+                uri String,
+                size UInt64,
+                sha256 String,
+                row_count UInt64,
+                run_id String,
+                processed_at DateTime
+            ) ENGINE = MergeTree()
+            ORDER BY (sha256, uri, processed_at)`,
+		},
+	},
+	{
+		Version:     7,
+		Description: "create api_keys",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS api_keys (
+                name String,
+                key_hash String,
+                project_ids Array(String),
+                rate_per_second Float64,
+                burst UInt32,
+                revoked UInt8,
+                updated_at DateTime
+            ) ENGINE = ReplacingMergeTree(updated_at)
+            ORDER BY key_hash`,
+		},
+	},
+	{
+		Version:     8,
+		Description: "record the source of token prices",
+		Statements: []string{
+			`ALTER TABLE token_prices ADD COLUMN IF NOT EXISTS source LowCardinality(String) DEFAULT 'unknown' AFTER average_price_usd`,
+		},
+	},
+	{
+		Version:     9,
+		Description: "record the trader of raw transactions",
+		Statements: []string{
+			`ALTER TABLE marketplace_transactions ADD COLUMN IF NOT EXISTS user_id String DEFAULT '' AFTER project_id`,
+		},
+	},
+	{
+		Version:     10,
+		Description: "deduplicate raw transactions per item",
+		// The sorting key of a table can't be extended with an existing column, so the table is rebuilt
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS marketplace_transactions_rebuild (
+                ts DateTime64(3),
+                event String,
+                project_id String,
+                user_id String DEFAULT '',
+                currency_symbol String,
+                chain_id String,
+                collection_address String,
+                currency_address String,
+                token_id String,
+                txn_hash String,
+                marketplace_type String,
+                request_id String,
+                currency_value_decimal String,
+                currency_value_raw String,
+                inserted_at DateTime DEFAULT now()
+            ) ENGINE = ReplacingMergeTree(inserted_at)
+            PARTITION BY toYYYYMM(ts)
+            ORDER BY (project_id, ts, txn_hash, token_id)`,
+			`INSERT INTO marketplace_transactions_rebuild
+            SELECT ts, event, project_id, user_id, currency_symbol, chain_id, collection_address, currency_address,
+                token_id, txn_hash, marketplace_type, request_id, currency_value_decimal, currency_value_raw, inserted_at
+            FROM marketplace_transactions`,
+			`EXCHANGE TABLES marketplace_transactions AND marketplace_transactions_rebuild`,
+			`DROP TABLE marketplace_transactions_rebuild`,
+		},
+	},
+	{
+		Version:     11,
+		Description: "rebuild analytics rollups from a cutoff without pausing loads",
+		Statements:  rebuildRollupStatements(),
+		Apply:       rebuildRollups,
+	},
+	{
+		Version:     12,
+		Description: "stamp native volumes with their insertion time",
+		// Replacements insert the new rows first and delete the older ones by their insertion time
+		Statements: []string{
+			`ALTER TABLE marketplace_volume_native ADD COLUMN IF NOT EXISTS inserted_at DateTime64(3) DEFAULT now64(3)`,
+			`ALTER TABLE marketplace_volume_native MATERIALIZE COLUMN inserted_at SETTINGS mutations_sync = 2`,
+		},
+	},
+}
+
+// rollupStatements builds a rollup table and a materialized view feeding it from marketplace_analytics for each granularity.
+func rollupStatements() []string {
+	var statements []string
+	for _, g := range []Granularity{GranularityDay, GranularityWeek, GranularityMonth} {
+		statements = append(statements,
+			rollupTableStatement(g),
+			rollupViewStatement(g, ""),
+			// Backfill rows loaded before the view existed
+			rollupBackfillStatement(g, ""),
+		)
+	}
+	return statements
+}
+
+// rebuildRollupStatements stamps analytics rows with their insertion time and empties every rollup, dropping its
+// view. rebuildRollups then refills them.
+func rebuildRollupStatements() []string {
+	statements := []string{
+		`ALTER TABLE marketplace_analytics ADD COLUMN IF NOT EXISTS inserted_at DateTime64(3) DEFAULT now64(3)`,
+		// Rows inserted before the column existed would read the default, the time of the query, so it is stored
+		`ALTER TABLE marketplace_analytics MATERIALIZE COLUMN inserted_at SETTINGS mutations_sync = 2`,
+	}
+	for _, g := range []Granularity{GranularityDay, GranularityWeek, GranularityMonth} {
+		statements = append(statements,
+			fmt.Sprintf("DROP VIEW IF EXISTS %s_mv", g.RollupTable()),
+			fmt.Sprintf("TRUNCATE TABLE IF EXISTS %s", g.RollupTable()),
+			rollupTableStatement(g),
+		)
+	}
+	return statements
+}
+
+// rebuildRollups refills the rollups emptied by rebuildRollupStatements while loads go on. The views are created
+// first, rolling up rows inserted from a cutoff ahead of time, and once the cutoff has passed the rows inserted
+// before it are backfilled, so every row is rolled up exactly once.
+func rebuildRollups(ctx context.Context, conn clickhouse.Conn) error {
+	// Both the cutoff and the insertion times come from the ClickHouse clock
+	var cutoff time.Time
+	query := fmt.Sprintf("SELECT now64(3) + toIntervalMillisecond(%d)", rollupCutoverDelay.Milliseconds())
+	if err := conn.QueryRow(ctx, query).Scan(&cutoff); err != nil {
+		return fmt.Errorf("error querying rollup cutoff: %w", err)
+	}
+
+	for _, g := range []Granularity{GranularityDay, GranularityWeek, GranularityMonth} {
+		if err := conn.Exec(ctx, rollupViewStatement(g, insertedSince(cutoff))); err != nil {
+			return fmt.Errorf("error creating %s rollup view: %w", g, err)
+		}
+	}
+
+	// Rows inserted after the cutoff but before the views existed would be lost, so the migration is retried
+	var now time.Time
+	if err := conn.QueryRow(ctx, "SELECT now64(3)").Scan(&now); err != nil {
+		return fmt.Errorf("error querying ClickHouse time: %w", err)
+	}
+	if !now.Before(cutoff) {
+		return fmt.Errorf("error creating rollup views: cutoff %s passed before they were created", cutoff.UTC().Format(time.RFC3339Nano))
+	}
+
+	select {
+	case <-time.After(cutoff.Sub(now) + rollupCommitMargin):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	// The backfills take the complement of the rows the views roll up
+	for _, g := range []Granularity{GranularityDay, GranularityWeek, GranularityMonth} {
+		if err := conn.Exec(ctx, rollupBackfillStatement(g, "NOT "+insertedSince(cutoff))); err != nil {
+			return fmt.Errorf("error backfilling %s rollup: %w", g, err)
+		}
+	}
+	return nil
+}
+
+// insertedSince returns the condition matching analytics rows inserted at or after cutoff.
+func insertedSince(cutoff time.Time) string {
+	return fmt.Sprintf("inserted_at >= toDateTime64('%s', 3, 'UTC')", cutoff.UTC().Format("2006-01-02 15:04:05.000"))
+}
+
+// rollupWhere returns the WHERE clause of a rollup query restricted to the rows matching condition, if any.
+func rollupWhere(condition string) string {
+	if condition == "" {
+		return ""
+	}
+	return "\n            WHERE " + condition
+}
+
+// rollupTableStatement creates the rollup table of g.
+func rollupTableStatement(g Granularity) string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+                period_start Date,
+                project_id String,
+                transaction_count UInt64,
+                total_volume_usd Float64
+            ) ENGINE = SummingMergeTree()
+            ORDER BY (period_start, project_id)`, g.RollupTable())
+}
+
+// rollupViewStatement creates the materialized view rolling up rows inserted into marketplace_analytics at g,
+// restricted to the rows matching condition unless it is empty. Rollups read the priced analytics rather than
+// raw transactions, since USD volumes depend on prices that are fetched per day and may be repriced after the
+// transactions are loaded.
+func rollupViewStatement(g Granularity, condition string) string {
+	return fmt.Sprintf(`CREATE MATERIALIZED VIEW IF NOT EXISTS %s_mv TO %s AS
+            SELECT
+                %s AS period_start,
+                project_id,
+                SUM(transaction_count) AS transaction_count,
+                SUM(total_volume_usd) AS total_volume_usd
+            FROM marketplace_analytics%s
+            GROUP BY period_start, project_id`, g.RollupTable(), g.RollupTable(), g.periodStartExpr("date"), rollupWhere(condition))
+}
+
+// rollupBackfillStatement rolls up the rows already in marketplace_analytics at g, restricted to the rows
+// matching condition unless it is empty.
+func rollupBackfillStatement(g Granularity, condition string) string {
+	return fmt.Sprintf(`INSERT INTO %s
+            SELECT
+                %s AS period_start,
+                project_id,
+                SUM(transaction_count) AS transaction_count,
+                SUM(total_volume_usd) AS total_volume_usd
+            FROM marketplace_analytics%s
+            GROUP BY period_start, project_id`, g.RollupTable(), g.periodStartExpr("date"), rollupWhere(condition))
+}
+
+// LatestSchemaVersion returns the version of the newest migration.
+func LatestSchemaVersion() uint32 {
+	return Migrations[len(Migrations)-1].Version
+}
+
+// Migrate applies every migration newer than the current schema version.
+func Migrate(ctx context.Context, conn clickhouse.Conn) error {
+	query := `
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+            version UInt32,
+            description String,
+            applied_at DateTime DEFAULT now()
+        ) ENGINE = MergeTree()
+        ORDER BY version
+        `
+	if err := conn.Exec(ctx, query); err != nil {
+		return fmt.Errorf("error creating schema_migrations table: %w", err)
+	}
+
+	current, err := SchemaVersion(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range Migrations {
+		if m.Version <= current {
+			continue
+		}
+
+		for _, statement := range m.Statements {
+			if err := conn.Exec(ctx, statement); err != nil {
+				return fmt.Errorf("error applying migration %d (%s): %w", m.Version, m.Description, err)
+			}
+		}
+		if m.Apply != nil {
+			if err := m.Apply(ctx, conn); err != nil {
+				return fmt.Errorf("error applying migration %d (%s): %w", m.Version, m.Description, err)
+			}
+		}
+
+		query := "INSERT INTO schema_migrations (version, description) VALUES (?, ?)"
+		if err := conn.Exec(ctx, query, m.Version, m.Description); err != nil {
+			return fmt.Errorf("error recording migration %d: %w", m.Version, err)
+		}
+		log.Printf("Applied migration %d: %s", m.Version, m.Description)
+	}
+
+	return nil
+}
+
+// CheckSchemaVersion returns an ErrSchemaOutdated error when migrations newer than the applied schema exist.
+func CheckSchemaVersion(ctx context.Context, conn clickhouse.Conn) error {
+	version, err := SchemaVersion(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if latest := LatestSchemaVersion(); version < latest {
+		return fmt.Errorf("%w: version %d, latest %d", ErrSchemaOutdated, version, latest)
+	}
+	return nil
+}
+
+// SchemaVersion returns the version of the newest migration applied to ClickHouse.
+func SchemaVersion(ctx context.Context, conn clickhouse.Conn) (uint32, error) {
+	var version uint32
+	query := "SELECT max(version) FROM schema_migrations"
+	if err := conn.QueryRow(ctx, query).Scan(&version); err != nil {
+		return 0, fmt.Errorf("error querying schema version: %w", err)
+	}
+	return version, nil
+}
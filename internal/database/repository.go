@@ -0,0 +1,112 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/estensen/marketplace-pipeline/internal/models"
+)
+
+// ErrPartialReplace is returned when replacing rows failed after the new rows were stored. The replaced rows are
+// counted twice until the replacement is retried with the same rows.
+var ErrPartialReplace = errors.New("replacement partially applied")
+
+// MetricsRepository reads and writes marketplace analytics.
+type MetricsRepository interface {
+	LoadAggregates(ctx context.Context, data []models.AggregatedData) error
+	LoadNativeVolumes(ctx context.Context, data []models.NativeVolume) error
+	LoadTransactions(ctx context.Context, transactions []models.Transaction) error
+	FetchTransactions(ctx context.Context, filter TransactionFilter) ([]models.Transaction, error)
+	FetchMetrics(ctx context.Context, date time.Time) ([]models.AggregatedData, error)
+	FetchMetricsRange(ctx context.Context, from, to time.Time) ([]models.AggregatedData, error)
+	FetchRollup(ctx context.Context, g Granularity, from, to time.Time, projectIDs []string) ([]models.AggregatedData, error)
+	StreamRollup(ctx context.Context, g Granularity, from, to time.Time, projectIDs []string, fn func(models.AggregatedData) error) error
+	FetchNativeVolumes(ctx context.Context, from, to time.Time) ([]models.NativeVolume, error)
+	ReplaceAggregates(ctx context.Context, keys []models.AggregatedData, data []models.AggregatedData) error
+	ReplaceNativeVolumes(ctx context.Context, keys []models.AggregatedData, data []models.NativeVolume) error
+	StoreRepriceAudit(ctx context.Context, entries []models.RepriceAudit) error
+}
+
+// PriceRepository reads and writes token prices.
+type PriceRepository interface {
+	HasPrices(ctx context.Context, date time.Time) (bool, error)
+	StorePrices(ctx context.Context, date time.Time, source string, prices map[string]float64) error
+	FetchPrices(ctx context.Context, coinIDs []string, date time.Time) (map[string]float64, error)
+	FetchPriceHistory(ctx context.Context, from, to time.Time, tokens []string) ([]models.TokenPrice, error)
+	FetchLatestPrices(ctx context.Context, tokens []string) ([]models.TokenPrice, error)
+}
+
+// InputLedger records the input files processed by the pipeline.
+type InputLedger interface {
+	FindProcessedInput(ctx context.Context, sha256 string) (models.ProcessedInput, bool, error)
+	LatestProcessedInput(ctx context.Context, uri string) (models.ProcessedInput, bool, error)
+	LastProcessedInput(ctx context.Context) (models.ProcessedInput, bool, error)
+	RecordProcessedInputs(ctx context.Context, inputs []models.ProcessedInput) error
+}
+
+// Pinger checks that a storage backend is reachable.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Repository combines the metrics and price repositories and the input ledger of a single storage backend.
+type Repository interface {
+	MetricsRepository
+	PriceRepository
+	InputLedger
+	Pinger
+}
+
+// ClickHouseRepository implements MetricsRepository and PriceRepository on top of ClickHouse.
+type ClickHouseRepository struct {
+	Conn                 clickhouse.Conn
+	TransactionBatchSize int
+
+	// sending is held for reading while a batch is sent, so Close waits for in-flight inserts.
+	sending sync.RWMutex
+}
+
+// NewClickHouseRepository creates a new ClickHouseRepository.
+func NewClickHouseRepository(conn clickhouse.Conn) *ClickHouseRepository {
+	return &ClickHouseRepository{
+		Conn:                 conn,
+		TransactionBatchSize: DefaultTransactionBatchSize,
+	}
+}
+
+// Close waits for the batches being sent to complete and closes the ClickHouse connection.
+func (r *ClickHouseRepository) Close() error {
+	r.sending.Lock()
+	defer r.sending.Unlock()
+	if err := r.Conn.Close(); err != nil {
+		return fmt.Errorf("error closing ClickHouse connection: %w", err)
+	}
+	return nil
+}
+
+// Ping checks that ClickHouse is reachable.
+func (r *ClickHouseRepository) Ping(ctx context.Context) error {
+	if err := r.Conn.Ping(ctx); err != nil {
+		return fmt.Errorf("error pinging ClickHouse: %w", err)
+	}
+	return nil
+}
+
+var (
+	_ MetricsRepository = (*ClickHouseRepository)(nil)
+	_ PriceRepository   = (*ClickHouseRepository)(nil)
+	_ InputLedger       = (*ClickHouseRepository)(nil)
+	_ MetricsRepository = (*MemoryRepository)(nil)
+	_ PriceRepository   = (*MemoryRepository)(nil)
+	_ InputLedger       = (*MemoryRepository)(nil)
+	_ MetricsRepository = (*SQLiteRepository)(nil)
+	_ PriceRepository   = (*SQLiteRepository)(nil)
+	_ InputLedger       = (*SQLiteRepository)(nil)
+	_ Repository        = (*ClickHouseRepository)(nil)
+	_ Repository        = (*MemoryRepository)(nil)
+	_ Repository        = (*SQLiteRepository)(nil)
+)
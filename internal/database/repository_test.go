@@ -0,0 +1,389 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/estensen/marketplace-pipeline/internal/models"
+)
+
+// TestRepositories runs the same checks against every repository that works without a server.
+func TestRepositories(t *testing.T) {
+	implementations := []struct {
+		name string
+		open func(t *testing.T) Repository
+	}{
+		{
+			name: "memory",
+			open: func(t *testing.T) Repository {
+				return NewMemoryRepository()
+			},
+		},
+		{
+			name: "sqlite",
+			open: func(t *testing.T) Repository {
+				repo, err := NewSQLiteRepository(context.Background(), filepath.Join(t.TempDir(), "pipeline.db"))
+				require.NoError(t, err)
+				t.Cleanup(func() { repo.Close() })
+				return repo
+			},
+		},
+	}
+
+	for _, impl := range implementations {
+		t.Run(impl.name, func(t *testing.T) {
+			t.Run("Metrics", func(t *testing.T) {
+				testMetricsRepository(t, impl.open(t))
+			})
+			t.Run("Prices", func(t *testing.T) {
+				testPriceRepository(t, impl.open(t))
+			})
+			t.Run("InputLedger", func(t *testing.T) {
+				testInputLedger(t, impl.open(t))
+			})
+			t.Run("Ping", func(t *testing.T) {
+				assert.NoError(t, impl.open(t).Ping(context.Background()))
+			})
+			t.Run("Transactions", func(t *testing.T) {
+				testFetchTransactions(t, impl.open(t))
+			})
+		})
+	}
+}
+
+func testMetricsRepository(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	apr1 := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	apr2 := time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)
+	apr15 := time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC)
+
+	require.NoError(t, repo.LoadAggregates(ctx, []models.AggregatedData{
+		{Date: apr1, ProjectID: "4974", TransactionCount: 5, TotalVolumeUSD: 10},
+		{Date: apr2, ProjectID: "4974", TransactionCount: 3, TotalVolumeUSD: 6},
+		{Date: apr2, ProjectID: "1609", TransactionCount: 1, TotalVolumeUSD: 2},
+		{Date: apr15, ProjectID: "4974", TransactionCount: 2, TotalVolumeUSD: 4},
+	}))
+
+	metrics, err := repo.FetchMetrics(ctx, apr2)
+	require.NoError(t, err)
+	assert.Len(t, metrics, 2)
+
+	weeks, err := repo.FetchRollup(ctx, GranularityWeek, apr1, apr15, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []models.AggregatedData{
+		{Date: apr1, ProjectID: "1609", TransactionCount: 1, TotalVolumeUSD: 2},
+		{Date: apr1, ProjectID: "4974", TransactionCount: 8, TotalVolumeUSD: 16},
+		{Date: apr15, ProjectID: "4974", TransactionCount: 2, TotalVolumeUSD: 4},
+	}, weeks)
+
+	// Periods starting after to are left out
+	week, err := repo.FetchRollup(ctx, GranularityWeek, apr1, apr1, nil)
+	require.NoError(t, err)
+	assert.Equal(t, weeks[:2], week)
+
+	month, err := repo.FetchRollup(ctx, GranularityMonth, apr1, apr1, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []models.AggregatedData{
+		{Date: apr1, ProjectID: "1609", TransactionCount: 1, TotalVolumeUSD: 2},
+		{Date: apr1, ProjectID: "4974", TransactionCount: 10, TotalVolumeUSD: 20},
+	}, month)
+
+	// Rollups are restricted to the requested projects
+	projects, err := repo.FetchRollup(ctx, GranularityMonth, apr1, apr1, []string{"1609", "unknown"})
+	require.NoError(t, err)
+	assert.Equal(t, month[:1], projects)
+
+	var streamed []models.AggregatedData
+	require.NoError(t, repo.StreamRollup(ctx, GranularityWeek, apr1, apr15, nil, func(data models.AggregatedData) error {
+		streamed = append(streamed, data)
+		return nil
+	}))
+	assert.Equal(t, weeks, streamed)
+	stop := errors.New("stop")
+	assert.ErrorIs(t, repo.StreamRollup(ctx, GranularityWeek, apr1, apr15, nil, func(models.AggregatedData) error { return stop }), stop)
+	assert.ErrorIs(t, repo.StreamRollup(ctx, GranularityHour, apr1, apr1, nil, func(models.AggregatedData) error { return nil }), ErrInvalidGranularity)
+
+	// Replacing a row leaves the other rows untouched
+	require.NoError(t, repo.ReplaceAggregates(ctx,
+		[]models.AggregatedData{{Date: apr2, ProjectID: "4974"}},
+		[]models.AggregatedData{{Date: apr2, ProjectID: "4974", TransactionCount: 3, TotalVolumeUSD: 7}},
+	))
+	rangeMetrics, err := repo.FetchMetricsRange(ctx, apr1, apr2)
+	require.NoError(t, err)
+	assert.Equal(t, []models.AggregatedData{
+		{Date: apr1, ProjectID: "4974", TransactionCount: 5, TotalVolumeUSD: 10},
+		{Date: apr2, ProjectID: "1609", TransactionCount: 1, TotalVolumeUSD: 2},
+		{Date: apr2, ProjectID: "4974", TransactionCount: 3, TotalVolumeUSD: 7},
+	}, rangeMetrics)
+
+	require.NoError(t, repo.LoadNativeVolumes(ctx, []models.NativeVolume{
+		{Date: apr2, ProjectID: "4974", CurrencySymbol: "MATIC", Token: "matic-network", TransactionCount: 2, TotalVolumeNative: 1.5},
+		{Date: apr2, ProjectID: "4974", CurrencySymbol: "MATIC", Token: "matic-network", TransactionCount: 1, TotalVolumeNative: 0.5},
+		{Date: apr15, ProjectID: "4974", CurrencySymbol: "SFL", Token: "sunflower-land", TransactionCount: 1, TotalVolumeNative: 3},
+	}))
+	volumes, err := repo.FetchNativeVolumes(ctx, apr1, apr2)
+	require.NoError(t, err)
+	assert.Equal(t, []models.NativeVolume{
+		{Date: apr2, ProjectID: "4974", CurrencySymbol: "MATIC", Token: "matic-network", TransactionCount: 3, TotalVolumeNative: 2},
+	}, volumes)
+
+	// Replacing a day and project leaves the other days untouched
+	require.NoError(t, repo.ReplaceNativeVolumes(ctx,
+		[]models.AggregatedData{{Date: apr2, ProjectID: "4974"}},
+		[]models.NativeVolume{{Date: apr2, ProjectID: "4974", CurrencySymbol: "USDC", Token: "usd-coin", TransactionCount: 1, TotalVolumeNative: 4}},
+	))
+	volumes, err = repo.FetchNativeVolumes(ctx, apr1, apr15)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []models.NativeVolume{
+		{Date: apr2, ProjectID: "4974", CurrencySymbol: "USDC", Token: "usd-coin", TransactionCount: 1, TotalVolumeNative: 4},
+		{Date: apr15, ProjectID: "4974", CurrencySymbol: "SFL", Token: "sunflower-land", TransactionCount: 1, TotalVolumeNative: 3},
+	}, volumes)
+
+	// Loading the same item twice keeps a single copy
+	txn := models.Transaction{
+		Timestamp: apr2.Add(time.Hour),
+		Event:     "BUY_ITEMS",
+		ProjectID: "4974",
+		Props:     models.Props{TxnHash: "0x1", TokenID: "215"},
+	}
+	require.NoError(t, repo.LoadTransactions(ctx, []models.Transaction{txn}))
+	require.NoError(t, repo.LoadTransactions(ctx, []models.Transaction{txn}))
+
+	require.NoError(t, repo.StoreRepriceAudit(ctx, []models.RepriceAudit{
+		{RepricedAt: time.Now(), Token: "matic-network", Date: apr2, ProjectID: "4974", OldVolumeUSD: 6, NewVolumeUSD: 7},
+	}))
+}
+
+func testPriceRepository(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	apr2 := time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)
+
+	exists, err := repo.HasPrices(ctx, apr2)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, repo.StorePrices(ctx, apr2, "coingecko", map[string]float64{"matic-network": 0.5, "usd-coin": 1}))
+	exists, err = repo.HasPrices(ctx, apr2)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	// A corrected price replaces the earlier one
+	require.NoError(t, repo.StorePrices(ctx, apr2, "snapshot", map[string]float64{"matic-network": 0.7}))
+
+	prices, err := repo.FetchPrices(ctx, []string{"matic-network", "usd-coin", "bitcoin"}, apr2)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"matic-network": 0.7, "usd-coin": 1}, prices)
+
+	history, err := repo.FetchPriceHistory(ctx, apr2, apr2, nil)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, "matic-network", history[0].Token)
+	assert.Equal(t, apr2, history[0].Date)
+	assert.Equal(t, 0.7, history[0].AveragePriceUSD)
+	assert.Equal(t, "snapshot", history[0].Source)
+	assert.False(t, history[0].FetchedAt.IsZero())
+	assert.Equal(t, "coingecko", history[1].Source)
+
+	// History is restricted to the requested tokens
+	filtered, err := repo.FetchPriceHistory(ctx, apr2, apr2, []string{"usd-coin", "bitcoin"})
+	require.NoError(t, err)
+	assert.Equal(t, history[1:], filtered)
+
+	// The latest price of a token is the one of its most recent day
+	apr3 := apr2.AddDate(0, 0, 1)
+	require.NoError(t, repo.StorePrices(ctx, apr3, "coingecko", map[string]float64{"usd-coin": 0.99}))
+	latest, err := repo.FetchLatestPrices(ctx, nil)
+	require.NoError(t, err)
+	require.Len(t, latest, 2)
+	assert.Equal(t, "matic-network", latest[0].Token)
+	assert.Equal(t, apr2, latest[0].Date)
+	assert.Equal(t, 0.7, latest[0].AveragePriceUSD)
+	assert.Equal(t, "snapshot", latest[0].Source)
+	assert.Equal(t, "usd-coin", latest[1].Token)
+	assert.Equal(t, apr3, latest[1].Date)
+	assert.Equal(t, 0.99, latest[1].AveragePriceUSD)
+
+	latest, err = repo.FetchLatestPrices(ctx, []string{"usd-coin", "bitcoin"})
+	require.NoError(t, err)
+	require.Len(t, latest, 1)
+	assert.Equal(t, "usd-coin", latest[0].Token)
+}
+
+// TestSQLiteQueriesWhileStreaming checks that rows held open by a stream don't block other queries or writes.
+func TestSQLiteQueriesWhileStreaming(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	repo, err := NewSQLiteRepository(ctx, filepath.Join(t.TempDir(), "pipeline.db"))
+	require.NoError(t, err)
+	defer repo.Close()
+
+	apr1 := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	apr2 := time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, repo.LoadAggregates(ctx, []models.AggregatedData{
+		{Date: apr1, ProjectID: "4974", TransactionCount: 5, TotalVolumeUSD: 10},
+		{Date: apr1, ProjectID: "1609", TransactionCount: 1, TotalVolumeUSD: 2},
+	}))
+
+	var streamed int
+	require.NoError(t, repo.StreamRollup(ctx, GranularityDay, apr1, apr1, nil, func(models.AggregatedData) error {
+		streamed++
+		if streamed > 1 {
+			return nil
+		}
+		if _, err := repo.FetchMetrics(ctx, apr1); err != nil {
+			return err
+		}
+		if err := repo.LoadAggregates(ctx, []models.AggregatedData{{Date: apr2, ProjectID: "4974", TransactionCount: 1, TotalVolumeUSD: 1}}); err != nil {
+			return err
+		}
+		return repo.Ping(ctx)
+	}))
+	assert.Equal(t, 2, streamed)
+
+	metrics, err := repo.FetchMetrics(ctx, apr2)
+	require.NoError(t, err)
+	assert.Len(t, metrics, 1)
+}
+
+// TestSQLiteAddsColumns checks that databases created before a column existed gain it when opened.
+func TestSQLiteAddsColumns(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "pipeline.db")
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, `CREATE TABLE token_prices (
+        token TEXT NOT NULL,
+        date TEXT NOT NULL,
+        average_price_usd REAL NOT NULL,
+        fetched_at TEXT NOT NULL
+    )`)
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, "INSERT INTO token_prices VALUES ('usd-coin', '2024-04-02', 1, '2024-04-03 00:00:00.000000000')")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	for range 2 {
+		repo, err := NewSQLiteRepository(ctx, path)
+		require.NoError(t, err)
+		latest, err := repo.FetchLatestPrices(ctx, nil)
+		require.NoError(t, err)
+		require.Len(t, latest, 1)
+		assert.Equal(t, "unknown", latest[0].Source)
+		require.NoError(t, repo.Close())
+	}
+}
+
+func testInputLedger(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	first := models.ProcessedInput{
+		URI:         "s3://exports/2024-04-02.csv",
+		Size:        1024,
+		SHA256:      "aaa",
+		RowCount:    10,
+		RunID:       "run-1",
+		ProcessedAt: time.Date(2024, 4, 3, 1, 0, 0, 0, time.UTC),
+	}
+	changed := first
+	changed.SHA256 = "bbb"
+	changed.RunID = "run-2"
+	changed.ProcessedAt = first.ProcessedAt.Add(time.Hour)
+
+	_, found, err := repo.FindProcessedInput(ctx, "aaa")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	_, found, err = repo.LastProcessedInput(ctx)
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	require.NoError(t, repo.RecordProcessedInputs(ctx, []models.ProcessedInput{first, changed}))
+
+	input, found, err := repo.FindProcessedInput(ctx, "aaa")
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, first, input)
+
+	latest, found, err := repo.LatestProcessedInput(ctx, first.URI)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, "bbb", latest.SHA256)
+	assert.Equal(t, "run-2", latest.RunID)
+
+	_, found, err = repo.LatestProcessedInput(ctx, "s3://exports/2024-04-03.csv")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	last, found, err := repo.LastProcessedInput(ctx)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, "run-2", last.RunID)
+}
+
+func testFetchTransactions(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	txn := func(ts time.Time, event, projectID, chainID, txnHash string) models.Transaction {
+		return models.Transaction{
+			Timestamp: ts,
+			Event:     event,
+			ProjectID: projectID,
+			UserID:    "trader-" + txnHash,
+			Props:     models.Props{ChainID: chainID, CurrencySymbol: "ETH", TxnHash: txnHash, TokenID: "1"},
+			Nums:      models.Nums{CurrencyValueDecimal: "1.5", CurrencyValueRaw: "1500000000000000000"},
+		}
+	}
+	apr1 := time.Date(2024, 4, 1, 10, 30, 0, 0, time.UTC)
+	apr2 := time.Date(2024, 4, 2, 9, 0, 0, 0, time.UTC)
+	apr3 := time.Date(2024, 4, 3, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, repo.LoadTransactions(ctx, []models.Transaction{
+		txn(apr2, "BUY_ITEMS", "4974", "137", "0x2"),
+		txn(apr1, "BUY_ITEMS", "4974", "1", "0x1"),
+		txn(apr1.Add(time.Hour), "SELL_ITEMS", "1609", "137", "0x3"),
+		txn(apr3, "BUY_ITEMS", "4974", "137", "0x4"),
+	}))
+
+	tests := []struct {
+		name   string
+		filter TransactionFilter
+		want   []string
+	}{
+		{
+			name:   "range is end exclusive",
+			filter: TransactionFilter{From: apr1.Truncate(24 * time.Hour), To: apr3},
+			want:   []string{"0x1", "0x3", "0x2"},
+		},
+		{
+			name:   "projects",
+			filter: TransactionFilter{From: apr1.Truncate(24 * time.Hour), To: apr3.Add(time.Hour), ProjectIDs: []string{"4974"}},
+			want:   []string{"0x1", "0x2", "0x4"},
+		},
+		{
+			name:   "chains and events",
+			filter: TransactionFilter{From: apr1.Truncate(24 * time.Hour), To: apr3, ChainIDs: []string{"137"}, Events: []string{"BUY_ITEMS", "LIST_ITEM"}},
+			want:   []string{"0x2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transactions, err := repo.FetchTransactions(ctx, tt.filter)
+			require.NoError(t, err)
+			var hashes []string
+			for _, txn := range transactions {
+				hashes = append(hashes, txn.Props.TxnHash)
+			}
+			assert.Equal(t, tt.want, hashes)
+		})
+	}
+
+	transactions, err := repo.FetchTransactions(ctx, TransactionFilter{From: apr1, To: apr1.Add(time.Minute)})
+	require.NoError(t, err)
+	require.Len(t, transactions, 1)
+	assert.Equal(t, txn(apr1, "BUY_ITEMS", "4974", "1", "0x1"), transactions[0])
+}
@@ -0,0 +1,156 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/estensen/marketplace-pipeline/internal/models"
+)
+
+// FetchNativeVolumes retrieves native currency volumes from ClickHouse between from and to, inclusive.
+func (r *ClickHouseRepository) FetchNativeVolumes(ctx context.Context, from, to time.Time) ([]models.NativeVolume, error) {
+	var volumes []models.NativeVolume
+	query := `
+        SELECT
+            date,
+            project_id,
+            currency_symbol,
+            token,
+            SUM(transaction_count) AS transaction_count,
+            SUM(total_volume_native) AS total_volume_native
+        FROM marketplace_volume_native
+        WHERE date BETWEEN ? AND ?
+        GROUP BY date, project_id, currency_symbol, token
+        `
+
+	if err := r.Conn.Select(ctx, &volumes, query, from, to); err != nil {
+		return nil, fmt.Errorf("error executing native volume query: %w", err)
+	}
+
+	return volumes, nil
+}
+
+// ReplaceAggregates replaces the analytics rows for each day and project in keys with data and rebuilds the
+// rollups covering them. ClickHouse has no transactions, so the new rows are inserted first with a version in
+// inserted_at, and only then are the older rows of each key deleted. If a step after the insert fails, the error
+// matches ErrPartialReplace: the replaced rows are counted twice until the replacement is retried.
+func (r *ClickHouseRepository) ReplaceAggregates(ctx context.Context, keys []models.AggregatedData, data []models.AggregatedData) error {
+	version, err := r.nextVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(data) > 0 {
+		if err := r.insertAggregates(ctx, data, version); err != nil {
+			return fmt.Errorf("error inserting replacement analytics: %w", err)
+		}
+	}
+
+	for _, key := range keys {
+		query := "DELETE FROM marketplace_analytics WHERE date = ? AND project_id = ? AND inserted_at < ?"
+		if err := r.Conn.Exec(ctx, query, key.Date, key.ProjectID, version); err != nil {
+			return partialReplace(fmt.Errorf("error deleting replaced analytics for project %s on %s: %w", key.ProjectID, key.Date.Format("2006-01-02"), err))
+		}
+	}
+
+	if err := r.refreshRollups(ctx, keys); err != nil {
+		return partialReplace(err)
+	}
+
+	return nil
+}
+
+// ReplaceNativeVolumes replaces the native volumes for each day and project in keys with data, inserting the new
+// rows before deleting the older ones like ReplaceAggregates. If the deletes fail, the error matches ErrPartialReplace.
+func (r *ClickHouseRepository) ReplaceNativeVolumes(ctx context.Context, keys []models.AggregatedData, data []models.NativeVolume) error {
+	version, err := r.nextVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(data) > 0 {
+		if err := r.insertNativeVolumes(ctx, data, version); err != nil {
+			return fmt.Errorf("error inserting replacement native volumes: %w", err)
+		}
+	}
+
+	for _, key := range keys {
+		query := "DELETE FROM marketplace_volume_native WHERE date = ? AND project_id = ? AND inserted_at < ?"
+		if err := r.Conn.Exec(ctx, query, key.Date, key.ProjectID, version); err != nil {
+			return partialReplace(fmt.Errorf("error deleting replaced native volumes for project %s on %s: %w", key.ProjectID, key.Date.Format("2006-01-02"), err))
+		}
+	}
+
+	return nil
+}
+
+// nextVersion returns a version newer than the inserted_at of every row already in the database.
+func (r *ClickHouseRepository) nextVersion(ctx context.Context) (time.Time, error) {
+	var version time.Time
+	if err := r.Conn.QueryRow(ctx, "SELECT now64(3) + toIntervalMillisecond(1)").Scan(&version); err != nil {
+		return time.Time{}, fmt.Errorf("error reading ClickHouse time: %w", err)
+	}
+	return version, nil
+}
+
+// insertAggregates inserts analytics rows with the given version as their inserted_at.
+func (r *ClickHouseRepository) insertAggregates(ctx context.Context, data []models.AggregatedData, version time.Time) error {
+	batch, err := r.Conn.PrepareBatch(ctx, "INSERT INTO marketplace_analytics (date, project_id, transaction_count, total_volume_usd, inserted_at)")
+	if err != nil {
+		return err
+	}
+
+	for _, record := range data {
+		if err := batch.Append(record.Date, record.ProjectID, record.TransactionCount, record.TotalVolumeUSD, version); err != nil {
+			return err
+		}
+	}
+
+	return r.sendBatch("marketplace_analytics", batch)
+}
+
+// insertNativeVolumes inserts native volumes with the given version as their inserted_at.
+func (r *ClickHouseRepository) insertNativeVolumes(ctx context.Context, data []models.NativeVolume, version time.Time) error {
+	batch, err := r.Conn.PrepareBatch(ctx, "INSERT INTO marketplace_volume_native (date, project_id, currency_symbol, token, transaction_count, total_volume_native, inserted_at)")
+	if err != nil {
+		return err
+	}
+
+	for _, record := range data {
+		if err := batch.Append(record.Date, record.ProjectID, record.CurrencySymbol, record.Token, record.TransactionCount, record.TotalVolumeNative, version); err != nil {
+			return err
+		}
+	}
+
+	return r.sendBatch("marketplace_volume_native", batch)
+}
+
+// partialReplace marks err as a failure after the replacement rows were inserted and logs it.
+func partialReplace(err error) error {
+	err = fmt.Errorf("%w: %w", ErrPartialReplace, err)
+	log.Printf("Replacement left duplicate rows, retry it: %v", err)
+	return err
+}
+
+// StoreRepriceAudit records the before and after values of repriced analytics rows.
+func (r *ClickHouseRepository) StoreRepriceAudit(ctx context.Context, entries []models.RepriceAudit) error {
+	batch, err := r.Conn.PrepareBatch(ctx, "INSERT INTO reprice_audit (repriced_at, token, date, project_id, old_transaction_count, new_transaction_count, old_volume_usd, new_volume_usd)")
+	if err != nil {
+		return fmt.Errorf("error preparing ClickHouse batch: %w", err)
+	}
+
+	for _, entry := range entries {
+		err := batch.Append(entry.RepricedAt, entry.Token, entry.Date, entry.ProjectID, entry.OldTransactionCount, entry.NewTransactionCount, entry.OldVolumeUSD, entry.NewVolumeUSD)
+		if err != nil {
+			return fmt.Errorf("error appending to ClickHouse batch: %w", err)
+		}
+	}
+
+	if err := r.sendBatch("reprice_audit", batch); err != nil {
+		return fmt.Errorf("error sending batch to ClickHouse: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/estensen/marketplace-pipeline/internal/models"
+)
+
+// ErrInvalidGranularity is returned for granularities without a rollup table.
+var ErrInvalidGranularity = errors.New("invalid granularity")
+
+// Granularity is the period length of an analytics rollup.
+type Granularity string
+
+const (
+	GranularityHour  Granularity = "hour"
+	GranularityDay   Granularity = "day"
+	GranularityWeek  Granularity = "week"
+	GranularityMonth Granularity = "month"
+)
+
+// ParseGranularity converts a string to a Granularity, defaulting to daily when empty.
+func ParseGranularity(s string) (Granularity, error) {
+	switch g := Granularity(s); g {
+	case "":
+		return GranularityDay, nil
+	case GranularityHour, GranularityDay, GranularityWeek, GranularityMonth:
+		return g, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrInvalidGranularity, s)
+	}
+}
+
+// HasRollup reports whether analytics are rolled up at this granularity. Hourly metrics are computed from raw transactions.
+func (g Granularity) HasRollup() bool {
+	return g != GranularityHour
+}
+
+// RollupTable returns the name of the table holding rollups of this granularity.
+func (g Granularity) RollupTable() string {
+	switch g {
+	case GranularityWeek:
+		return "marketplace_analytics_weekly"
+	case GranularityMonth:
+		return "marketplace_analytics_monthly"
+	default:
+		return "marketplace_analytics_daily"
+	}
+}
+
+// PeriodStart returns the start of the period containing date. Weeks start on Monday.
+func (g Granularity) PeriodStart(date time.Time) time.Time {
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	switch g {
+	case GranularityHour:
+		return day.Add(time.Duration(date.Hour()) * time.Hour)
+	case GranularityWeek:
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case GranularityMonth:
+		return day.AddDate(0, 0, 1-day.Day())
+	default:
+		return day
+	}
+}
+
+// NextPeriodStart returns the start of the period following the one starting at periodStart.
+func (g Granularity) NextPeriodStart(periodStart time.Time) time.Time {
+	switch g {
+	case GranularityHour:
+		return periodStart.Add(time.Hour)
+	case GranularityWeek:
+		return periodStart.AddDate(0, 0, 7)
+	case GranularityMonth:
+		return periodStart.AddDate(0, 1, 0)
+	default:
+		return periodStart.AddDate(0, 0, 1)
+	}
+}
+
+// periodStartExpr returns the ClickHouse expression truncating column to the start of its period.
+func (g Granularity) periodStartExpr(column string) string {
+	switch g {
+	case GranularityHour:
+		return fmt.Sprintf("toStartOfHour(%s)", column)
+	case GranularityWeek:
+		return fmt.Sprintf("toStartOfWeek(%s, 1)", column)
+	case GranularityMonth:
+		return fmt.Sprintf("toStartOfMonth(%s)", column)
+	default:
+		return column
+	}
+}
+
+// FetchRollup retrieves the metrics per period and project from the rollup of g, for the periods starting
+// between from and to, inclusive, of projectIDs or of every project when empty, ordered by period and project.
+func (r *ClickHouseRepository) FetchRollup(ctx context.Context, g Granularity, from, to time.Time, projectIDs []string) ([]models.AggregatedData, error) {
+	var metrics []models.AggregatedData
+	err := r.StreamRollup(ctx, g, from, to, projectIDs, func(data models.AggregatedData) error {
+		metrics = append(metrics, data)
+		return nil
+	})
+	return metrics, err
+}
+
+// StreamRollup calls fn with the metrics per period and project from the rollup of g, for the periods starting
+// between from and to, inclusive, of projectIDs or of every project when empty, ordered by period and project,
+// reading them row by row.
+func (r *ClickHouseRepository) StreamRollup(ctx context.Context, g Granularity, from, to time.Time, projectIDs []string, fn func(models.AggregatedData) error) error {
+	if !g.HasRollup() {
+		return fmt.Errorf("%w: no %s rollup", ErrInvalidGranularity, g)
+	}
+
+	// Rows of a period are only summed once their parts are merged, so they are summed again here
+	query := fmt.Sprintf(`
+        SELECT
+            period_start AS date,
+            project_id,
+            SUM(transaction_count) AS transaction_count,
+            SUM(total_volume_usd) AS total_volume_usd
+        FROM %s
+        WHERE period_start BETWEEN ? AND ?`, g.RollupTable())
+	args := []any{from, to}
+	if len(projectIDs) > 0 {
+		query += " AND project_id IN ?"
+		args = append(args, projectIDs)
+	}
+	query += " GROUP BY period_start, project_id ORDER BY period_start, project_id"
+
+	rows, err := r.Conn.Query(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("error executing rollup query: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data models.AggregatedData
+		if err := rows.ScanStruct(&data); err != nil {
+			return fmt.Errorf("error scanning rollup row: %w", err)
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating rollup rows: %w", err)
+	}
+
+	return nil
+}
+
+// refreshRollups rebuilds the rollup rows covering each day and project in keys from marketplace_analytics.
+// Materialized views only see inserts, so rollups must be refreshed after analytics rows are deleted.
+func (r *ClickHouseRepository) refreshRollups(ctx context.Context, keys []models.AggregatedData) error {
+	for _, g := range []Granularity{GranularityDay, GranularityWeek, GranularityMonth} {
+		refreshed := make(map[string]struct{})
+		for _, key := range keys {
+			periodStart := g.PeriodStart(key.Date)
+			id := periodStart.Format("2006-01-02") + key.ProjectID
+			if _, exists := refreshed[id]; exists {
+				continue
+			}
+			refreshed[id] = struct{}{}
+
+			query := fmt.Sprintf("DELETE FROM %s WHERE period_start = ? AND project_id = ?", g.RollupTable())
+			if err := r.Conn.Exec(ctx, query, periodStart, key.ProjectID); err != nil {
+				return fmt.Errorf("error deleting %s rollup: %w", g, err)
+			}
+
+			query = fmt.Sprintf(`
+                INSERT INTO %s
+                SELECT
+                    %s AS period_start,
+                    project_id,
+                    SUM(transaction_count) AS transaction_count,
+                    SUM(total_volume_usd) AS total_volume_usd
+                FROM marketplace_analytics
+                WHERE period_start = ? AND project_id = ?
+                GROUP BY period_start, project_id
+                `, g.RollupTable(), g.periodStartExpr("date"))
+			if err := r.Conn.Exec(ctx, query, periodStart, key.ProjectID); err != nil {
+				return fmt.Errorf("error rebuilding %s rollup: %w", g, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGranularity(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    Granularity
+		expectedErr error
+	}{
+		{input: "", expected: GranularityDay},
+		{input: "day", expected: GranularityDay},
+		{input: "week", expected: GranularityWeek},
+		{input: "month", expected: GranularityMonth},
+		{input: "year", expectedErr: ErrInvalidGranularity},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			granularity, err := ParseGranularity(tc.input)
+			if tc.expectedErr != nil {
+				assert.True(t, errors.Is(err, tc.expectedErr), "expected error type does not match")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, granularity)
+		})
+	}
+}
+
+func TestPeriodStart(t *testing.T) {
+	// 2024-04-17 is a Wednesday
+	date := time.Date(2024, 4, 17, 13, 45, 0, 0, time.UTC)
+
+	tests := []struct {
+		granularity Granularity
+		expected    time.Time
+	}{
+		{granularity: GranularityDay, expected: time.Date(2024, 4, 17, 0, 0, 0, 0, time.UTC)},
+		{granularity: GranularityWeek, expected: time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC)},
+		{granularity: GranularityMonth, expected: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tc := range tests {
+		t.Run(string(tc.granularity), func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.granularity.PeriodStart(date))
+		})
+	}
+
+	// A Sunday belongs to the week starting the previous Monday
+	sunday := time.Date(2024, 4, 21, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC), GranularityWeek.PeriodStart(sunday))
+}
+
+func TestMigrationsOrdered(t *testing.T) {
+	for i := 1; i < len(Migrations); i++ {
+		assert.Greater(t, Migrations[i].Version, Migrations[i-1].Version, "migration %q is out of order", Migrations[i].Description)
+	}
+	assert.Equal(t, Migrations[len(Migrations)-1].Version, LatestSchemaVersion())
+}
+
+func TestRebuildRollupStatements(t *testing.T) {
+	statements := rebuildRollupStatements()
+	assert.True(t, strings.HasPrefix(statements[0], "ALTER TABLE marketplace_analytics ADD COLUMN IF NOT EXISTS inserted_at "))
+	for _, statement := range statements {
+		// The views and backfills are left to rebuildRollups, which splits the rows at a cutoff
+		assert.NotContains(t, statement, "CREATE MATERIALIZED VIEW")
+		assert.False(t, strings.HasPrefix(statement, "INSERT INTO"), statement)
+	}
+
+	cutoff := time.Date(2024, 4, 16, 12, 30, 5, 250_000_000, time.UTC)
+	view := rollupViewStatement(GranularityWeek, insertedSince(cutoff))
+	backfill := rollupBackfillStatement(GranularityWeek, "NOT "+insertedSince(cutoff))
+	assert.Contains(t, view, "FROM marketplace_analytics\n            WHERE inserted_at >= toDateTime64('2024-04-16 12:30:05.250', 3, 'UTC')\n")
+	assert.Contains(t, backfill, "FROM marketplace_analytics\n            WHERE NOT inserted_at >= toDateTime64('2024-04-16 12:30:05.250', 3, 'UTC')\n")
+
+	// Without a condition every row is rolled up, as in the original rollup migration
+	assert.NotContains(t, rollupViewStatement(GranularityWeek, ""), "WHERE")
+	assert.NotContains(t, rollupBackfillStatement(GranularityWeek, ""), "WHERE")
+}
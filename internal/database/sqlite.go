@@ -0,0 +1,714 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/estensen/marketplace-pipeline/internal/models"
+
+	// Registers the pure-Go "sqlite" driver.
+	_ "modernc.org/sqlite"
+)
+
+const (
+	sqliteDateFormat = "2006-01-02"
+	sqliteTimeFormat = "2006-01-02 15:04:05.000000000"
+)
+
+// sqliteSchema creates the SQLite equivalents of the ClickHouse tables.
+// Rollups are computed at query time, so there are no materialized views.
+var sqliteSchema = []string{
+	`CREATE TABLE IF NOT EXISTS marketplace_analytics (
+        date TEXT NOT NULL,
+        project_id TEXT NOT NULL,
+        transaction_count INTEGER NOT NULL,
+        total_volume_usd REAL NOT NULL
+    )`,
+	`CREATE INDEX IF NOT EXISTS marketplace_analytics_date ON marketplace_analytics (date, project_id)`,
+	`CREATE TABLE IF NOT EXISTS token_prices (
+        token TEXT NOT NULL,
+        date TEXT NOT NULL,
+        average_price_usd REAL NOT NULL,
+        source TEXT NOT NULL DEFAULT 'unknown',
+        fetched_at TEXT NOT NULL
+    )`,
+	`CREATE INDEX IF NOT EXISTS token_prices_date ON token_prices (date, token)`,
+	`CREATE TABLE IF NOT EXISTS marketplace_volume_native (
+        date TEXT NOT NULL,
+        project_id TEXT NOT NULL,
+        currency_symbol TEXT NOT NULL,
+        token TEXT NOT NULL,
+        transaction_count INTEGER NOT NULL,
+        total_volume_native REAL NOT NULL
+    )`,
+	`CREATE INDEX IF NOT EXISTS marketplace_volume_native_date ON marketplace_volume_native (date, project_id)`,
+	`CREATE TABLE IF NOT EXISTS reprice_audit (
+        repriced_at TEXT NOT NULL,
+        token TEXT NOT NULL,
+        date TEXT NOT NULL,
+        project_id TEXT NOT NULL,
+        old_transaction_count INTEGER NOT NULL,
+        new_transaction_count INTEGER NOT NULL,
+        old_volume_usd REAL NOT NULL,
+        new_volume_usd REAL NOT NULL
+    )`,
+	`CREATE TABLE IF NOT EXISTS marketplace_transactions (
+        ts TEXT NOT NULL,
+        event TEXT NOT NULL,
+        project_id TEXT NOT NULL,
+        user_id TEXT NOT NULL DEFAULT '',
+        currency_symbol TEXT NOT NULL,
+        chain_id TEXT NOT NULL,
+        collection_address TEXT NOT NULL,
+        currency_address TEXT NOT NULL,
+        token_id TEXT NOT NULL,
+        txn_hash TEXT NOT NULL,
+        marketplace_type TEXT NOT NULL,
+        request_id TEXT NOT NULL,
+        currency_value_decimal TEXT NOT NULL,
+        currency_value_raw TEXT NOT NULL
+    )`,
+	`CREATE INDEX IF NOT EXISTS marketplace_transactions_project ON marketplace_transactions (project_id, ts)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS marketplace_transactions_item
+        ON marketplace_transactions (txn_hash, token_id) WHERE txn_hash != ''`,
+	`CREATE TABLE IF NOT EXISTS processed_inputs (
+        uri TEXT NOT NULL,
+        size INTEGER NOT NULL,
+        sha256 TEXT NOT NULL,
+        row_count INTEGER NOT NULL,
+        run_id TEXT NOT NULL,
+        processed_at TEXT NOT NULL
+    )`,
+	`CREATE INDEX IF NOT EXISTS processed_inputs_sha256 ON processed_inputs (sha256)`,
+	`CREATE INDEX IF NOT EXISTS processed_inputs_uri ON processed_inputs (uri)`,
+}
+
+// sqliteColumn is a column added to a table after it was first created.
+type sqliteColumn struct {
+	table      string
+	name       string
+	definition string
+}
+
+// sqliteColumns are added to databases created before the columns existed, since SQLite has no
+// ADD COLUMN IF NOT EXISTS.
+var sqliteColumns = []sqliteColumn{
+	{table: "token_prices", name: "source", definition: "TEXT NOT NULL DEFAULT 'unknown'"},
+	{table: "marketplace_transactions", name: "user_id", definition: "TEXT NOT NULL DEFAULT ''"},
+}
+
+// sqliteReaders is the number of connections reading the database at once, so a slow export streaming rows
+// doesn't hold up other queries.
+const sqliteReaders = 4
+
+// sqliteBusyTimeoutMillis bounds how long a connection waits for a lock held by another connection.
+const sqliteBusyTimeoutMillis = 5000
+
+// SQLiteRepository implements MetricsRepository and PriceRepository on an embedded SQLite file.
+type SQLiteRepository struct {
+	// DB reads the database over several connections, which WAL mode lets run alongside the writer.
+	DB *sql.DB
+	// writer is the single connection writing to the database, since SQLite allows one writer at a time.
+	writer *sql.DB
+}
+
+// NewSQLiteRepository opens the SQLite database file at path, creating it and its tables if needed.
+func NewSQLiteRepository(ctx context.Context, path string) (*SQLiteRepository, error) {
+	// WAL mode is stored in the file, so it is set on the writer before any reader connects
+	writer, err := openSQLite(path, "journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	writer.SetMaxOpenConns(1)
+
+	for _, statement := range sqliteSchema {
+		if _, err := writer.ExecContext(ctx, statement); err != nil {
+			writer.Close()
+			return nil, fmt.Errorf("error creating SQLite schema: %w", err)
+		}
+	}
+	for _, column := range sqliteColumns {
+		if err := addSQLiteColumn(ctx, writer, column); err != nil {
+			writer.Close()
+			return nil, fmt.Errorf("error creating SQLite schema: %w", err)
+		}
+	}
+
+	db, err := openSQLite(path, "query_only(1)")
+	if err != nil {
+		writer.Close()
+		return nil, err
+	}
+	db.SetMaxOpenConns(sqliteReaders)
+
+	log.Printf("Successfully opened SQLite database at %s.", path)
+	return &SQLiteRepository{DB: db, writer: writer}, nil
+}
+
+// openSQLite opens a connection pool to the database file at path, applying pragmas to every connection.
+func openSQLite(path string, pragmas ...string) (*sql.DB, error) {
+	dsn := fmt.Sprintf("%s?_pragma=busy_timeout(%d)", path, sqliteBusyTimeoutMillis)
+	for _, pragma := range pragmas {
+		dsn += "&_pragma=" + pragma
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error opening SQLite database: %w", err)
+	}
+	return db, nil
+}
+
+// addSQLiteColumn adds column to its table unless it already has it.
+func addSQLiteColumn(ctx context.Context, db *sql.DB, column sqliteColumn) error {
+	var count int
+	query := "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?"
+	if err := db.QueryRowContext(ctx, query, column.table, column.name).Scan(&count); err != nil {
+		return fmt.Errorf("error inspecting table %s: %w", column.table, err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	statement := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", column.table, column.name, column.definition)
+	if _, err := db.ExecContext(ctx, statement); err != nil {
+		return fmt.Errorf("error adding column %s.%s: %w", column.table, column.name, err)
+	}
+	return nil
+}
+
+// Ping checks that the database is reachable.
+func (r *SQLiteRepository) Ping(ctx context.Context) error {
+	if err := r.DB.PingContext(ctx); err != nil {
+		return fmt.Errorf("error pinging SQLite: %w", err)
+	}
+	return nil
+}
+
+// Close closes the reader and writer connections.
+func (r *SQLiteRepository) Close() error {
+	return errors.Join(r.DB.Close(), r.writer.Close())
+}
+
+// LoadAggregates inserts aggregated data into the database.
+func (r *SQLiteRepository) LoadAggregates(ctx context.Context, data []models.AggregatedData) error {
+	return r.insertRows(ctx, "INSERT INTO marketplace_analytics (date, project_id, transaction_count, total_volume_usd) VALUES (?, ?, ?, ?)", len(data), func(i int) []any {
+		return []any{data[i].Date.Format(sqliteDateFormat), data[i].ProjectID, data[i].TransactionCount, data[i].TotalVolumeUSD}
+	})
+}
+
+// LoadNativeVolumes inserts per-currency native volumes into the database.
+func (r *SQLiteRepository) LoadNativeVolumes(ctx context.Context, data []models.NativeVolume) error {
+	return r.insertRows(ctx, "INSERT INTO marketplace_volume_native (date, project_id, currency_symbol, token, transaction_count, total_volume_native) VALUES (?, ?, ?, ?, ?, ?)", len(data), func(i int) []any {
+		v := data[i]
+		return []any{v.Date.Format(sqliteDateFormat), v.ProjectID, v.CurrencySymbol, v.Token, v.TransactionCount, v.TotalVolumeNative}
+	})
+}
+
+// LoadTransactions inserts raw transactions, ignoring items that were already loaded.
+func (r *SQLiteRepository) LoadTransactions(ctx context.Context, transactions []models.Transaction) error {
+	query := `INSERT OR IGNORE INTO marketplace_transactions (
+        ts, event, project_id, user_id, currency_symbol, chain_id, collection_address, currency_address,
+        token_id, txn_hash, marketplace_type, request_id, currency_value_decimal, currency_value_raw)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	return r.insertRows(ctx, query, len(transactions), func(i int) []any {
+		txn := transactions[i]
+		return []any{
+			txn.Timestamp.UTC().Format(sqliteTimeFormat),
+			txn.Event,
+			txn.ProjectID,
+			txn.UserID,
+			txn.Props.CurrencySymbol,
+			txn.Props.ChainID,
+			txn.Props.CollectionAddress,
+			txn.Props.CurrencyAddress,
+			txn.Props.TokenID,
+			txn.Props.TxnHash,
+			txn.Props.MarketplaceType,
+			txn.Props.RequestID,
+			txn.Nums.CurrencyValueDecimal,
+			txn.Nums.CurrencyValueRaw,
+		}
+	})
+}
+
+// FetchMetrics retrieves the analytics rows for the given date.
+func (r *SQLiteRepository) FetchMetrics(ctx context.Context, date time.Time) ([]models.AggregatedData, error) {
+	query := `
+        SELECT date, project_id, transaction_count, total_volume_usd
+        FROM marketplace_analytics
+        WHERE date = ?
+        `
+	return r.queryAggregates(ctx, query, date.Format(sqliteDateFormat))
+}
+
+// FetchMetricsRange retrieves aggregated metrics per day and project between from and to, inclusive.
+func (r *SQLiteRepository) FetchMetricsRange(ctx context.Context, from, to time.Time) ([]models.AggregatedData, error) {
+	query := `
+        SELECT date, project_id, SUM(transaction_count), SUM(total_volume_usd)
+        FROM marketplace_analytics
+        WHERE date BETWEEN ? AND ?
+        GROUP BY date, project_id
+        ORDER BY date, project_id
+        `
+	return r.queryAggregates(ctx, query, from.Format(sqliteDateFormat), to.Format(sqliteDateFormat))
+}
+
+// FetchRollup retrieves the metrics per period and project at granularity g, for the periods starting between
+// from and to, inclusive, of projectIDs or of every project when empty, ordered by period and project.
+// SQLite has no rollup tables, so the analytics are summed.
+func (r *SQLiteRepository) FetchRollup(ctx context.Context, g Granularity, from, to time.Time, projectIDs []string) ([]models.AggregatedData, error) {
+	var metrics []models.AggregatedData
+	err := r.StreamRollup(ctx, g, from, to, projectIDs, func(data models.AggregatedData) error {
+		metrics = append(metrics, data)
+		return nil
+	})
+	return metrics, err
+}
+
+// StreamRollup calls fn with the metrics per period and project at granularity g, for the periods starting between
+// from and to, inclusive, of projectIDs or of every project when empty, ordered by period and project,
+// reading them row by row.
+func (r *SQLiteRepository) StreamRollup(ctx context.Context, g Granularity, from, to time.Time, projectIDs []string, fn func(models.AggregatedData) error) error {
+	if !g.HasRollup() {
+		return fmt.Errorf("%w: no %s rollup", ErrInvalidGranularity, g)
+	}
+
+	query := fmt.Sprintf(`
+        SELECT %s AS period_start, project_id, SUM(transaction_count), SUM(total_volume_usd)
+        FROM marketplace_analytics
+        WHERE date >= ? AND date < ?`, sqlitePeriodStartExpr(g, "date"))
+	args := []any{from.Format(sqliteDateFormat), g.NextPeriodStart(to).Format(sqliteDateFormat)}
+	if len(projectIDs) > 0 {
+		query += fmt.Sprintf(" AND project_id IN (?%s)", strings.Repeat(", ?", len(projectIDs)-1))
+		for _, id := range projectIDs {
+			args = append(args, id)
+		}
+	}
+	query += " GROUP BY period_start, project_id ORDER BY period_start, project_id"
+	return r.streamAggregates(ctx, query, fn, args...)
+}
+
+// sqlitePeriodStartExpr returns the SQLite expression truncating the date column to the start of its period.
+// Weeks start on Monday, and strftime('%w') counts days from Sunday.
+func sqlitePeriodStartExpr(g Granularity, column string) string {
+	switch g {
+	case GranularityWeek:
+		return fmt.Sprintf("date(%s, '-' || ((CAST(strftime('%%w', %s) AS INTEGER) + 6) %% 7) || ' days')", column, column)
+	case GranularityMonth:
+		return fmt.Sprintf("date(%s, 'start of month')", column)
+	default:
+		return column
+	}
+}
+
+// FetchTransactions retrieves the raw transactions matching filter, ordered by timestamp.
+func (r *SQLiteRepository) FetchTransactions(ctx context.Context, filter TransactionFilter) ([]models.Transaction, error) {
+	query := `
+        SELECT ts, event, project_id, user_id, currency_symbol, chain_id, collection_address, currency_address,
+            token_id, txn_hash, marketplace_type, request_id, currency_value_decimal, currency_value_raw
+        FROM marketplace_transactions
+        WHERE ts >= ? AND ts < ?`
+	args := []any{filter.From.UTC().Format(sqliteTimeFormat), filter.To.UTC().Format(sqliteTimeFormat)}
+	for _, in := range []struct {
+		column string
+		values []string
+	}{
+		{"project_id", filter.ProjectIDs},
+		{"chain_id", filter.ChainIDs},
+		{"event", filter.Events},
+	} {
+		if len(in.values) == 0 {
+			continue
+		}
+		query += fmt.Sprintf(" AND %s IN (?%s)", in.column, strings.Repeat(", ?", len(in.values)-1))
+		for _, value := range in.values {
+			args = append(args, value)
+		}
+	}
+	query += " ORDER BY ts, rowid"
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing transactions query: %w", err)
+	}
+	defer rows.Close()
+
+	var transactions []models.Transaction
+	for rows.Next() {
+		var txn models.Transaction
+		var ts string
+		err := rows.Scan(&ts, &txn.Event, &txn.ProjectID, &txn.UserID, &txn.Props.CurrencySymbol, &txn.Props.ChainID,
+			&txn.Props.CollectionAddress, &txn.Props.CurrencyAddress, &txn.Props.TokenID, &txn.Props.TxnHash,
+			&txn.Props.MarketplaceType, &txn.Props.RequestID, &txn.Nums.CurrencyValueDecimal, &txn.Nums.CurrencyValueRaw)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning transaction row: %w", err)
+		}
+		if txn.Timestamp, err = time.Parse(sqliteTimeFormat, ts); err != nil {
+			return nil, fmt.Errorf("error parsing transaction timestamp: %w", err)
+		}
+		transactions = append(transactions, txn)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating transaction rows: %w", err)
+	}
+
+	return transactions, nil
+}
+
+// FetchNativeVolumes retrieves native currency volumes between from and to, inclusive.
+func (r *SQLiteRepository) FetchNativeVolumes(ctx context.Context, from, to time.Time) ([]models.NativeVolume, error) {
+	query := `
+        SELECT date, project_id, currency_symbol, token, SUM(transaction_count), SUM(total_volume_native)
+        FROM marketplace_volume_native
+        WHERE date BETWEEN ? AND ?
+        GROUP BY date, project_id, currency_symbol, token
+        ORDER BY date, project_id, currency_symbol
+        `
+	rows, err := r.DB.QueryContext(ctx, query, from.Format(sqliteDateFormat), to.Format(sqliteDateFormat))
+	if err != nil {
+		return nil, fmt.Errorf("error executing native volume query: %w", err)
+	}
+	defer rows.Close()
+
+	var volumes []models.NativeVolume
+	for rows.Next() {
+		var v models.NativeVolume
+		var date string
+		if err := rows.Scan(&date, &v.ProjectID, &v.CurrencySymbol, &v.Token, &v.TransactionCount, &v.TotalVolumeNative); err != nil {
+			return nil, fmt.Errorf("error scanning native volume row: %w", err)
+		}
+		if v.Date, err = time.Parse(sqliteDateFormat, date); err != nil {
+			return nil, fmt.Errorf("error parsing native volume date: %w", err)
+		}
+		volumes = append(volumes, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating native volume rows: %w", err)
+	}
+
+	return volumes, nil
+}
+
+// ReplaceAggregates deletes the analytics rows for each day and project in keys and inserts data in their place.
+func (r *SQLiteRepository) ReplaceAggregates(ctx context.Context, keys []models.AggregatedData, data []models.AggregatedData) error {
+	tx, err := r.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting SQLite transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, key := range keys {
+		query := "DELETE FROM marketplace_analytics WHERE date = ? AND project_id = ?"
+		if _, err := tx.ExecContext(ctx, query, key.Date.Format(sqliteDateFormat), key.ProjectID); err != nil {
+			return fmt.Errorf("error deleting analytics for project %s on %s: %w", key.ProjectID, key.Date.Format("2006-01-02"), err)
+		}
+	}
+
+	query := "INSERT INTO marketplace_analytics (date, project_id, transaction_count, total_volume_usd) VALUES (?, ?, ?, ?)"
+	for _, record := range data {
+		if _, err := tx.ExecContext(ctx, query, record.Date.Format(sqliteDateFormat), record.ProjectID, record.TransactionCount, record.TotalVolumeUSD); err != nil {
+			return fmt.Errorf("error inserting repriced analytics: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ReplaceNativeVolumes deletes the native volumes for each day and project in keys and inserts data in their place.
+func (r *SQLiteRepository) ReplaceNativeVolumes(ctx context.Context, keys []models.AggregatedData, data []models.NativeVolume) error {
+	tx, err := r.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting SQLite transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, key := range keys {
+		query := "DELETE FROM marketplace_volume_native WHERE date = ? AND project_id = ?"
+		if _, err := tx.ExecContext(ctx, query, key.Date.Format(sqliteDateFormat), key.ProjectID); err != nil {
+			return fmt.Errorf("error deleting native volumes for project %s on %s: %w", key.ProjectID, key.Date.Format("2006-01-02"), err)
+		}
+	}
+
+	query := "INSERT INTO marketplace_volume_native (date, project_id, currency_symbol, token, transaction_count, total_volume_native) VALUES (?, ?, ?, ?, ?, ?)"
+	for _, record := range data {
+		if _, err := tx.ExecContext(ctx, query, record.Date.Format(sqliteDateFormat), record.ProjectID, record.CurrencySymbol, record.Token, record.TransactionCount, record.TotalVolumeNative); err != nil {
+			return fmt.Errorf("error inserting replacement native volumes: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// StoreRepriceAudit records the before and after values of repriced analytics rows.
+func (r *SQLiteRepository) StoreRepriceAudit(ctx context.Context, entries []models.RepriceAudit) error {
+	query := `INSERT INTO reprice_audit (
+        repriced_at, token, date, project_id, old_transaction_count, new_transaction_count, old_volume_usd, new_volume_usd)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	return r.insertRows(ctx, query, len(entries), func(i int) []any {
+		e := entries[i]
+		return []any{
+			e.RepricedAt.UTC().Format(sqliteTimeFormat), e.Token, e.Date.Format(sqliteDateFormat), e.ProjectID,
+			e.OldTransactionCount, e.NewTransactionCount, e.OldVolumeUSD, e.NewVolumeUSD,
+		}
+	})
+}
+
+// HasPrices reports whether any token prices are stored for the given date.
+func (r *SQLiteRepository) HasPrices(ctx context.Context, date time.Time) (bool, error) {
+	var count uint64
+	query := "SELECT COUNT(*) FROM token_prices WHERE date = ?"
+	if err := r.DB.QueryRowContext(ctx, query, date.Format(sqliteDateFormat)).Scan(&count); err != nil {
+		return false, fmt.Errorf("error querying SQLite: %w", err)
+	}
+	return count > 0, nil
+}
+
+// StorePrices inserts the token prices for the given date, as fetched from source.
+func (r *SQLiteRepository) StorePrices(ctx context.Context, date time.Time, source string, prices map[string]float64) error {
+	fetchedAt := time.Now().UTC().Format(sqliteTimeFormat)
+	coinIDs := make([]string, 0, len(prices))
+	for coinID := range prices {
+		coinIDs = append(coinIDs, coinID)
+	}
+
+	query := "INSERT INTO token_prices (token, date, average_price_usd, source, fetched_at) VALUES (?, ?, ?, ?, ?)"
+	return r.insertRows(ctx, query, len(coinIDs), func(i int) []any {
+		return []any{coinIDs[i], date.Format(sqliteDateFormat), prices[coinIDs[i]], source, fetchedAt}
+	})
+}
+
+// FetchPrices retrieves the latest token prices for the given coin IDs and date.
+func (r *SQLiteRepository) FetchPrices(ctx context.Context, coinIDs []string, date time.Time) (map[string]float64, error) {
+	prices := make(map[string]float64)
+	if len(coinIDs) == 0 {
+		return prices, nil
+	}
+
+	history, err := r.FetchPriceHistory(ctx, date, date, coinIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range history {
+		prices[p.Token] = p.AveragePriceUSD
+	}
+	return prices, nil
+}
+
+// FetchPriceHistory retrieves the latest price of each of tokens, or of every token when tokens is empty,
+// for each day between from and to, inclusive, ordered by date and token.
+func (r *SQLiteRepository) FetchPriceHistory(ctx context.Context, from, to time.Time, tokens []string) ([]models.TokenPrice, error) {
+	// Ties on fetched_at resolve to the row inserted last
+	query := `
+        SELECT token, date, average_price_usd, source, fetched_at
+        FROM token_prices AS p
+        WHERE date BETWEEN ? AND ?
+            AND rowid = (
+                SELECT rowid FROM token_prices
+                WHERE token = p.token AND date = p.date
+                ORDER BY fetched_at DESC, rowid DESC
+                LIMIT 1
+            )`
+	args := []any{from.Format(sqliteDateFormat), to.Format(sqliteDateFormat)}
+	if len(tokens) > 0 {
+		query += fmt.Sprintf(" AND token IN (?%s)", strings.Repeat(", ?", len(tokens)-1))
+		for _, token := range tokens {
+			args = append(args, token)
+		}
+	}
+	query += " ORDER BY date, token"
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing price history query: %w", err)
+	}
+	return scanTokenPrices(rows)
+}
+
+// FetchLatestPrices retrieves the most recent price of each of tokens, or of every token when tokens is empty.
+func (r *SQLiteRepository) FetchLatestPrices(ctx context.Context, tokens []string) ([]models.TokenPrice, error) {
+	// Ties on date and fetched_at resolve to the row inserted last
+	query := `
+        SELECT token, date, average_price_usd, source, fetched_at
+        FROM token_prices AS p
+        WHERE rowid = (
+                SELECT rowid FROM token_prices
+                WHERE token = p.token
+                ORDER BY date DESC, fetched_at DESC, rowid DESC
+                LIMIT 1
+            )`
+	var args []any
+	if len(tokens) > 0 {
+		query += fmt.Sprintf(" AND token IN (?%s)", strings.Repeat(", ?", len(tokens)-1))
+		for _, token := range tokens {
+			args = append(args, token)
+		}
+	}
+	query += " ORDER BY token"
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing latest prices query: %w", err)
+	}
+	return scanTokenPrices(rows)
+}
+
+// scanTokenPrices reads and closes rows of token, date, average_price_usd, source and fetched_at.
+func scanTokenPrices(rows *sql.Rows) ([]models.TokenPrice, error) {
+	defer rows.Close()
+
+	var prices []models.TokenPrice
+	for rows.Next() {
+		var p models.TokenPrice
+		var date, fetchedAt string
+		if err := rows.Scan(&p.Token, &date, &p.AveragePriceUSD, &p.Source, &fetchedAt); err != nil {
+			return nil, fmt.Errorf("error scanning price row: %w", err)
+		}
+		var err error
+		if p.Date, err = time.Parse(sqliteDateFormat, date); err != nil {
+			return nil, fmt.Errorf("error parsing price date: %w", err)
+		}
+		if p.FetchedAt, err = time.Parse(sqliteTimeFormat, fetchedAt); err != nil {
+			return nil, fmt.Errorf("error parsing price fetch time: %w", err)
+		}
+		prices = append(prices, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating price rows: %w", err)
+	}
+
+	return prices, nil
+}
+
+// FindProcessedInput returns the most recent ledger entry of an input with the given content checksum.
+func (r *SQLiteRepository) FindProcessedInput(ctx context.Context, sha256 string) (models.ProcessedInput, bool, error) {
+	query := `
+        SELECT uri, size, sha256, row_count, run_id, processed_at
+        FROM processed_inputs
+        WHERE sha256 = ?
+        ORDER BY processed_at DESC, rowid DESC
+        LIMIT 1
+        `
+	return r.queryProcessedInput(ctx, query, sha256)
+}
+
+// LatestProcessedInput returns the most recent ledger entry of the input at uri.
+func (r *SQLiteRepository) LatestProcessedInput(ctx context.Context, uri string) (models.ProcessedInput, bool, error) {
+	query := `
+        SELECT uri, size, sha256, row_count, run_id, processed_at
+        FROM processed_inputs
+        WHERE uri = ?
+        ORDER BY processed_at DESC, rowid DESC
+        LIMIT 1
+        `
+	return r.queryProcessedInput(ctx, query, uri)
+}
+
+// LastProcessedInput returns the most recent ledger entry of any input, which marks the last successful pipeline run.
+func (r *SQLiteRepository) LastProcessedInput(ctx context.Context) (models.ProcessedInput, bool, error) {
+	query := `
+        SELECT uri, size, sha256, row_count, run_id, processed_at
+        FROM processed_inputs
+        ORDER BY processed_at DESC, rowid DESC
+        LIMIT 1`
+	return r.queryProcessedInput(ctx, query)
+}
+
+// RecordProcessedInputs appends entries to the input ledger.
+func (r *SQLiteRepository) RecordProcessedInputs(ctx context.Context, inputs []models.ProcessedInput) error {
+	query := "INSERT INTO processed_inputs (uri, size, sha256, row_count, run_id, processed_at) VALUES (?, ?, ?, ?, ?, ?)"
+	return r.insertRows(ctx, query, len(inputs), func(i int) []any {
+		input := inputs[i]
+		return []any{input.URI, input.Size, input.SHA256, input.RowCount, input.RunID, input.ProcessedAt.UTC().Format(sqliteTimeFormat)}
+	})
+}
+
+// queryProcessedInput runs a ledger query returning at most one entry.
+func (r *SQLiteRepository) queryProcessedInput(ctx context.Context, query string, args ...any) (models.ProcessedInput, bool, error) {
+	var input models.ProcessedInput
+	var processedAt string
+	err := r.DB.QueryRowContext(ctx, query, args...).Scan(&input.URI, &input.Size, &input.SHA256, &input.RowCount, &input.RunID, &processedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.ProcessedInput{}, false, nil
+	}
+	if err != nil {
+		return models.ProcessedInput{}, false, fmt.Errorf("error executing input ledger query: %w", err)
+	}
+	if input.ProcessedAt, err = time.Parse(sqliteTimeFormat, processedAt); err != nil {
+		return models.ProcessedInput{}, false, fmt.Errorf("error parsing input processing time: %w", err)
+	}
+	return input, true, nil
+}
+
+// insertRows executes query once per row inside a single transaction.
+func (r *SQLiteRepository) insertRows(ctx context.Context, query string, n int, row func(i int) []any) error {
+	if n == 0 {
+		return nil
+	}
+
+	tx, err := r.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting SQLite transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("error preparing SQLite statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for i := 0; i < n; i++ {
+		if _, err := stmt.ExecContext(ctx, row(i)...); err != nil {
+			return fmt.Errorf("error inserting into SQLite: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// queryAggregates runs a query returning date, project_id, transaction_count and total_volume_usd columns.
+func (r *SQLiteRepository) queryAggregates(ctx context.Context, query string, args ...any) ([]models.AggregatedData, error) {
+	var metrics []models.AggregatedData
+	err := r.streamAggregates(ctx, query, func(data models.AggregatedData) error {
+		metrics = append(metrics, data)
+		return nil
+	}, args...)
+	return metrics, err
+}
+
+// streamAggregates runs a metrics query, calling fn with each row as it is read.
+func (r *SQLiteRepository) streamAggregates(ctx context.Context, query string, fn func(models.AggregatedData) error, args ...any) error {
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("error executing metrics query: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data models.AggregatedData
+		var date string
+		if err := rows.Scan(&date, &data.ProjectID, &data.TransactionCount, &data.TotalVolumeUSD); err != nil {
+			return fmt.Errorf("error scanning metrics row: %w", err)
+		}
+		if data.Date, err = time.Parse(sqliteDateFormat, date); err != nil {
+			return fmt.Errorf("error parsing metrics date: %w", err)
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating metrics rows: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,196 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/estensen/marketplace-pipeline/internal/models"
+)
+
+// DefaultTransactionBatchSize is the number of transactions sent to ClickHouse per insert.
+const DefaultTransactionBatchSize = 10000
+
+// LoadTransactions inserts transactions into the marketplace_transactions table in batches.
+// Items repeating a txnHash and tokenId already seen in the same load are skipped;
+// duplicates across loads are removed by the table engine.
+func (r *ClickHouseRepository) LoadTransactions(ctx context.Context, transactions []models.Transaction) error {
+	unique := dedupTransactions(transactions)
+
+	for start := 0; start < len(unique); start += r.TransactionBatchSize {
+		end := min(start+r.TransactionBatchSize, len(unique))
+		if err := r.sendTransactionBatch(ctx, unique[start:end]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sendTransactionBatch inserts a single batch of transactions.
+func (r *ClickHouseRepository) sendTransactionBatch(ctx context.Context, transactions []models.Transaction) error {
+	batch, err := r.Conn.PrepareBatch(ctx, `INSERT INTO marketplace_transactions (
+        ts, event, project_id, user_id, currency_symbol, chain_id, collection_address, currency_address,
+        token_id, txn_hash, marketplace_type, request_id, currency_value_decimal, currency_value_raw)`)
+	if err != nil {
+		return fmt.Errorf("error preparing ClickHouse batch: %w", err)
+	}
+
+	for _, txn := range transactions {
+		err := batch.Append(
+			txn.Timestamp,
+			txn.Event,
+			txn.ProjectID,
+			txn.UserID,
+			txn.Props.CurrencySymbol,
+			txn.Props.ChainID,
+			txn.Props.CollectionAddress,
+			txn.Props.CurrencyAddress,
+			txn.Props.TokenID,
+			txn.Props.TxnHash,
+			txn.Props.MarketplaceType,
+			txn.Props.RequestID,
+			txn.Nums.CurrencyValueDecimal,
+			txn.Nums.CurrencyValueRaw,
+		)
+		if err != nil {
+			return fmt.Errorf("error appending to ClickHouse batch: %w", err)
+		}
+	}
+
+	if err := r.sendBatch("marketplace_transactions", batch); err != nil {
+		return fmt.Errorf("error sending batch to ClickHouse: %w", err)
+	}
+
+	return nil
+}
+
+// dedupTransactions drops transactions whose item appeared earlier in the slice.
+// Transactions without a txnHash are always kept.
+func dedupTransactions(transactions []models.Transaction) []models.Transaction {
+	seen := make(map[string]struct{}, len(transactions))
+	unique := make([]models.Transaction, 0, len(transactions))
+	for _, txn := range transactions {
+		if key := transactionKey(txn); key != "" {
+			if _, exists := seen[key]; exists {
+				continue
+			}
+			seen[key] = struct{}{}
+		}
+		unique = append(unique, txn)
+	}
+	return unique
+}
+
+// transactionKey identifies a traded item. A single txnHash can cover several items,
+// so the tokenId is part of the key. It is empty for transactions without a txnHash.
+func transactionKey(txn models.Transaction) string {
+	if txn.Props.TxnHash == "" {
+		return ""
+	}
+	return txn.Props.TxnHash + "/" + txn.Props.TokenID
+}
+
+// TransactionFilter selects raw transactions with a timestamp in [From, To).
+// Empty ID lists match every value.
+type TransactionFilter struct {
+	From       time.Time
+	To         time.Time
+	ProjectIDs []string
+	ChainIDs   []string
+	Events     []string
+}
+
+// Matches reports whether txn is selected by the filter.
+func (f TransactionFilter) Matches(txn models.Transaction) bool {
+	return !txn.Timestamp.Before(f.From) && txn.Timestamp.Before(f.To) &&
+		matchesAny(f.ProjectIDs, txn.ProjectID) &&
+		matchesAny(f.ChainIDs, txn.Props.ChainID) &&
+		matchesAny(f.Events, txn.Event)
+}
+
+// matchesAny reports whether value is in values, treating an empty list as matching everything.
+func matchesAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// transactionRow is a flat marketplace_transactions row.
+type transactionRow struct {
+	Timestamp            time.Time `ch:"ts"`
+	Event                string    `ch:"event"`
+	ProjectID            string    `ch:"project_id"`
+	UserID               string    `ch:"user_id"`
+	CurrencySymbol       string    `ch:"currency_symbol"`
+	ChainID              string    `ch:"chain_id"`
+	CollectionAddress    string    `ch:"collection_address"`
+	CurrencyAddress      string    `ch:"currency_address"`
+	TokenID              string    `ch:"token_id"`
+	TxnHash              string    `ch:"txn_hash"`
+	MarketplaceType      string    `ch:"marketplace_type"`
+	RequestID            string    `ch:"request_id"`
+	CurrencyValueDecimal string    `ch:"currency_value_decimal"`
+	CurrencyValueRaw     string    `ch:"currency_value_raw"`
+}
+
+// FetchTransactions retrieves the raw transactions matching filter from ClickHouse, ordered by timestamp.
+func (r *ClickHouseRepository) FetchTransactions(ctx context.Context, filter TransactionFilter) ([]models.Transaction, error) {
+	query := `
+        SELECT ts, event, project_id, user_id, currency_symbol, chain_id, collection_address, currency_address,
+            token_id, txn_hash, marketplace_type, request_id, currency_value_decimal, currency_value_raw
+        FROM marketplace_transactions FINAL
+        WHERE ts >= ? AND ts < ?`
+	args := []any{filter.From, filter.To}
+	for _, in := range []struct {
+		column string
+		values []string
+	}{
+		{"project_id", filter.ProjectIDs},
+		{"chain_id", filter.ChainIDs},
+		{"event", filter.Events},
+	} {
+		if len(in.values) > 0 {
+			query += fmt.Sprintf(" AND %s IN ?", in.column)
+			args = append(args, in.values)
+		}
+	}
+	query += " ORDER BY ts"
+
+	var rows []transactionRow
+	if err := r.Conn.Select(ctx, &rows, query, args...); err != nil {
+		return nil, fmt.Errorf("error executing transactions query: %w", err)
+	}
+
+	transactions := make([]models.Transaction, 0, len(rows))
+	for _, row := range rows {
+		transactions = append(transactions, models.Transaction{
+			Timestamp: row.Timestamp.UTC(),
+			Event:     row.Event,
+			ProjectID: row.ProjectID,
+			UserID:    row.UserID,
+			Props: models.Props{
+				CurrencySymbol:    row.CurrencySymbol,
+				ChainID:           row.ChainID,
+				CollectionAddress: row.CollectionAddress,
+				CurrencyAddress:   row.CurrencyAddress,
+				TokenID:           row.TokenID,
+				TxnHash:           row.TxnHash,
+				MarketplaceType:   row.MarketplaceType,
+				RequestID:         row.RequestID,
+			},
+			Nums: models.Nums{
+				CurrencyValueDecimal: row.CurrencyValueDecimal,
+				CurrencyValueRaw:     row.CurrencyValueRaw,
+			},
+		})
+	}
+
+	return transactions, nil
+}
@@ -0,0 +1,26 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/estensen/marketplace-pipeline/internal/models"
+)
+
+func TestDedupTransactions(t *testing.T) {
+	transactions := []models.Transaction{
+		{Event: "BUY_ITEMS", Props: models.Props{TxnHash: "0x1", TokenID: "215"}},
+		{Event: "BUY_ITEMS", Props: models.Props{TxnHash: "0x2", TokenID: "215"}},
+		{Event: "BUY_ITEMS", Props: models.Props{TxnHash: "0x1", TokenID: "215"}},
+		{Event: "BUY_ITEMS", Props: models.Props{TxnHash: "0x1", TokenID: "602"}},
+		{Event: "BUY_ITEMS", Props: models.Props{TxnHash: ""}},
+		{Event: "SELL_ITEMS", Props: models.Props{TxnHash: ""}},
+	}
+
+	unique := dedupTransactions(transactions)
+
+	// Items sharing a txnHash are kept as long as their tokenId differs
+	expected := []models.Transaction{transactions[0], transactions[1], transactions[3], transactions[4], transactions[5]}
+	assert.Equal(t, expected, unique)
+}
@@ -6,6 +6,7 @@ type Transaction struct {
 	Timestamp time.Time
 	Event     string
 	ProjectID string
+	UserID    string
 	Props     Props
 	Nums      Nums
 }
@@ -15,10 +16,15 @@ type Props struct {
 	ChainID           string `json:"chainId"`
 	CollectionAddress string `json:"collectionAddress"`
 	CurrencyAddress   string `json:"currencyAddress"`
+	TokenID           string `json:"tokenId"`
+	TxnHash           string `json:"txnHash"`
+	MarketplaceType   string `json:"marketplaceType"`
+	RequestID         string `json:"requestId"`
 }
 
 type Nums struct {
 	CurrencyValueDecimal string `json:"currencyValueDecimal"`
+	CurrencyValueRaw     string `json:"currencyValueRaw"`
 }
 
 type AggregatedData struct {
@@ -27,3 +33,106 @@ type AggregatedData struct {
 	TransactionCount uint64    `ch:"transaction_count"`
 	TotalVolumeUSD   float64   `ch:"total_volume_usd"`
 }
+
+type LeaderboardEntry struct {
+	Rank             int
+	PreviousRank     int
+	RankChange       int
+	ID               string
+	TransactionCount uint64
+	TotalVolumeUSD   float64
+}
+
+type TimeSeriesPoint struct {
+	Timestamp time.Time
+	Value     float64
+}
+
+type ProjectSummary struct {
+	ProjectID string
+	Current   PeriodSummary
+	Previous  PeriodSummary
+	Change    SummaryChange
+}
+
+type PeriodSummary struct {
+	From             time.Time
+	To               time.Time
+	TransactionCount uint64
+	TotalVolumeUSD   float64
+	UniqueTraders    uint64
+	AverageTicketUSD float64
+	Currencies       []BreakdownEntry
+	Events           []BreakdownEntry
+	BreakdownsMatch  bool
+}
+
+type BreakdownEntry struct {
+	Key              string
+	TransactionCount uint64
+	TotalVolumeUSD   float64
+}
+
+type SummaryChange struct {
+	TransactionCount Change
+	TotalVolumeUSD   Change
+	UniqueTraders    Change
+	AverageTicketUSD Change
+}
+
+type Change struct {
+	Absolute float64
+	Percent  *float64
+}
+
+type NativeVolume struct {
+	Date              time.Time `ch:"date"`
+	ProjectID         string    `ch:"project_id"`
+	CurrencySymbol    string    `ch:"currency_symbol"`
+	Token             string    `ch:"token"`
+	TransactionCount  uint64    `ch:"transaction_count"`
+	TotalVolumeNative float64   `ch:"total_volume_native"`
+}
+
+type TokenPrice struct {
+	Token           string    `ch:"token"`
+	Date            time.Time `ch:"date"`
+	AveragePriceUSD float64   `ch:"average_price_usd"`
+	Source          string    `ch:"source"`
+	FetchedAt       time.Time `ch:"fetched_at"`
+}
+
+type RepriceAudit struct {
+	RepricedAt          time.Time `ch:"repriced_at"`
+	Token               string    `ch:"token"`
+	Date                time.Time `ch:"date"`
+	ProjectID           string    `ch:"project_id"`
+	OldTransactionCount uint64    `ch:"old_transaction_count"`
+	NewTransactionCount uint64    `ch:"new_transaction_count"`
+	OldVolumeUSD        float64   `ch:"old_volume_usd"`
+	NewVolumeUSD        float64   `ch:"new_volume_usd"`
+}
+
+type ProcessedInput struct {
+	URI         string    `ch:"uri"`
+	Size        uint64    `ch:"size"`
+	SHA256      string    `ch:"sha256"`
+	RowCount    uint64    `ch:"row_count"`
+	RunID       string    `ch:"run_id"`
+	ProcessedAt time.Time `ch:"processed_at"`
+}
+
+type PipelineRun struct {
+	RunID       string
+	URI         string
+	Result      string
+	CompletedAt time.Time
+}
+
+type APIKey struct {
+	Name          string   `ch:"name" json:"name"`
+	KeyHash       string   `ch:"key_hash" json:"key_sha256"`
+	ProjectIDs    []string `ch:"project_ids" json:"project_ids"`
+	RatePerSecond float64  `ch:"rate_per_second" json:"rate_per_second"`
+	Burst         uint32   `ch:"burst" json:"burst"`
+}
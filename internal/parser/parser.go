@@ -3,15 +3,21 @@ package parser
 import (
 	"encoding/csv"
 	"encoding/json"
+	"fmt"
+	"io"
 	"os"
 	"time"
 
 	"github.com/estensen/marketplace-pipeline/internal/models"
 )
 
+// recordFields is the number of fields a record needs, up to the JSON-encoded Nums.
+const recordFields = 16
+
 // Parser defines the interface for parsing CSV files.
 type Parser interface {
 	ParseCSV(filePath string) ([]models.Transaction, error)
+	Parse(r io.Reader) ([]models.Transaction, error)
 }
 
 // CSVParser implements the Parser interface for CSV files.
@@ -30,7 +36,12 @@ func (p *CSVParser) ParseCSV(filePath string) ([]models.Transaction, error) {
 	}
 	defer file.Close()
 
-	records, err := p.readCSV(file)
+	return p.Parse(file)
+}
+
+// Parse parses CSV content from r into a slice of transactions.
+func (p *CSVParser) Parse(r io.Reader) ([]models.Transaction, error) {
+	records, err := p.readCSV(r)
 	if err != nil {
 		return nil, err
 	}
@@ -38,9 +49,9 @@ func (p *CSVParser) ParseCSV(filePath string) ([]models.Transaction, error) {
 	return p.parseRecords(records)
 }
 
-// readCSV reads the CSV content from a file and returns the records.
-func (p *CSVParser) readCSV(file *os.File) ([][]string, error) {
-	reader := csv.NewReader(file)
+// readCSV reads the CSV content from a reader and returns the records.
+func (p *CSVParser) readCSV(r io.Reader) ([][]string, error) {
+	reader := csv.NewReader(r)
 	reader.FieldsPerRecord = -1 // Allows variable number of fields per record
 	return reader.ReadAll()
 }
@@ -66,6 +77,10 @@ func ParseRecord(record []string) (models.Transaction, error) {
 	var txn models.Transaction
 	var err error
 
+	if len(record) < recordFields {
+		return txn, fmt.Errorf("error parsing record: expected at least %d fields, got %d", recordFields, len(record))
+	}
+
 	txn.Timestamp, err = parseTimestamp(record[1])
 	if err != nil {
 		return txn, err
@@ -73,6 +88,7 @@ func ParseRecord(record []string) (models.Transaction, error) {
 
 	txn.Event = record[2]
 	txn.ProjectID = record[3]
+	txn.UserID = record[6]
 
 	err = parseProps(record[14], &txn.Props)
 	if err != nil {
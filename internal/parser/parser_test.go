@@ -24,8 +24,16 @@ func TestParseCSV(t *testing.T) {
 				{
 					Event:     "BUY_ITEMS",
 					ProjectID: "4974",
-					Props:     models.Props{CurrencySymbol: "SFL", ChainID: "137"},
-					Nums:      models.Nums{CurrencyValueDecimal: "0.6136203411678249"},
+					UserID:    "0896ae95dcaeee38e83fa5c43bef99780d7b2be23bcab36214",
+					Props: models.Props{
+						CurrencySymbol: "SFL",
+						ChainID:        "137",
+						TxnHash:        "0xd919290e80df271e77d1cbca61f350d2727531e0334266671ec20d626b2104a2",
+					},
+					Nums: models.Nums{
+						CurrencyValueDecimal: "0.6136203411678249",
+						CurrencyValueRaw:     "613620341167824900",
+					},
 				},
 			},
 			expectedError: false,
@@ -52,9 +60,12 @@ func TestParseCSV(t *testing.T) {
 			for i, expected := range tt.expectedTxn {
 				assert.Equal(t, expected.Event, transactions[i].Event)
 				assert.Equal(t, expected.ProjectID, transactions[i].ProjectID)
+				assert.Equal(t, expected.UserID, transactions[i].UserID)
 				assert.Equal(t, expected.Props.CurrencySymbol, transactions[i].Props.CurrencySymbol)
 				assert.Equal(t, expected.Props.ChainID, transactions[i].Props.ChainID)
+				assert.Equal(t, expected.Props.TxnHash, transactions[i].Props.TxnHash)
 				assert.Equal(t, expected.Nums.CurrencyValueDecimal, transactions[i].Nums.CurrencyValueDecimal)
+				assert.Equal(t, expected.Nums.CurrencyValueRaw, transactions[i].Nums.CurrencyValueRaw)
 			}
 		})
 	}
@@ -90,6 +101,7 @@ func TestParseRecord(t *testing.T) {
 			expectedTxn: models.Transaction{
 				Event:     "BUY_ITEMS",
 				ProjectID: "4974",
+				UserID:    "0896ae95dcaeee38e83fa5c43bef99780d7b2be23bcab36214",
 				Props:     models.Props{CurrencySymbol: "SFL", ChainID: "137"},
 				Nums:      models.Nums{CurrencyValueDecimal: "0.6136203411678249"},
 			},
@@ -118,6 +130,17 @@ func TestParseRecord(t *testing.T) {
 			expectedTxn:   models.Transaction{},
 			expectedError: true,
 		},
+		{
+			name: "Short record",
+			record: []string{
+				"seq-market",
+				"2024-04-15 02:15:07.167",
+				"BUY_ITEMS",
+				"4974",
+			},
+			expectedTxn:   models.Transaction{},
+			expectedError: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -131,6 +154,7 @@ func TestParseRecord(t *testing.T) {
 			require.NoError(t, err)
 			assert.Equal(t, tt.expectedTxn.Event, txn.Event)
 			assert.Equal(t, tt.expectedTxn.ProjectID, txn.ProjectID)
+			assert.Equal(t, tt.expectedTxn.UserID, txn.UserID)
 			assert.Equal(t, tt.expectedTxn.Props.CurrencySymbol, txn.Props.CurrencySymbol)
 			assert.Equal(t, tt.expectedTxn.Props.ChainID, txn.Props.ChainID)
 			assert.Equal(t, tt.expectedTxn.Nums.CurrencyValueDecimal, txn.Nums.CurrencyValueDecimal)
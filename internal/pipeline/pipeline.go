@@ -0,0 +1,528 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/estensen/marketplace-pipeline/internal/aggregator"
+	"github.com/estensen/marketplace-pipeline/internal/archive"
+	"github.com/estensen/marketplace-pipeline/internal/database"
+	"github.com/estensen/marketplace-pipeline/internal/models"
+	"github.com/estensen/marketplace-pipeline/internal/parser"
+	"github.com/estensen/marketplace-pipeline/internal/price"
+	"github.com/estensen/marketplace-pipeline/internal/source"
+	"github.com/estensen/marketplace-pipeline/internal/telemetry"
+	"github.com/estensen/marketplace-pipeline/internal/utils"
+)
+
+// Predefined errors returned by Run.
+var (
+	ErrNoValidCoinIDs = errors.New("no valid CoinGecko IDs found")
+	ErrNoNewInputs    = errors.New("all inputs were already processed")
+)
+
+// Pipeline stages whose durations are recorded.
+const (
+	StageParse     = "parse"
+	StagePrices    = "prices"
+	StageAggregate = "aggregate"
+	StageLoad      = "load"
+	StageArchive   = "archive"
+)
+
+// Pipeline parses transactions, prices them and loads the aggregates into the repositories.
+type Pipeline struct {
+	Source     source.Source
+	Parser     parser.Parser
+	CoinAPI    price.CoinAPI
+	Aggregator *aggregator.Aggregator
+	Metrics    database.MetricsRepository
+	Prices     database.PriceRepository
+	Ledger     database.InputLedger
+	Archiver   *archive.Archiver
+	// Reprocess processes inputs even when the ledger shows identical content was already processed.
+	Reprocess bool
+	// Loaded, when set, is called with the first and last day of the loaded analytics once a run completes.
+	Loaded func(from, to time.Time)
+
+	mu      sync.Mutex
+	lastRun *models.PipelineRun
+}
+
+// NewPipeline creates a new Pipeline.
+func NewPipeline(src source.Source, p parser.Parser, coinAPI price.CoinAPI, agg *aggregator.Aggregator, metrics database.MetricsRepository, prices database.PriceRepository, ledger database.InputLedger, archiver *archive.Archiver) *Pipeline {
+	return &Pipeline{
+		Source:     src,
+		Parser:     p,
+		CoinAPI:    coinAPI,
+		Aggregator: agg,
+		Metrics:    metrics,
+		Prices:     prices,
+		Ledger:     ledger,
+		Archiver:   archiver,
+	}
+}
+
+// Run processes the transactions in every input matched by uri, pricing each day of transactions with the token
+// prices of that day.
+func (p *Pipeline) Run(ctx context.Context, uri string) (err error) {
+	runID := uuid.NewString()
+	var parsed, rejected, aggregated int
+	defer func() {
+		telemetry.RecordRun(runResult(err), parsed, rejected, aggregated)
+		if err == nil || errors.Is(err, ErrNoNewInputs) {
+			p.recordRun(models.PipelineRun{RunID: runID, URI: uri, Result: runResult(err), CompletedAt: time.Now().UTC()})
+		}
+	}()
+
+	// Parse the inputs to get the transactions, skipping inputs that were already processed
+	start := time.Now()
+	transactions, inputs, replaced, err := p.readTransactions(ctx, uri)
+	telemetry.ObserveStage(StageParse, start)
+	if err != nil {
+		return err
+	}
+
+	if len(inputs) == 0 {
+		return ErrNoNewInputs
+	}
+	parsed = len(transactions)
+
+	// Fetch coin list
+	start = time.Now()
+	symbolToCoinID, err := p.CoinAPI.FetchCoinsList()
+	if err != nil {
+		return fmt.Errorf("error fetching coin list: %w", err)
+	}
+
+	// Map tokens to CoinGecko IDs, warning about tokens missing from the coin list
+	for _, tokenSymbol := range utils.ExtractUniqueTokens(transactions) {
+		if _, found := symbolToCoinID[normalizeToken(tokenSymbol)]; !found {
+			log.Printf("No CoinGecko ID found for token: %s", tokenSymbol)
+		}
+	}
+	if len(coinIDs(transactions, symbolToCoinID)) == 0 {
+		return ErrNoValidCoinIDs
+	}
+
+	// Map CoinGecko IDs back to symbols
+	coinIDToSymbol := utils.InvertMap(symbolToCoinID)
+
+	// Fetch the prices of each day's tokens for that day
+	days, byDay := groupByDay(transactions)
+	dayPrices := make(map[time.Time]map[string]float64)
+	for _, day := range days {
+		ids := coinIDs(byDay[day], symbolToCoinID)
+		if len(ids) == 0 {
+			continue
+		}
+		if dayPrices[day], err = p.fetchPrices(ctx, ids, coinIDToSymbol, day); err != nil {
+			return err
+		}
+	}
+	telemetry.ObserveStage(StagePrices, start)
+
+	// Aggregate data
+	start = time.Now()
+	var aggregatedData []models.AggregatedData
+	for _, day := range days {
+		data, err := p.Aggregator.Aggregate(byDay[day], dayPrices[day])
+		if err != nil {
+			return fmt.Errorf("error aggregating data: %w", err)
+		}
+		aggregatedData = append(aggregatedData, data...)
+	}
+
+	// Aggregate native currency volumes so USD volumes can be recomputed from token prices
+	nativeVolumes, err := p.Aggregator.AggregateNative(transactions, symbolToCoinID)
+	if err != nil {
+		return fmt.Errorf("error aggregating native volumes: %w", err)
+	}
+	telemetry.ObserveStage(StageAggregate, start)
+
+	// Rows are rejected by the aggregator when they can't be parsed or priced
+	for _, data := range aggregatedData {
+		aggregated += int(data.TransactionCount)
+	}
+	rejected = parsed - aggregated
+
+	// Load aggregated data
+	start = time.Now()
+	if err := p.loadAggregates(ctx, aggregatedData, nativeVolumes, replaced); err != nil {
+		return err
+	}
+
+	// Load raw transactions
+	if err := p.Metrics.LoadTransactions(ctx, transactions); err != nil {
+		return fmt.Errorf("error loading transactions: %w", err)
+	}
+	telemetry.ObserveStage(StageLoad, start)
+
+	// Archive the analytics snapshot
+	start = time.Now()
+	if err := p.Archiver.ArchiveAnalytics(aggregatedData); err != nil {
+		return fmt.Errorf("error archiving analytics: %w", err)
+	}
+	telemetry.ObserveStage(StageArchive, start)
+
+	// Record the inputs only once their transactions are loaded, so failed runs are retried
+	for i := range inputs {
+		inputs[i].RunID = runID
+	}
+	if err := p.Ledger.RecordProcessedInputs(ctx, inputs); err != nil {
+		return fmt.Errorf("error recording processed inputs: %w", err)
+	}
+	log.Printf("Recorded %d processed inputs for run %s", len(inputs), runID)
+
+	if p.Loaded != nil && len(aggregatedData) > 0 {
+		from, to := aggregatedData[0].Date, aggregatedData[0].Date
+		for _, data := range aggregatedData[1:] {
+			if data.Date.Before(from) {
+				from = data.Date
+			}
+			if data.Date.After(to) {
+				to = data.Date
+			}
+		}
+		p.Loaded(from, to)
+	}
+
+	return nil
+}
+
+// LastRun returns the last run that completed, including runs that skipped every input.
+func (p *Pipeline) LastRun() (models.PipelineRun, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.lastRun == nil {
+		return models.PipelineRun{}, false
+	}
+	return *p.lastRun, true
+}
+
+// recordRun records run as the last completed run.
+func (p *Pipeline) recordRun(run models.PipelineRun) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastRun = &run
+}
+
+// fetchPrices stores the prices of coinIDs for date, unless they were already stored, and returns the stored
+// prices keyed by token symbol.
+func (p *Pipeline) fetchPrices(ctx context.Context, coinIDs []string, coinIDToSymbol map[string]string, date time.Time) (map[string]float64, error) {
+	// Run batch job to fetch and store token prices
+	batchJob := database.NewBatchJob(p.CoinAPI, p.Prices, p.Archiver)
+	if err := batchJob.RunDailyBatchJob(ctx, coinIDs, date); err != nil {
+		log.Printf("Error running daily batch job for %s: %v", date.Format("2006-01-02"), err)
+	} else {
+		log.Printf("Daily batch job for %s completed successfully.", date.Format("2006-01-02"))
+	}
+
+	// Fetch stored prices
+	prices, err := p.Prices.FetchPrices(ctx, coinIDs, date)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching prices: %w", err)
+	}
+
+	// Map prices to symbols
+	symbolPrices := make(map[string]float64)
+	for coinID, priceUSD := range prices {
+		symbolPrices[coinIDToSymbol[coinID]] = priceUSD
+	}
+	return symbolPrices, nil
+}
+
+// loadAggregates loads the analytics rows and native volumes of a run. The rows of the days and projects in replaced
+// take the place of the stored ones, so inputs processed again aren't counted twice.
+func (p *Pipeline) loadAggregates(ctx context.Context, data []models.AggregatedData, volumes []models.NativeVolume, replaced []models.AggregatedData) error {
+	keys := make(map[string]struct{}, len(replaced))
+	for _, key := range replaced {
+		keys[rowKey(key.Date, key.ProjectID)] = struct{}{}
+	}
+	isReplaced := func(date time.Time, projectID string) bool {
+		_, found := keys[rowKey(date, projectID)]
+		return found
+	}
+
+	var newData, replacedData []models.AggregatedData
+	for _, row := range data {
+		if isReplaced(row.Date, row.ProjectID) {
+			replacedData = append(replacedData, row)
+		} else {
+			newData = append(newData, row)
+		}
+	}
+	var newVolumes, replacedVolumes []models.NativeVolume
+	for _, row := range volumes {
+		if isReplaced(row.Date, row.ProjectID) {
+			replacedVolumes = append(replacedVolumes, row)
+		} else {
+			newVolumes = append(newVolumes, row)
+		}
+	}
+
+	if err := p.Metrics.LoadAggregates(ctx, newData); err != nil {
+		return fmt.Errorf("error loading aggregated data: %w", err)
+	}
+
+	if err := p.Metrics.LoadNativeVolumes(ctx, newVolumes); err != nil {
+		return fmt.Errorf("error loading native volumes: %w", err)
+	}
+
+	if len(replaced) == 0 {
+		return nil
+	}
+	log.Printf("Replacing the analytics of %d days and projects processed before", len(replaced))
+
+	if err := p.Metrics.ReplaceAggregates(ctx, replaced, replacedData); err != nil {
+		return fmt.Errorf("error replacing aggregated data: %w", err)
+	}
+
+	if err := p.Metrics.ReplaceNativeVolumes(ctx, replaced, replacedVolumes); err != nil {
+		return fmt.Errorf("error replacing native volumes: %w", err)
+	}
+
+	return nil
+}
+
+// rowKeys returns the days and projects transactions fall on, in order.
+func rowKeys(transactions []models.Transaction) []models.AggregatedData {
+	seen := make(map[string]struct{})
+	var keys []models.AggregatedData
+	for _, txn := range transactions {
+		day := txn.Timestamp.UTC().Truncate(24 * time.Hour)
+		if _, exists := seen[rowKey(day, txn.ProjectID)]; exists {
+			continue
+		}
+		seen[rowKey(day, txn.ProjectID)] = struct{}{}
+		keys = append(keys, models.AggregatedData{Date: day, ProjectID: txn.ProjectID})
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].Date.Equal(keys[j].Date) {
+			return keys[i].Date.Before(keys[j].Date)
+		}
+		return keys[i].ProjectID < keys[j].ProjectID
+	})
+	return keys
+}
+
+// rowKey identifies the analytics row of a day and project.
+func rowKey(date time.Time, projectID string) string {
+	return date.Format("2006-01-02") + projectID
+}
+
+// coinIDs returns the CoinGecko IDs of the tokens traded in transactions.
+func coinIDs(transactions []models.Transaction, symbolToCoinID map[string]string) []string {
+	ids := []string{}
+	for _, tokenSymbol := range utils.ExtractUniqueTokens(transactions) {
+		if coinID, found := symbolToCoinID[normalizeToken(tokenSymbol)]; found {
+			ids = append(ids, coinID)
+		}
+	}
+	return ids
+}
+
+// normalizeToken returns the symbol a token is listed under in the coin list.
+func normalizeToken(tokenSymbol string) string {
+	return utils.NormalizeTokenSymbol(strings.ToUpper(tokenSymbol))
+}
+
+// groupByDay groups transactions by their UTC day, returning the days in order.
+func groupByDay(transactions []models.Transaction) ([]time.Time, map[time.Time][]models.Transaction) {
+	byDay := make(map[time.Time][]models.Transaction)
+	var days []time.Time
+	for _, txn := range transactions {
+		day := txn.Timestamp.UTC().Truncate(24 * time.Hour)
+		if _, exists := byDay[day]; !exists {
+			days = append(days, day)
+		}
+		byDay[day] = append(byDay[day], txn)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return days, byDay
+}
+
+// runResult returns the metrics label of a run ending with err.
+func runResult(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, ErrNoNewInputs):
+		return "skipped"
+	default:
+		return "failure"
+	}
+}
+
+// readTransactions parses every new input matched by uri into a single slice of transactions,
+// archiving each one as a raw part of every day it has transactions for. It returns the ledger entries of the parsed
+// inputs, and the days and projects of the inputs processed before, whose stored rows the run replaces.
+func (p *Pipeline) readTransactions(ctx context.Context, uri string) ([]models.Transaction, []models.ProcessedInput, []models.AggregatedData, error) {
+	uris, err := p.Source.Resolve(uri)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error resolving input: %w", err)
+	}
+
+	var transactions, reprocessed []models.Transaction
+	var inputs []models.ProcessedInput
+	for part, inputURI := range uris {
+		parsed, input, seen, err := p.parseInput(ctx, inputURI, part)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if input == nil {
+			continue
+		}
+		log.Printf("Parsed %d transactions from %s", len(parsed), inputURI)
+		transactions = append(transactions, parsed...)
+		inputs = append(inputs, *input)
+		if seen {
+			reprocessed = append(reprocessed, parsed...)
+		}
+	}
+
+	return transactions, inputs, rowKeys(reprocessed), nil
+}
+
+// parseInput opens a single input, parses it and archives its content as the given raw part.
+// It returns a nil ledger entry when identical content was already processed, and reports whether the input
+// was processed before, with the same or other content.
+func (p *Pipeline) parseInput(ctx context.Context, uri string, part int) ([]models.Transaction, *models.ProcessedInput, bool, error) {
+	reader, err := p.Source.Open(uri)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("error opening input: %w", err)
+	}
+	defer reader.Close()
+
+	// Read the whole input once, so it can be checksummed, parsed and archived even from stdin
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("error reading input %s: %w", uri, err)
+	}
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+
+	skip, seen, err := p.checkLedger(ctx, uri, checksum)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if skip {
+		return nil, nil, false, nil
+	}
+
+	transactions, err := p.Parser.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("error parsing CSV %s: %w", uri, err)
+	}
+
+	if err := p.archiveRaw(part, data, transactions); err != nil {
+		return nil, nil, false, fmt.Errorf("error archiving input %s: %w", uri, err)
+	}
+
+	return transactions, &models.ProcessedInput{
+		URI:         uri,
+		Size:        uint64(len(data)),
+		SHA256:      checksum,
+		RowCount:    uint64(len(transactions)),
+		ProcessedAt: time.Now().UTC(),
+	}, seen, nil
+}
+
+// archiveRaw archives the CSV input data as the given raw part of each day it has transactions for, each part
+// holding the header and the rows of its day. The parser yields one transaction per row after the header.
+func (p *Pipeline) archiveRaw(part int, data []byte, transactions []models.Transaction) error {
+	if len(transactions) == 0 {
+		return nil
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return fmt.Errorf("error reading CSV: %w", err)
+	}
+	if len(records) != len(transactions)+1 {
+		return fmt.Errorf("error splitting CSV by day: %d rows for %d transactions", len(records)-1, len(transactions))
+	}
+
+	rows := make(map[time.Time][]int)
+	var days []time.Time
+	for i, txn := range transactions {
+		day := txn.Timestamp.UTC().Truncate(24 * time.Hour)
+		if _, exists := rows[day]; !exists {
+			days = append(days, day)
+		}
+		rows[day] = append(rows[day], i)
+	}
+
+	for _, day := range days {
+		var buf bytes.Buffer
+		writer := csv.NewWriter(&buf)
+		dayTransactions := make([]models.Transaction, 0, len(rows[day]))
+		if err := writer.Write(records[0]); err != nil {
+			return fmt.Errorf("error writing CSV: %w", err)
+		}
+		for _, i := range rows[day] {
+			if err := writer.Write(records[i+1]); err != nil {
+				return fmt.Errorf("error writing CSV: %w", err)
+			}
+			dayTransactions = append(dayTransactions, transactions[i])
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return fmt.Errorf("error writing CSV: %w", err)
+		}
+
+		if err := p.Archiver.ArchiveRaw(day, part, &buf, dayTransactions); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkLedger reports whether an input should be skipped because identical content was already processed, and
+// whether it was processed before, either with identical content or under the same URI with different content.
+// It warns when an input with the same URI was processed with different content.
+func (p *Pipeline) checkLedger(ctx context.Context, uri, checksum string) (skip, seen bool, err error) {
+	processed, found, err := p.Ledger.FindProcessedInput(ctx, checksum)
+	if err != nil {
+		return false, false, fmt.Errorf("error checking input ledger: %w", err)
+	}
+	if found {
+		if !p.Reprocess {
+			log.Printf("Skipping %s: identical to %s processed in run %s", uri, processed.URI, processed.RunID)
+			return true, true, nil
+		}
+		log.Printf("Reprocessing %s, already processed in run %s", uri, processed.RunID)
+		return false, true, nil
+	}
+
+	// Stdin has no stable name to compare against
+	if uri == source.StdinURI {
+		return false, false, nil
+	}
+
+	previous, found, err := p.Ledger.LatestProcessedInput(ctx, uri)
+	if err != nil {
+		return false, false, fmt.Errorf("error checking input ledger: %w", err)
+	}
+	if found {
+		log.Printf("Warning: %s changed since run %s (sha256 %s, now %s)", uri, previous.RunID, previous.SHA256, checksum)
+	}
+
+	return false, found, nil
+}
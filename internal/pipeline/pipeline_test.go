@@ -0,0 +1,326 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/estensen/marketplace-pipeline/internal/aggregator"
+	"github.com/estensen/marketplace-pipeline/internal/archive"
+	"github.com/estensen/marketplace-pipeline/internal/database"
+	"github.com/estensen/marketplace-pipeline/internal/models"
+	"github.com/estensen/marketplace-pipeline/internal/parser"
+	"github.com/estensen/marketplace-pipeline/internal/source"
+	"github.com/estensen/marketplace-pipeline/internal/storage"
+)
+
+type mockCoinAPI struct {
+	coins  map[string]string
+	prices map[string]float64
+	// daily overrides prices on the days it lists
+	daily map[string]map[string]float64
+}
+
+func (m *mockCoinAPI) GetHistoricalPrice(coinID string, date time.Time) (float64, error) {
+	if prices, found := m.daily[date.Format("2006-01-02")]; found {
+		return prices[coinID], nil
+	}
+	return m.prices[coinID], nil
+}
+
+func (m *mockCoinAPI) GetHistoricalPrices(coinIDs []string, date time.Time) (map[string]float64, error) {
+	prices := make(map[string]float64)
+	for _, coinID := range coinIDs {
+		prices[coinID], _ = m.GetHistoricalPrice(coinID, date)
+	}
+	return prices, nil
+}
+
+func (m *mockCoinAPI) FetchCoinsList() (map[string]string, error) {
+	return m.coins, nil
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	date := time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)
+
+	coinAPI := &mockCoinAPI{
+		coins: map[string]string{
+			"SFL":   "sunflower-land",
+			"MATIC": "matic-network",
+			"USDC":  "usd-coin",
+		},
+		prices: map[string]float64{
+			"sunflower-land": 0.05,
+			"matic-network":  0.9,
+			"usd-coin":       1,
+		},
+	}
+	objectStorage, err := storage.NewLocalFSStorage(t.TempDir())
+	require.NoError(t, err)
+	repo := database.NewMemoryRepository()
+
+	p := NewPipeline(source.NewMux(), parser.NewCSVParser(), coinAPI, aggregator.NewAggregator(), repo, repo, repo, archive.NewArchiver(objectStorage))
+	var loaded [][2]time.Time
+	p.Loaded = func(from, to time.Time) { loaded = append(loaded, [2]time.Time{from, to}) }
+	require.NoError(t, p.Run(ctx, "../../data/sample.csv"))
+
+	// The loaded days are reported once the run completes
+	assert.Equal(t, [][2]time.Time{{time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 4, 16, 0, 0, 0, 0, time.UTC)}}, loaded)
+
+	// Prices are stored in the repository and archived in object storage
+	prices, err := repo.FetchPrices(ctx, []string{"sunflower-land", "matic-network", "usd-coin"}, date)
+	require.NoError(t, err)
+	assert.Equal(t, coinAPI.prices, prices)
+	_, err = objectStorage.Stat("prices/date=2024-04-02/part-0.csv.gz")
+	assert.NoError(t, err)
+
+	// The raw input is archived split by day, and a daily analytics snapshot is archived, with manifests
+	archiver := archive.NewArchiver(objectStorage)
+	rawManifest, err := archiver.ReadManifest(archive.ObjectName(archive.DatasetRaw, date, 0, archive.FormatCSV))
+	require.NoError(t, err)
+	assert.Equal(t, 210, rawManifest.Rows)
+	var rawRows int
+	for _, day := range []int{1, 2, 15, 16} {
+		manifest, err := archiver.ReadManifest(archive.ObjectName(archive.DatasetRaw, time.Date(2024, 4, day, 0, 0, 0, 0, time.UTC), 0, archive.FormatCSV))
+		require.NoError(t, err)
+		rawRows += manifest.Rows
+	}
+	assert.Equal(t, 1000, rawRows)
+	analytics, err := objectStorage.List(archive.DatasetAnalytics + "/")
+	require.NoError(t, err)
+	assert.Len(t, analytics, 8)
+
+	// Every transaction in the sample is priced, so the monthly rollup covers all of them
+	month := database.GranularityMonth.PeriodStart(date)
+	rollup, err := repo.FetchRollup(ctx, database.GranularityMonth, month, month, nil)
+	require.NoError(t, err)
+	var count uint64
+	for _, data := range rollup {
+		count += data.TransactionCount
+	}
+	assert.Equal(t, uint64(1000), count)
+
+	nativeVolumes, err := repo.FetchNativeVolumes(ctx, date.AddDate(0, 0, -1), date.AddDate(0, 0, 14))
+	require.NoError(t, err)
+	assert.NotEmpty(t, nativeVolumes)
+	assert.Len(t, repo.Transactions(), 1000)
+}
+
+func TestRunPricesEachDay(t *testing.T) {
+	ctx := context.Background()
+	apr1 := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	apr2 := time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)
+	coinAPI := &mockCoinAPI{
+		coins: map[string]string{"MATIC": "matic-network"},
+		daily: map[string]map[string]float64{
+			"2024-04-01": {"matic-network": 1},
+			"2024-04-02": {"matic-network": 3},
+		},
+	}
+	objectStorage, err := storage.NewLocalFSStorage(t.TempDir())
+	require.NoError(t, err)
+	repo := database.NewMemoryRepository()
+
+	// The same sale on two days of a single input
+	input := filepath.Join(t.TempDir(), "2024-04.csv")
+	header := `"app","ts","event","project_id","source","ident","user_id","session_id","country","device_type","device_os","device_os_ver","device_browser","device_browser_ver","props","nums"`
+	row := func(ts, txnHash string) string {
+		return fmt.Sprintf(`"seq-market","%s","BUY_ITEMS","4974","","1","user","session","DE","desktop","linux","x86_64","chrome","122.0.0.0","{""currencySymbol"":""MATIC"",""txnHash"":""%s""}","{""currencyValueDecimal"":""2000000000000000000""}"`, ts, txnHash)
+	}
+	content := strings.Join([]string{header, row("2024-04-01 10:00:00.000", "0x1"), row("2024-04-02 10:00:00.000", "0x2")}, "\n") + "\n"
+	require.NoError(t, os.WriteFile(input, []byte(content), 0o644))
+
+	p := NewPipeline(source.NewMux(), parser.NewCSVParser(), coinAPI, aggregator.NewAggregator(), repo, repo, repo, archive.NewArchiver(objectStorage))
+	require.NoError(t, p.Run(ctx, input))
+
+	// Each day is priced with its own prices
+	metrics, err := repo.FetchMetricsRange(ctx, apr1, apr2)
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+	assert.Equal(t, apr1, metrics[0].Date)
+	assert.InDelta(t, 2.0, metrics[0].TotalVolumeUSD, 1e-9)
+	assert.Equal(t, apr2, metrics[1].Date)
+	assert.InDelta(t, 6.0, metrics[1].TotalVolumeUSD, 1e-9)
+
+	// And archived in its own raw partition
+	archiver := archive.NewArchiver(objectStorage)
+	for _, day := range []time.Time{apr1, apr2} {
+		manifest, err := archiver.ReadManifest(archive.ObjectName(archive.DatasetRaw, day, 0, archive.FormatCSV))
+		require.NoError(t, err)
+		assert.Equal(t, 1, manifest.Rows)
+
+		raw, err := archiver.Open(archive.DatasetRaw, day, 0)
+		require.NoError(t, err)
+		transactions, err := parser.NewCSVParser().Parse(raw)
+		require.NoError(t, err)
+		require.Len(t, transactions, 1)
+		assert.Equal(t, day, transactions[0].Timestamp.Truncate(24*time.Hour))
+	}
+}
+
+func TestRunWithoutCoinIDs(t *testing.T) {
+	coinAPI := &mockCoinAPI{coins: map[string]string{"BTC": "bitcoin"}}
+	repo := database.NewMemoryRepository()
+	objectStorage, err := storage.NewLocalFSStorage(t.TempDir())
+	require.NoError(t, err)
+
+	p := NewPipeline(source.NewMux(), parser.NewCSVParser(), coinAPI, aggregator.NewAggregator(), repo, repo, repo, archive.NewArchiver(objectStorage))
+	err = p.Run(context.Background(), "../../data/sample.csv")
+	assert.ErrorIs(t, err, ErrNoValidCoinIDs)
+}
+
+func TestRunSkipsProcessedInputs(t *testing.T) {
+	ctx := context.Background()
+	coinAPI := &mockCoinAPI{
+		coins:  map[string]string{"SFL": "sunflower-land", "MATIC": "matic-network", "USDC": "usd-coin"},
+		prices: map[string]float64{"sunflower-land": 0.05, "matic-network": 0.9, "usd-coin": 1},
+	}
+	repo := database.NewMemoryRepository()
+	objectStorage, err := storage.NewLocalFSStorage(t.TempDir())
+	require.NoError(t, err)
+
+	p := NewPipeline(source.NewMux(), parser.NewCSVParser(), coinAPI, aggregator.NewAggregator(), repo, repo, repo, archive.NewArchiver(objectStorage))
+	_, found := p.LastRun()
+	assert.False(t, found)
+	require.NoError(t, p.Run(ctx, "../../data/sample.csv"))
+
+	input, found, err := repo.LatestProcessedInput(ctx, "../../data/sample.csv")
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, uint64(1000), input.RowCount)
+	assert.Len(t, input.SHA256, 64)
+	assert.NotEmpty(t, input.RunID)
+
+	run, found := p.LastRun()
+	require.True(t, found)
+	assert.Equal(t, input.RunID, run.RunID)
+	assert.Equal(t, "success", run.Result)
+
+	// The same export is skipped on the next run, which still completes
+	err = p.Run(ctx, "../../data/sample.csv")
+	assert.ErrorIs(t, err, ErrNoNewInputs)
+	skipped, found := p.LastRun()
+	require.True(t, found)
+	assert.Equal(t, "skipped", skipped.Result)
+	assert.NotEqual(t, run.RunID, skipped.RunID)
+	assert.False(t, skipped.CompletedAt.Before(run.CompletedAt))
+
+	// Unless reprocessing is forced
+	p.Reprocess = true
+	require.NoError(t, p.Run(ctx, "../../data/sample.csv"))
+
+	reprocessed, found, err := repo.LatestProcessedInput(ctx, "../../data/sample.csv")
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, input.SHA256, reprocessed.SHA256)
+	assert.NotEqual(t, input.RunID, reprocessed.RunID)
+}
+
+func TestRunReprocessReplacesAnalytics(t *testing.T) {
+	ctx := context.Background()
+	apr1 := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	apr16 := time.Date(2024, 4, 16, 0, 0, 0, 0, time.UTC)
+	coinAPI := &mockCoinAPI{
+		coins:  map[string]string{"SFL": "sunflower-land", "MATIC": "matic-network", "USDC": "usd-coin"},
+		prices: map[string]float64{"sunflower-land": 0.05, "matic-network": 0.9, "usd-coin": 1},
+	}
+	repo := database.NewMemoryRepository()
+	objectStorage, err := storage.NewLocalFSStorage(t.TempDir())
+	require.NoError(t, err)
+
+	p := NewPipeline(source.NewMux(), parser.NewCSVParser(), coinAPI, aggregator.NewAggregator(), repo, repo, repo, archive.NewArchiver(objectStorage))
+	p.Reprocess = true
+
+	totals := func() (uint64, float64, uint64, float64) {
+		metrics, err := repo.FetchMetricsRange(ctx, apr1, apr16)
+		require.NoError(t, err)
+		var count uint64
+		var volumeUSD float64
+		for _, data := range metrics {
+			count += data.TransactionCount
+			volumeUSD += data.TotalVolumeUSD
+		}
+
+		volumes, err := repo.FetchNativeVolumes(ctx, apr1, apr16)
+		require.NoError(t, err)
+		var nativeCount uint64
+		var volumeNative float64
+		for _, volume := range volumes {
+			nativeCount += volume.TransactionCount
+			volumeNative += volume.TotalVolumeNative
+		}
+		return count, volumeUSD, nativeCount, volumeNative
+	}
+
+	require.NoError(t, p.Run(ctx, "../../data/sample.csv"))
+	count, volumeUSD, nativeCount, volumeNative := totals()
+	assert.Equal(t, uint64(1000), count)
+	assert.Equal(t, uint64(1000), nativeCount)
+
+	// Reprocessing the same input replaces its rows instead of adding to them
+	require.NoError(t, p.Run(ctx, "../../data/sample.csv"))
+	reprocessedCount, reprocessedVolumeUSD, reprocessedNativeCount, reprocessedVolumeNative := totals()
+	assert.Equal(t, count, reprocessedCount)
+	assert.InDelta(t, volumeUSD, reprocessedVolumeUSD, 1e-6)
+	assert.Equal(t, nativeCount, reprocessedNativeCount)
+	assert.InDelta(t, volumeNative, reprocessedVolumeNative, 1e-6)
+
+	month := database.GranularityMonth.PeriodStart(apr1)
+	rollup, err := repo.FetchRollup(ctx, database.GranularityMonth, month, month, nil)
+	require.NoError(t, err)
+	var rollupCount uint64
+	for _, data := range rollup {
+		rollupCount += data.TransactionCount
+	}
+	assert.Equal(t, count, rollupCount)
+}
+
+func TestRunChangedInputReplacesAnalytics(t *testing.T) {
+	ctx := context.Background()
+	apr1 := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	apr2 := time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)
+	coinAPI := &mockCoinAPI{
+		coins:  map[string]string{"MATIC": "matic-network"},
+		prices: map[string]float64{"matic-network": 1},
+	}
+	repo := database.NewMemoryRepository()
+	objectStorage, err := storage.NewLocalFSStorage(t.TempDir())
+	require.NoError(t, err)
+
+	input := filepath.Join(t.TempDir(), "2024-04.csv")
+	header := `"app","ts","event","project_id","source","ident","user_id","session_id","country","device_type","device_os","device_os_ver","device_browser","device_browser_ver","props","nums"`
+	row := func(ts, projectID, txnHash string) string {
+		return fmt.Sprintf(`"seq-market","%s","BUY_ITEMS","%s","","1","user","session","DE","desktop","linux","x86_64","chrome","122.0.0.0","{""currencySymbol"":""MATIC"",""txnHash"":""%s""}","{""currencyValueDecimal"":""1000000000000000000""}"`, ts, projectID, txnHash)
+	}
+	p := NewPipeline(source.NewMux(), parser.NewCSVParser(), coinAPI, aggregator.NewAggregator(), repo, repo, repo, archive.NewArchiver(objectStorage))
+
+	// Another input loads a row of its own for a different project
+	other := filepath.Join(t.TempDir(), "other.csv")
+	require.NoError(t, os.WriteFile(other, []byte(strings.Join([]string{header, row("2024-04-01 09:00:00.000", "1609", "0x9")}, "\n")+"\n"), 0o644))
+	require.NoError(t, p.Run(ctx, other))
+
+	require.NoError(t, os.WriteFile(input, []byte(strings.Join([]string{header, row("2024-04-01 10:00:00.000", "4974", "0x1")}, "\n")+"\n"), 0o644))
+	require.NoError(t, p.Run(ctx, input))
+
+	// The corrected export has one more sale that day and one on the next
+	corrected := []string{header, row("2024-04-01 10:00:00.000", "4974", "0x1"), row("2024-04-01 11:00:00.000", "4974", "0x2"), row("2024-04-02 10:00:00.000", "4974", "0x3")}
+	require.NoError(t, os.WriteFile(input, []byte(strings.Join(corrected, "\n")+"\n"), 0o644))
+	require.NoError(t, p.Run(ctx, input))
+
+	metrics, err := repo.FetchMetricsRange(ctx, apr1, apr2)
+	require.NoError(t, err)
+	require.Len(t, metrics, 3)
+	assert.Equal(t, []models.AggregatedData{
+		{Date: apr1, ProjectID: "1609", TransactionCount: 1, TotalVolumeUSD: 1},
+		{Date: apr1, ProjectID: "4974", TransactionCount: 2, TotalVolumeUSD: 2},
+		{Date: apr2, ProjectID: "4974", TransactionCount: 1, TotalVolumeUSD: 1},
+	}, metrics)
+}
@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/estensen/marketplace-pipeline/internal/telemetry"
 )
 
 // Predefined errors for better error handling.
@@ -132,10 +135,13 @@ func buildCoinGeckoURL(coinID string, date time.Time) string {
 
 // fetchResponse performs an HTTP GET request and returns the response.
 func fetchResponse(url string) (*http.Response, error) {
+	start := time.Now()
 	resp, err := http.Get(url)
 	if err != nil {
+		telemetry.ObserveCoinGeckoRequest(coinGeckoEndpoint(url), telemetry.StatusError, start)
 		return nil, fmt.Errorf("error fetching price from CoinGecko: %v", err)
 	}
+	telemetry.ObserveCoinGeckoRequest(coinGeckoEndpoint(url), strconv.Itoa(resp.StatusCode), start)
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		return nil, fmt.Errorf("received non-OK status code: %d", resp.StatusCode)
@@ -144,6 +150,14 @@ func fetchResponse(url string) (*http.Response, error) {
 	return resp, nil
 }
 
+// coinGeckoEndpoint returns the metrics label of a CoinGecko API URL, leaving out the coin ID and date.
+func coinGeckoEndpoint(url string) string {
+	if strings.Contains(url, "/history") {
+		return "coin_history"
+	}
+	return "coins_list"
+}
+
 // parsePriceFromResponse extracts the USD price from the CoinGecko API response.
 func parsePriceFromResponse(resp *http.Response) (float64, error) {
 	if resp.Body == nil {
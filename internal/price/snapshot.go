@@ -0,0 +1,137 @@
+package price
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/estensen/marketplace-pipeline/internal/archive"
+	"github.com/estensen/marketplace-pipeline/internal/storage"
+)
+
+// Errors returned by SnapshotProvider.
+var (
+	ErrMissingSnapshotPrice = errors.New("price missing from archived snapshot")
+	ErrNoFallback           = errors.New("no fallback price API configured")
+)
+
+// legacySnapshotName returns the flat object name price snapshots were archived under before the partitioned layout.
+func legacySnapshotName(date time.Time) string {
+	return fmt.Sprintf("prices-%s.csv", date.Format("2006-01-02"))
+}
+
+// SnapshotProvider implements the CoinAPI interface by reading price snapshots archived in object storage.
+// Coins missing from the archive are fetched from the fallback API when one is set.
+type SnapshotProvider struct {
+	Storage  storage.Storage
+	Fallback CoinAPI
+}
+
+// NewSnapshotProvider creates a new SnapshotProvider. fallback may be nil to serve archived prices only.
+func NewSnapshotProvider(storage storage.Storage, fallback CoinAPI) *SnapshotProvider {
+	return &SnapshotProvider{
+		Storage:  storage,
+		Fallback: fallback,
+	}
+}
+
+// FetchCoinsList delegates to the fallback API, since snapshots only hold prices.
+func (s *SnapshotProvider) FetchCoinsList() (map[string]string, error) {
+	if s.Fallback == nil {
+		return nil, ErrNoFallback
+	}
+	return s.Fallback.FetchCoinsList()
+}
+
+// GetHistoricalPrice returns the USD price of a cryptocurrency for a given date.
+func (s *SnapshotProvider) GetHistoricalPrice(coinID string, date time.Time) (float64, error) {
+	prices, err := s.GetHistoricalPrices([]string{coinID}, date)
+	if err != nil {
+		return 0, err
+	}
+	return prices[coinID], nil
+}
+
+// GetHistoricalPrices returns the USD prices of multiple cryptocurrencies for a given date,
+// preferring the archived snapshot over the fallback API.
+func (s *SnapshotProvider) GetHistoricalPrices(coinIDs []string, date time.Time) (map[string]float64, error) {
+	bySource, err := s.GetHistoricalPricesBySource(coinIDs, date)
+	if err != nil {
+		return nil, err
+	}
+
+	prices := make(map[string]float64)
+	for _, sourcePrices := range bySource {
+		for coinID, price := range sourcePrices {
+			prices[coinID] = price
+		}
+	}
+	return prices, nil
+}
+
+// GetHistoricalPricesBySource returns the USD prices of multiple cryptocurrencies for a given date, grouped by
+// whether they were read from the archived snapshot or fetched from the fallback API.
+func (s *SnapshotProvider) GetHistoricalPricesBySource(coinIDs []string, date time.Time) (map[string]map[string]float64, error) {
+	snapshot, err := s.ReadSnapshot(date)
+	if err != nil && !errors.Is(err, storage.ErrObjectNotFound) {
+		return nil, err
+	}
+
+	archived := make(map[string]float64)
+	var missing []string
+	for _, coinID := range coinIDs {
+		if price, ok := snapshot[coinID]; ok {
+			archived[coinID] = price
+		} else {
+			missing = append(missing, coinID)
+		}
+	}
+
+	bySource := map[string]map[string]float64{SourceSnapshot: archived}
+	if len(missing) == 0 {
+		return bySource, nil
+	}
+	if s.Fallback == nil {
+		return nil, fmt.Errorf("%w: %s on %s", ErrMissingSnapshotPrice, strings.Join(missing, ", "), date.Format("2006-01-02"))
+	}
+
+	fetched, err := HistoricalPricesBySource(s.Fallback, missing, date)
+	if err != nil {
+		return nil, err
+	}
+	for source, prices := range fetched {
+		if bySource[source] == nil {
+			bySource[source] = make(map[string]float64)
+		}
+		for coinID, price := range prices {
+			bySource[source][coinID] = price
+		}
+	}
+
+	return bySource, nil
+}
+
+// ReadSnapshot reads the price snapshot archived for date, falling back to the legacy flat layout.
+func (s *SnapshotProvider) ReadSnapshot(date time.Time) (map[string]float64, error) {
+	prices, err := archive.NewArchiver(s.Storage).ReadPrices(date)
+	if err == nil {
+		return prices, nil
+	}
+	if !errors.Is(err, storage.ErrObjectNotFound) {
+		return nil, fmt.Errorf("error reading price snapshot: %w", err)
+	}
+
+	legacy, err := s.Storage.Download(legacySnapshotName(date))
+	if err != nil {
+		return nil, fmt.Errorf("error downloading price snapshot: %w", err)
+	}
+	defer legacy.Close()
+
+	return archive.ParsePrices(legacy)
+}
+
+var (
+	_ CoinAPI        = (*SnapshotProvider)(nil)
+	_ SourcedCoinAPI = (*SnapshotProvider)(nil)
+)
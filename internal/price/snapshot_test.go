@@ -0,0 +1,179 @@
+package price
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/estensen/marketplace-pipeline/internal/archive"
+	"github.com/estensen/marketplace-pipeline/internal/storage"
+)
+
+type mockCoinAPI struct {
+	prices map[string]float64
+	calls  [][]string
+}
+
+func (m *mockCoinAPI) GetHistoricalPrice(coinID string, date time.Time) (float64, error) {
+	return m.prices[coinID], nil
+}
+
+func (m *mockCoinAPI) GetHistoricalPrices(coinIDs []string, date time.Time) (map[string]float64, error) {
+	m.calls = append(m.calls, coinIDs)
+	prices := make(map[string]float64)
+	for _, coinID := range coinIDs {
+		prices[coinID] = m.prices[coinID]
+	}
+	return prices, nil
+}
+
+func (m *mockCoinAPI) FetchCoinsList() (map[string]string, error) {
+	return map[string]string{"MATIC": "matic-network"}, nil
+}
+
+func TestSnapshotProviderGetHistoricalPrices(t *testing.T) {
+	date := time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)
+	snapshot := "token,average_price_usd\nmatic-network,0.85000000\nusd-coin,1.00000000\n"
+
+	tests := []struct {
+		name           string
+		snapshot       string
+		legacy         bool
+		coinIDs        []string
+		withFallback   bool
+		expectedPrices map[string]float64
+		expectedCalls  [][]string
+		expectedErr    error
+	}{
+		{
+			name:           "All prices archived",
+			snapshot:       snapshot,
+			coinIDs:        []string{"matic-network", "usd-coin"},
+			withFallback:   true,
+			expectedPrices: map[string]float64{"matic-network": 0.85, "usd-coin": 1},
+		},
+		{
+			name:           "Legacy flat snapshot",
+			snapshot:       snapshot,
+			legacy:         true,
+			coinIDs:        []string{"usd-coin"},
+			expectedPrices: map[string]float64{"usd-coin": 1},
+		},
+		{
+			name:           "Missing coin fetched from fallback",
+			snapshot:       snapshot,
+			coinIDs:        []string{"matic-network", "sunflower-land"},
+			withFallback:   true,
+			expectedPrices: map[string]float64{"matic-network": 0.85, "sunflower-land": 0.05},
+			expectedCalls:  [][]string{{"sunflower-land"}},
+		},
+		{
+			name:           "No snapshot for the date",
+			coinIDs:        []string{"matic-network"},
+			withFallback:   true,
+			expectedPrices: map[string]float64{"matic-network": 0.9},
+			expectedCalls:  [][]string{{"matic-network"}},
+		},
+		{
+			name:        "Missing coin without fallback",
+			snapshot:    snapshot,
+			coinIDs:     []string{"sunflower-land"},
+			expectedErr: ErrMissingSnapshotPrice,
+		},
+		{
+			name:         "Invalid snapshot",
+			snapshot:     "token,price\nmatic-network,0.85\n",
+			coinIDs:      []string{"matic-network"},
+			withFallback: true,
+			expectedErr:  archive.ErrInvalidPrices,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := storage.NewLocalFSStorage(t.TempDir())
+			require.NoError(t, err)
+			switch {
+			case tt.legacy:
+				require.NoError(t, s.UploadFile(legacySnapshotName(date), strings.NewReader(tt.snapshot)))
+			case tt.snapshot != "":
+				_, err := archive.NewArchiver(s).Write(archive.DatasetPrices, date, 0, strings.NewReader(tt.snapshot), 2)
+				require.NoError(t, err)
+			}
+
+			fallback := &mockCoinAPI{prices: map[string]float64{"matic-network": 0.9, "sunflower-land": 0.05}}
+			provider := NewSnapshotProvider(s, nil)
+			if tt.withFallback {
+				provider.Fallback = fallback
+			}
+
+			prices, err := provider.GetHistoricalPrices(tt.coinIDs, date)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedPrices, prices)
+			assert.Equal(t, tt.expectedCalls, fallback.calls)
+		})
+	}
+}
+
+func TestHistoricalPricesBySource(t *testing.T) {
+	date := time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)
+	s, err := storage.NewLocalFSStorage(t.TempDir())
+	require.NoError(t, err)
+	_, err = archive.NewArchiver(s).Write(archive.DatasetPrices, date, 0, strings.NewReader("token,average_price_usd\nmatic-network,0.85000000\n"), 1)
+	require.NoError(t, err)
+
+	coinGecko := &CoinGeckoAPI{fetchFunc: func(url string) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"market_data":{"current_price":{"usd":0.05}}}`)),
+		}, nil
+	}}
+
+	tests := []struct {
+		name     string
+		api      CoinAPI
+		expected map[string]map[string]float64
+	}{
+		{
+			name: "Snapshot with CoinGecko fallback",
+			api:  NewSnapshotProvider(s, coinGecko),
+			expected: map[string]map[string]float64{
+				SourceSnapshot:  {"matic-network": 0.85},
+				SourceCoinGecko: {"sunflower-land": 0.05},
+			},
+		},
+		{
+			name: "Fallback without a source",
+			api:  NewSnapshotProvider(s, &mockCoinAPI{prices: map[string]float64{"sunflower-land": 0.05}}),
+			expected: map[string]map[string]float64{
+				SourceSnapshot: {"matic-network": 0.85},
+				SourceUnknown:  {"sunflower-land": 0.05},
+			},
+		},
+		{
+			name: "CoinGecko",
+			api:  coinGecko,
+			expected: map[string]map[string]float64{
+				SourceCoinGecko: {"matic-network": 0.05, "sunflower-land": 0.05},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prices, err := HistoricalPricesBySource(tt.api, []string{"matic-network", "sunflower-land"}, date)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, prices)
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package price
+
+import "time"
+
+// Sources recorded with stored prices.
+const (
+	SourceCoinGecko = "coingecko"
+	SourceSnapshot  = "snapshot"
+	SourceUnknown   = "unknown"
+)
+
+// Sourcer is implemented by CoinAPIs that serve every price from a single source.
+type Sourcer interface {
+	Source() string
+}
+
+// SourcedCoinAPI is implemented by CoinAPIs that combine several sources.
+type SourcedCoinAPI interface {
+	// GetHistoricalPricesBySource returns the USD prices of coinIDs on date, grouped by source.
+	GetHistoricalPricesBySource(coinIDs []string, date time.Time) (map[string]map[string]float64, error)
+}
+
+// Source returns the CoinGecko source.
+func (c *CoinGeckoAPI) Source() string {
+	return SourceCoinGecko
+}
+
+// HistoricalPricesBySource fetches the USD prices of coinIDs on date from api, grouped by the source they came from.
+// Prices of CoinAPIs that don't report their source are attributed to SourceUnknown.
+func HistoricalPricesBySource(api CoinAPI, coinIDs []string, date time.Time) (map[string]map[string]float64, error) {
+	if sourced, ok := api.(SourcedCoinAPI); ok {
+		return sourced.GetHistoricalPricesBySource(coinIDs, date)
+	}
+
+	prices, err := api.GetHistoricalPrices(coinIDs, date)
+	if err != nil {
+		return nil, err
+	}
+	source := SourceUnknown
+	if sourcer, ok := api.(Sourcer); ok {
+		source = sourcer.Source()
+	}
+	return map[string]map[string]float64{source: prices}, nil
+}
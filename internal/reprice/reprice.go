@@ -0,0 +1,138 @@
+package reprice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/estensen/marketplace-pipeline/internal/aggregator"
+	"github.com/estensen/marketplace-pipeline/internal/database"
+	"github.com/estensen/marketplace-pipeline/internal/models"
+)
+
+// ErrInvalidRange is returned when the end of a reprice range is before its start.
+var ErrInvalidRange = errors.New("invalid reprice date range")
+
+// Repricer recomputes USD analytics from native volumes after token prices are corrected.
+type Repricer struct {
+	Aggregator *aggregator.Aggregator
+	Metrics    database.MetricsRepository
+	Prices     database.PriceRepository
+}
+
+// NewRepricer creates a new Repricer.
+func NewRepricer(agg *aggregator.Aggregator, metrics database.MetricsRepository, prices database.PriceRepository) *Repricer {
+	return &Repricer{
+		Aggregator: agg,
+		Metrics:    metrics,
+		Prices:     prices,
+	}
+}
+
+// Reprice recomputes the analytics rows with volume in the given token between from and to, inclusive.
+// The before and after values of every affected row are stored in the audit table and returned.
+func (r *Repricer) Reprice(ctx context.Context, token string, from, to time.Time) ([]models.RepriceAudit, error) {
+	if to.Before(from) {
+		return nil, fmt.Errorf("%w: %s is before %s", ErrInvalidRange, to.Format("2006-01-02"), from.Format("2006-01-02"))
+	}
+
+	volumes, err := r.Metrics.FetchNativeVolumes(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, affectedVolumes := affectedRows(volumes, token)
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	prices, err := r.Prices.FetchPriceHistory(ctx, from, to, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	previous, err := r.Metrics.FetchMetricsRange(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	repriced := r.Aggregator.PriceNativeVolumes(affectedVolumes, prices)
+	entries := buildAuditEntries(token, keys, previous, repriced, time.Now())
+
+	if err := r.Metrics.ReplaceAggregates(ctx, keys, repriced); err != nil {
+		return nil, err
+	}
+
+	if err := r.Metrics.StoreRepriceAudit(ctx, entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// affectedRows returns the days and projects with volume in token, along with all native volumes in those rows.
+func affectedRows(volumes []models.NativeVolume, token string) ([]models.AggregatedData, []models.NativeVolume) {
+	keySet := make(map[string]models.AggregatedData)
+	for _, v := range volumes {
+		if v.Token == token {
+			keySet[rowKey(v.Date, v.ProjectID)] = models.AggregatedData{Date: v.Date, ProjectID: v.ProjectID}
+		}
+	}
+
+	var affectedVolumes []models.NativeVolume
+	for _, v := range volumes {
+		if _, exists := keySet[rowKey(v.Date, v.ProjectID)]; exists {
+			affectedVolumes = append(affectedVolumes, v)
+		}
+	}
+
+	keys := make([]models.AggregatedData, 0, len(keySet))
+	for _, key := range keySet {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].Date.Equal(keys[j].Date) {
+			return keys[i].Date.Before(keys[j].Date)
+		}
+		return keys[i].ProjectID < keys[j].ProjectID
+	})
+
+	return keys, affectedVolumes
+}
+
+// buildAuditEntries pairs the previous and repriced values of each affected row.
+func buildAuditEntries(token string, keys, previous, repriced []models.AggregatedData, repricedAt time.Time) []models.RepriceAudit {
+	previousMap := make(map[string]models.AggregatedData)
+	for _, data := range previous {
+		previousMap[rowKey(data.Date, data.ProjectID)] = data
+	}
+
+	repricedMap := make(map[string]models.AggregatedData)
+	for _, data := range repriced {
+		repricedMap[rowKey(data.Date, data.ProjectID)] = data
+	}
+
+	entries := make([]models.RepriceAudit, 0, len(keys))
+	for _, key := range keys {
+		old := previousMap[rowKey(key.Date, key.ProjectID)]
+		updated := repricedMap[rowKey(key.Date, key.ProjectID)]
+		entries = append(entries, models.RepriceAudit{
+			RepricedAt:          repricedAt,
+			Token:               token,
+			Date:                key.Date,
+			ProjectID:           key.ProjectID,
+			OldTransactionCount: old.TransactionCount,
+			NewTransactionCount: updated.TransactionCount,
+			OldVolumeUSD:        old.TotalVolumeUSD,
+			NewVolumeUSD:        updated.TotalVolumeUSD,
+		})
+	}
+	return entries
+}
+
+// rowKey builds the map key of an analytics row.
+func rowKey(date time.Time, projectID string) string {
+	return date.Format("2006-01-02") + projectID
+}
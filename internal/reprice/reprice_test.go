@@ -0,0 +1,192 @@
+package reprice
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/estensen/marketplace-pipeline/internal/aggregator"
+	"github.com/estensen/marketplace-pipeline/internal/archive"
+	"github.com/estensen/marketplace-pipeline/internal/database"
+	"github.com/estensen/marketplace-pipeline/internal/models"
+	"github.com/estensen/marketplace-pipeline/internal/storage"
+)
+
+type mockCoinAPI struct {
+	prices  map[string]float64
+	fetched []string
+}
+
+func (m *mockCoinAPI) GetHistoricalPrice(coinID string, date time.Time) (float64, error) {
+	m.fetched = append(m.fetched, coinID)
+	return m.prices[coinID], nil
+}
+
+func (m *mockCoinAPI) GetHistoricalPrices(coinIDs []string, date time.Time) (map[string]float64, error) {
+	prices := make(map[string]float64)
+	for _, coinID := range coinIDs {
+		prices[coinID], _ = m.GetHistoricalPrice(coinID, date)
+	}
+	return prices, nil
+}
+
+func (m *mockCoinAPI) FetchCoinsList() (map[string]string, error) {
+	return nil, nil
+}
+
+func TestAffectedRows(t *testing.T) {
+	day := time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)
+	volumes := []models.NativeVolume{
+		{Date: day, ProjectID: "4974", Token: "matic-network"},
+		{Date: day, ProjectID: "4974", Token: "usd-coin"},
+		{Date: day, ProjectID: "1609", Token: "usd-coin"},
+		{Date: day.AddDate(0, 0, 1), ProjectID: "0", Token: "matic-network"},
+	}
+
+	keys, affectedVolumes := affectedRows(volumes, "matic-network")
+
+	require.Len(t, keys, 2)
+	assert.Equal(t, models.AggregatedData{Date: day, ProjectID: "4974"}, keys[0])
+	assert.Equal(t, models.AggregatedData{Date: day.AddDate(0, 0, 1), ProjectID: "0"}, keys[1])
+
+	// All currencies of an affected row are needed to recompute its USD volume
+	assert.ElementsMatch(t, []models.NativeVolume{volumes[0], volumes[1], volumes[3]}, affectedVolumes)
+}
+
+func TestBuildAuditEntries(t *testing.T) {
+	day := time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	keys := []models.AggregatedData{
+		{Date: day, ProjectID: "4974"},
+		{Date: day, ProjectID: "1609"},
+	}
+	previous := []models.AggregatedData{
+		{Date: day, ProjectID: "4974", TransactionCount: 3, TotalVolumeUSD: 10},
+		{Date: day, ProjectID: "1609", TransactionCount: 1, TotalVolumeUSD: 2},
+		{Date: day, ProjectID: "0", TransactionCount: 7, TotalVolumeUSD: 5},
+	}
+	repriced := []models.AggregatedData{
+		{Date: day, ProjectID: "4974", TransactionCount: 3, TotalVolumeUSD: 12},
+	}
+
+	entries := buildAuditEntries("matic-network", keys, previous, repriced, now)
+
+	expected := []models.RepriceAudit{
+		{
+			RepricedAt:          now,
+			Token:               "matic-network",
+			Date:                day,
+			ProjectID:           "4974",
+			OldTransactionCount: 3,
+			NewTransactionCount: 3,
+			OldVolumeUSD:        10,
+			NewVolumeUSD:        12,
+		},
+		{
+			RepricedAt:          now,
+			Token:               "matic-network",
+			Date:                day,
+			ProjectID:           "1609",
+			OldTransactionCount: 1,
+			NewTransactionCount: 0,
+			OldVolumeUSD:        2,
+			NewVolumeUSD:        0,
+		},
+	}
+	assert.Equal(t, expected, entries)
+}
+
+func TestReprice(t *testing.T) {
+	ctx := context.Background()
+	day := time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)
+	repo := database.NewMemoryRepository()
+
+	require.NoError(t, repo.LoadAggregates(ctx, []models.AggregatedData{
+		{Date: day, ProjectID: "4974", TransactionCount: 3, TotalVolumeUSD: 2.5},
+		{Date: day, ProjectID: "1609", TransactionCount: 1, TotalVolumeUSD: 1},
+	}))
+	require.NoError(t, repo.LoadNativeVolumes(ctx, []models.NativeVolume{
+		{Date: day, ProjectID: "4974", CurrencySymbol: "MATIC", Token: "matic-network", TransactionCount: 2, TotalVolumeNative: 1},
+		{Date: day, ProjectID: "4974", CurrencySymbol: "USDC", Token: "usd-coin", TransactionCount: 1, TotalVolumeNative: 2},
+		{Date: day, ProjectID: "1609", CurrencySymbol: "USDC", Token: "usd-coin", TransactionCount: 1, TotalVolumeNative: 1},
+	}))
+	require.NoError(t, repo.StorePrices(ctx, day, "coingecko", map[string]float64{"matic-network": 0.5, "usd-coin": 1}))
+
+	// Correct the MATIC price
+	require.NoError(t, repo.StorePrices(ctx, day, "coingecko", map[string]float64{"matic-network": 0.7}))
+
+	repricer := NewRepricer(aggregator.NewAggregator(), repo, repo)
+	entries, err := repricer.Reprice(ctx, "matic-network", day, day)
+	require.NoError(t, err)
+
+	require.Len(t, entries, 1)
+	assert.Equal(t, "4974", entries[0].ProjectID)
+	assert.InDelta(t, 2.5, entries[0].OldVolumeUSD, 0.0001)
+	assert.InDelta(t, 2.7, entries[0].NewVolumeUSD, 0.0001)
+	assert.Equal(t, entries, repo.RepriceAudit())
+
+	// Only the row containing MATIC volume changes
+	metrics, err := repo.FetchMetricsRange(ctx, day, day)
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+	assert.InDelta(t, 1.0, metrics[0].TotalVolumeUSD, 0.0001)
+	assert.InDelta(t, 2.7, metrics[1].TotalVolumeUSD, 0.0001)
+
+	_, err = repricer.Reprice(ctx, "matic-network", day, day.AddDate(0, 0, -1))
+	assert.ErrorIs(t, err, ErrInvalidRange)
+}
+
+func TestRepriceRefetchedPrices(t *testing.T) {
+	ctx := context.Background()
+	day := time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)
+	repo := database.NewMemoryRepository()
+	objectStorage, err := storage.NewLocalFSStorage(t.TempDir())
+	require.NoError(t, err)
+	archiver := archive.NewArchiver(objectStorage)
+
+	require.NoError(t, repo.LoadAggregates(ctx, []models.AggregatedData{
+		{Date: day, ProjectID: "4974", TransactionCount: 2, TotalVolumeUSD: 0.5},
+	}))
+	require.NoError(t, repo.LoadNativeVolumes(ctx, []models.NativeVolume{
+		{Date: day, ProjectID: "4974", CurrencySymbol: "MATIC", Token: "matic-network", TransactionCount: 2, TotalVolumeNative: 1},
+	}))
+
+	coinAPI := &mockCoinAPI{prices: map[string]float64{"matic-network": 0.5, "usd-coin": 1}}
+	require.NoError(t, database.NewBatchJob(coinAPI, repo, archiver).RunDailyBatchJob(ctx, []string{"matic-network"}, day))
+
+	// A token added later is fetched for an already priced day, without fetching the stored prices again
+	coinAPI.fetched = nil
+	coinAPI.prices["matic-network"] = 0.7
+	require.NoError(t, database.NewBatchJob(coinAPI, repo, archiver).RunDailyBatchJob(ctx, []string{"matic-network", "usd-coin"}, day))
+	assert.Equal(t, []string{"usd-coin"}, coinAPI.fetched)
+	prices, err := repo.FetchPrices(ctx, []string{"matic-network", "usd-coin"}, day)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"matic-network": 0.5, "usd-coin": 1}, prices)
+
+	// Overwriting stores the corrected price and archives it with the rest of the day
+	batchJob := database.NewBatchJob(coinAPI, repo, archiver)
+	batchJob.Overwrite = true
+	require.NoError(t, batchJob.RunDailyBatchJob(ctx, []string{"matic-network"}, day))
+	prices, err = repo.FetchPrices(ctx, []string{"matic-network", "usd-coin"}, day)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"matic-network": 0.7, "usd-coin": 1}, prices)
+	manifest, err := archiver.ReadManifest(archive.ObjectName(archive.DatasetPrices, day, 0, archive.FormatCSV))
+	require.NoError(t, err)
+	assert.Equal(t, 2, manifest.Rows)
+
+	// Repricing picks up the correction
+	entries, err := NewRepricer(aggregator.NewAggregator(), repo, repo).Reprice(ctx, "matic-network", day, day)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.InDelta(t, 0.5, entries[0].OldVolumeUSD, 0.0001)
+	assert.InDelta(t, 0.7, entries[0].NewVolumeUSD, 0.0001)
+
+	metrics, err := repo.FetchMetricsRange(ctx, day, day)
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+	assert.InDelta(t, 0.7, metrics[0].TotalVolumeUSD, 0.0001)
+}
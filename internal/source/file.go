@@ -0,0 +1,55 @@
+package source
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FileSource reads inputs from the local filesystem. URIs are either plain paths or file:// URIs.
+type FileSource struct{}
+
+// NewFileSource creates a new instance of FileSource.
+func NewFileSource() *FileSource {
+	return &FileSource{}
+}
+
+// Resolve expands glob patterns in the path of uri. A path without patterns must exist.
+func (f *FileSource) Resolve(uri string) ([]string, error) {
+	path := filePath(uri)
+	if !hasMeta(path) {
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("error resolving %s: %w", uri, err)
+		}
+		return []string{uri}, nil
+	}
+
+	matches, err := filepath.Glob(path)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving %s: %w", uri, err)
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// Open opens the file at the path of uri.
+func (f *FileSource) Open(uri string) (io.ReadCloser, error) {
+	file, err := os.Open(filePath(uri))
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %w", uri, err)
+	}
+	return file, nil
+}
+
+// filePath strips the file:// scheme from uri.
+func filePath(uri string) string {
+	return strings.TrimPrefix(uri, "file://")
+}
+
+// hasMeta reports whether path contains glob pattern characters.
+func hasMeta(path string) bool {
+	return strings.ContainsAny(path, `*?[`)
+}
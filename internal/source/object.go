@@ -0,0 +1,98 @@
+package source
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/estensen/marketplace-pipeline/internal/storage"
+)
+
+// ErrInvalidObjectURI is returned for object URIs without a bucket or key.
+var ErrInvalidObjectURI = errors.New("invalid object URI")
+
+// BucketFunc returns the storage holding the objects of a bucket.
+type BucketFunc func(bucket string) (storage.Storage, error)
+
+// ObjectSource reads inputs from an object store using s3://bucket/key URIs.
+type ObjectSource struct {
+	Scheme string
+	Bucket BucketFunc
+}
+
+// NewObjectSource creates a new ObjectSource for URIs with the given scheme.
+func NewObjectSource(scheme string, bucket BucketFunc) *ObjectSource {
+	return &ObjectSource{
+		Scheme: scheme,
+		Bucket: bucket,
+	}
+}
+
+// Resolve lists the objects under the literal prefix of the key pattern and keeps those matching it.
+func (o *ObjectSource) Resolve(uri string) ([]string, error) {
+	bucket, key, err := o.split(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := o.Bucket(bucket)
+	if err != nil {
+		return nil, fmt.Errorf("error opening bucket %s: %w", bucket, err)
+	}
+
+	if !hasMeta(key) {
+		if _, err := s.Stat(key); err != nil {
+			return nil, fmt.Errorf("error resolving %s: %w", uri, err)
+		}
+		return []string{uri}, nil
+	}
+
+	prefix := key[:strings.IndexAny(key, `*?[`)]
+	objects, err := s.List(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving %s: %w", uri, err)
+	}
+
+	var uris []string
+	for _, obj := range objects {
+		matched, err := path.Match(key, obj.Name)
+		if err != nil {
+			return nil, fmt.Errorf("error resolving %s: %w", uri, err)
+		}
+		if matched {
+			uris = append(uris, fmt.Sprintf("%s://%s/%s", o.Scheme, bucket, obj.Name))
+		}
+	}
+	return uris, nil
+}
+
+// Open downloads the object named by uri.
+func (o *ObjectSource) Open(uri string) (io.ReadCloser, error) {
+	bucket, key, err := o.split(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := o.Bucket(bucket)
+	if err != nil {
+		return nil, fmt.Errorf("error opening bucket %s: %w", bucket, err)
+	}
+
+	return s.Download(key)
+}
+
+// split returns the bucket and key of uri.
+func (o *ObjectSource) split(uri string) (string, string, error) {
+	rest, found := strings.CutPrefix(uri, o.Scheme+"://")
+	if !found {
+		return "", "", fmt.Errorf("%w: %s", ErrInvalidObjectURI, uri)
+	}
+
+	bucket, key, found := strings.Cut(rest, "/")
+	if !found || bucket == "" || bucket == "." || bucket == ".." || key == "" {
+		return "", "", fmt.Errorf("%w: %s", ErrInvalidObjectURI, uri)
+	}
+	return bucket, key, nil
+}
@@ -0,0 +1,177 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Predefined errors for resolving input URIs.
+var (
+	ErrUnsupportedScheme = errors.New("unsupported input scheme")
+	ErrNoInputs          = errors.New("no inputs match")
+)
+
+// StdinURI is the input URI that reads transactions from standard input.
+const StdinURI = "-"
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Source resolves input URIs to the inputs they name and opens them for reading.
+type Source interface {
+	// Resolve expands uri, which may contain glob patterns, to the URIs of the matching inputs in name order.
+	Resolve(uri string) ([]string, error)
+	// Open opens a single input returned by Resolve. The caller must close the returned reader.
+	Open(uri string) (io.ReadCloser, error)
+}
+
+// Mux dispatches input URIs to the Source registered for their scheme and
+// transparently decompresses gzip and zstd inputs.
+type Mux struct {
+	sources map[string]Source
+}
+
+// NewMux creates a new Mux reading file:// URIs, plain paths and stdin.
+func NewMux() *Mux {
+	m := &Mux{sources: make(map[string]Source)}
+	m.Register("file", NewFileSource())
+	m.Register("stdin", NewStdinSource())
+	return m
+}
+
+// Register sets the Source handling URIs with the given scheme, replacing any previous one.
+func (m *Mux) Register(scheme string, src Source) {
+	m.sources[scheme] = src
+}
+
+// Resolve expands uri using the Source registered for its scheme.
+func (m *Mux) Resolve(uri string) ([]string, error) {
+	src, err := m.source(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	uris, err := src.Resolve(uri)
+	if err != nil {
+		return nil, err
+	}
+	if len(uris) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoInputs, uri)
+	}
+	return uris, nil
+}
+
+// Open opens uri using the Source registered for its scheme, decompressing it if needed.
+func (m *Mux) Open(uri string) (io.ReadCloser, error) {
+	src, err := m.source(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := src.Open(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	decompressed, err := Decompress(rc)
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("error decompressing %s: %w", uri, err)
+	}
+	return decompressed, nil
+}
+
+// source returns the Source registered for the scheme of uri.
+func (m *Mux) source(uri string) (Source, error) {
+	scheme := Scheme(uri)
+	src, ok := m.sources[scheme]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedScheme, scheme)
+	}
+	return src, nil
+}
+
+// Scheme returns the scheme of uri. Plain paths are treated as files and "-" as stdin.
+func Scheme(uri string) string {
+	if uri == StdinURI {
+		return "stdin"
+	}
+	if scheme, _, found := strings.Cut(uri, "://"); found {
+		return scheme
+	}
+	return "file"
+}
+
+// Decompress wraps rc in a gzip or zstd decoder when its content starts with the matching magic number.
+// Uncompressed content is returned as is.
+func Decompress(rc io.ReadCloser) (io.ReadCloser, error) {
+	br := bufio.NewReader(rc)
+	header, err := br.Peek(len(zstdMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return &readCloser{Reader: gz, closers: []io.Closer{gz, rc}}, nil
+	case bytes.HasPrefix(header, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return &readCloser{Reader: zr, closers: []io.Closer{zr.IOReadCloser(), rc}}, nil
+	default:
+		return &readCloser{Reader: br, closers: []io.Closer{rc}}, nil
+	}
+}
+
+// readCloser reads from a decoder and closes it together with the underlying input.
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+// Close closes every closer in order, returning the first error.
+func (r *readCloser) Close() error {
+	var firstErr error
+	for _, c := range r.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// StdinSource reads a single input from standard input.
+type StdinSource struct {
+	Stdin io.Reader
+}
+
+// NewStdinSource creates a new StdinSource reading from os.Stdin.
+func NewStdinSource() *StdinSource {
+	return &StdinSource{Stdin: os.Stdin}
+}
+
+// Resolve returns "-" as the only input.
+func (s *StdinSource) Resolve(uri string) ([]string, error) {
+	return []string{StdinURI}, nil
+}
+
+// Open returns standard input. Closing the returned reader leaves stdin open.
+func (s *StdinSource) Open(uri string) (io.ReadCloser, error) {
+	return io.NopCloser(s.Stdin), nil
+}
@@ -0,0 +1,208 @@
+package source
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/estensen/marketplace-pipeline/internal/storage"
+)
+
+func gzipBytes(t *testing.T, data string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, data string) []byte {
+	var buf bytes.Buffer
+	w, err := zstd.NewWriter(&buf)
+	require.NoError(t, err)
+	_, err = w.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestScheme(t *testing.T) {
+	tests := []struct {
+		uri      string
+		expected string
+	}{
+		{uri: "data/sample.csv", expected: "file"},
+		{uri: "file:///data/sample.csv", expected: "file"},
+		{uri: "s3://exports/2024/*.csv", expected: "s3"},
+		{uri: "-", expected: "stdin"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			assert.Equal(t, tt.expected, Scheme(tt.uri))
+		})
+	}
+}
+
+func TestMuxFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"2024-04-01.csv":     []byte("plain\n"),
+		"2024-04-02.csv.gz":  gzipBytes(t, "gzip\n"),
+		"2024-04-03.csv.zst": zstdBytes(t, "zstd\n"),
+		"notes.txt":          []byte("ignored\n"),
+	}
+	for name, data := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0o644))
+	}
+
+	tests := []struct {
+		name        string
+		uri         string
+		expected    []string
+		expectedErr error
+	}{
+		{
+			name:     "Plain path",
+			uri:      filepath.Join(dir, "2024-04-01.csv"),
+			expected: []string{"plain\n"},
+		},
+		{
+			name:     "File URI",
+			uri:      "file://" + filepath.Join(dir, "2024-04-02.csv.gz"),
+			expected: []string{"gzip\n"},
+		},
+		{
+			name:     "Glob across compressed files",
+			uri:      filepath.Join(dir, "2024-04-*.csv*"),
+			expected: []string{"plain\n", "gzip\n", "zstd\n"},
+		},
+		{
+			name:        "No matches",
+			uri:         filepath.Join(dir, "2023-*.csv"),
+			expectedErr: ErrNoInputs,
+		},
+		{
+			name:        "Unsupported scheme",
+			uri:         "gs://exports/2024-04-01.csv",
+			expectedErr: ErrUnsupportedScheme,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := NewMux()
+			uris, err := mux.Resolve(tt.uri)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+
+			var contents []string
+			for _, uri := range uris {
+				reader, err := mux.Open(uri)
+				require.NoError(t, err)
+				data, err := io.ReadAll(reader)
+				require.NoError(t, err)
+				require.NoError(t, reader.Close())
+				contents = append(contents, string(data))
+			}
+			assert.Equal(t, tt.expected, contents)
+		})
+	}
+}
+
+func TestMuxStdin(t *testing.T) {
+	mux := NewMux()
+	mux.Register("stdin", &StdinSource{Stdin: bytes.NewReader(gzipBytes(t, "from stdin\n"))})
+
+	uris, err := mux.Resolve(StdinURI)
+	require.NoError(t, err)
+	require.Equal(t, []string{StdinURI}, uris)
+
+	reader, err := mux.Open(StdinURI)
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "from stdin\n", string(data))
+}
+
+func TestObjectSource(t *testing.T) {
+	root := t.TempDir()
+	exports, err := storage.NewLocalFSStorage(filepath.Join(root, "exports"))
+	require.NoError(t, err)
+	for _, name := range []string{"2024/04/01.csv", "2024/04/02.csv", "2024/05/01.csv"} {
+		require.NoError(t, exports.UploadFile(name, strings.NewReader(name)))
+	}
+
+	src := NewObjectSource("s3", func(bucket string) (storage.Storage, error) {
+		return storage.NewLocalFSStorage(filepath.Join(root, bucket))
+	})
+
+	tests := []struct {
+		name        string
+		uri         string
+		expected    []string
+		expectedErr error
+	}{
+		{
+			name:     "Single object",
+			uri:      "s3://exports/2024/05/01.csv",
+			expected: []string{"s3://exports/2024/05/01.csv"},
+		},
+		{
+			name:     "Glob within a prefix",
+			uri:      "s3://exports/2024/04/*.csv",
+			expected: []string{"s3://exports/2024/04/01.csv", "s3://exports/2024/04/02.csv"},
+		},
+		{
+			name:     "Glob across prefixes",
+			uri:      "s3://exports/2024/*/01.csv",
+			expected: []string{"s3://exports/2024/04/01.csv", "s3://exports/2024/05/01.csv"},
+		},
+		{
+			name:        "Missing object",
+			uri:         "s3://exports/2024/06/01.csv",
+			expectedErr: storage.ErrObjectNotFound,
+		},
+		{
+			name:        "Missing key",
+			uri:         "s3://exports",
+			expectedErr: ErrInvalidObjectURI,
+		},
+		{
+			name:        "Bucket escaping the root",
+			uri:         "s3://../secrets.csv",
+			expectedErr: ErrInvalidObjectURI,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uris, err := src.Resolve(tt.uri)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, uris)
+
+			reader, err := src.Open(uris[0])
+			require.NoError(t, err)
+			defer reader.Close()
+			data, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, strings.TrimPrefix(uris[0], "s3://exports/"), string(data))
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// tempPrefix marks files that are still being written by UploadFile.
+const tempPrefix = ".upload-"
+
+// LocalFSStorage stores objects as files under a root directory, using the object name as the relative path.
+type LocalFSStorage struct {
+	Root string
+}
+
+// NewLocalFSStorage initializes and returns a new LocalFSStorage, creating the root directory if needed.
+func NewLocalFSStorage(root string) (*LocalFSStorage, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create storage root '%s': %w", root, err)
+	}
+	log.Printf("Initialized local storage at '%s'.\n", root)
+	return &LocalFSStorage{Root: root}, nil
+}
+
+// UploadFile writes the object to disk. The file is written to a temporary name first,
+// so readers never see a partially written object.
+func (l *LocalFSStorage) UploadFile(objectName string, data io.Reader) error {
+	path, err := l.objectPath(objectName)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory for '%s': %w", objectName, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), tempPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for '%s': %w", objectName, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write file '%s': %w", objectName, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write file '%s': %w", objectName, err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to store file '%s': %w", objectName, err)
+	}
+
+	log.Printf("File '%s' stored successfully in '%s'.\n", objectName, l.Root)
+	return nil
+}
+
+// Download opens the object for reading. The caller must close the returned reader.
+func (l *LocalFSStorage) Download(objectName string) (io.ReadCloser, error) {
+	path, err := l.objectPath(objectName)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file '%s': %w", objectName, mapFSError(err))
+	}
+	return f, nil
+}
+
+// List returns every object whose name starts with prefix, sorted by name.
+func (l *LocalFSStorage) List(prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := filepath.WalkDir(l.Root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), tempPrefix) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(l.Root, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{
+			Name:         name,
+			Size:         info.Size(),
+			LastModified: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects with prefix '%s': %w", prefix, err)
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Name < objects[j].Name
+	})
+	return objects, nil
+}
+
+// Ping checks that the root directory exists.
+func (l *LocalFSStorage) Ping(ctx context.Context) error {
+	info, err := os.Stat(l.Root)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && !info.IsDir()) {
+		return fmt.Errorf("%w: %s", ErrBucketNotFound, l.Root)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat storage root '%s': %w", l.Root, err)
+	}
+	return nil
+}
+
+// Stat returns the metadata of an object.
+func (l *LocalFSStorage) Stat(objectName string) (ObjectInfo, error) {
+	path, err := l.objectPath(objectName)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to stat file '%s': %w", objectName, mapFSError(err))
+	}
+	if info.IsDir() {
+		return ObjectInfo{}, fmt.Errorf("failed to stat file '%s': %w", objectName, ErrObjectNotFound)
+	}
+
+	return ObjectInfo{
+		Name:         objectName,
+		Size:         info.Size(),
+		LastModified: info.ModTime(),
+	}, nil
+}
+
+// Delete removes an object from disk. Deleting a missing object is not an error.
+func (l *LocalFSStorage) Delete(objectName string) error {
+	path, err := l.objectPath(objectName)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete file '%s': %w", objectName, err)
+	}
+	log.Printf("File '%s' deleted from '%s'.\n", objectName, l.Root)
+	return nil
+}
+
+// mapFSError converts missing file errors to ErrObjectNotFound.
+func mapFSError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %v", ErrObjectNotFound, err)
+	}
+	return err
+}
+
+// objectPath maps an object name to a path under the root, rejecting names that escape it.
+func (l *LocalFSStorage) objectPath(objectName string) (string, error) {
+	cleaned := filepath.Clean(filepath.FromSlash(objectName))
+	if cleaned == "." || filepath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid object name '%s'", objectName)
+	}
+	return filepath.Join(l.Root, cleaned), nil
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalFSStorageUploadFile(t *testing.T) {
+	tests := []struct {
+		name         string
+		objectName   string
+		expectedPath string
+		expectedErr  bool
+	}{
+		{
+			name:         "Flat object",
+			objectName:   "prices-2024-04-02.csv",
+			expectedPath: "prices-2024-04-02.csv",
+		},
+		{
+			name:         "Nested object",
+			objectName:   "prices/date=2024-04-02/part-0.csv",
+			expectedPath: filepath.Join("prices", "date=2024-04-02", "part-0.csv"),
+		},
+		{
+			name:        "Escaping the root",
+			objectName:  "../prices.csv",
+			expectedErr: true,
+		},
+		{
+			name:        "Absolute path",
+			objectName:  "/etc/prices.csv",
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			s, err := NewLocalFSStorage(root)
+			require.NoError(t, err)
+
+			err = s.UploadFile(tt.objectName, strings.NewReader("token,average_price_usd\n"))
+			if tt.expectedErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			data, err := os.ReadFile(filepath.Join(root, tt.expectedPath))
+			require.NoError(t, err)
+			assert.Equal(t, "token,average_price_usd\n", string(data))
+		})
+	}
+}
+
+func TestLocalFSStorageList(t *testing.T) {
+	s, err := NewLocalFSStorage(t.TempDir())
+	require.NoError(t, err)
+
+	for _, name := range []string{"prices-2024-04-02.csv", "prices-2024-04-01.csv", "raw/2024-04-01.csv"} {
+		require.NoError(t, s.UploadFile(name, strings.NewReader(name)))
+	}
+
+	tests := []struct {
+		name     string
+		prefix   string
+		expected []string
+	}{
+		{
+			name:     "All objects",
+			prefix:   "",
+			expected: []string{"prices-2024-04-01.csv", "prices-2024-04-02.csv", "raw/2024-04-01.csv"},
+		},
+		{
+			name:     "Prices only",
+			prefix:   "prices-",
+			expected: []string{"prices-2024-04-01.csv", "prices-2024-04-02.csv"},
+		},
+		{
+			name:     "Nested prefix",
+			prefix:   "raw/",
+			expected: []string{"raw/2024-04-01.csv"},
+		},
+		{
+			name:     "No match",
+			prefix:   "analytics/",
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects, err := s.List(tt.prefix)
+			require.NoError(t, err)
+
+			var names []string
+			for _, obj := range objects {
+				names = append(names, obj.Name)
+			}
+			assert.Equal(t, tt.expected, names)
+		})
+	}
+}
+
+func TestLocalFSStorageObjectLifecycle(t *testing.T) {
+	s, err := NewLocalFSStorage(t.TempDir())
+	require.NoError(t, err)
+
+	objectName := "prices/prices-2024-04-02.csv"
+	content := "token,average_price_usd\nmatic-network,0.85000000\n"
+	require.NoError(t, s.UploadFile(objectName, strings.NewReader(content)))
+
+	info, err := s.Stat(objectName)
+	require.NoError(t, err)
+	assert.Equal(t, objectName, info.Name)
+	assert.Equal(t, int64(len(content)), info.Size)
+
+	reader, err := s.Download(objectName)
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	assert.Equal(t, content, string(data))
+
+	require.NoError(t, s.Delete(objectName))
+	require.NoError(t, s.Delete(objectName), "deleting a missing object should succeed")
+
+	_, err = s.Stat(objectName)
+	assert.ErrorIs(t, err, ErrObjectNotFound)
+	_, err = s.Download(objectName)
+	assert.ErrorIs(t, err, ErrObjectNotFound)
+	_, err = s.Stat("prices")
+	assert.ErrorIs(t, err, ErrObjectNotFound)
+}
+
+func TestLocalFSStoragePing(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "objects")
+	s, err := NewLocalFSStorage(root)
+	require.NoError(t, err)
+	assert.NoError(t, s.Ping(context.Background()))
+
+	require.NoError(t, os.RemoveAll(root))
+	assert.ErrorIs(t, s.Ping(context.Background()), ErrBucketNotFound)
+}
@@ -5,16 +5,13 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net/http"
+	"path"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 )
 
-// Storage is an interface for uploading files.
-type Storage interface {
-	UploadFile(objectName string, reader io.Reader) error
-}
-
 type MinIOStorage struct {
 	Client     *minio.Client
 	BucketName string
@@ -69,11 +66,19 @@ func NewMinIOStorage(endpoint, accessKey, secretKey, bucketName string, useSSL b
 	}, nil
 }
 
+// WithBucket returns a MinIOStorage that shares the client but reads and writes bucketName.
+func (m *MinIOStorage) WithBucket(bucketName string) *MinIOStorage {
+	return &MinIOStorage{
+		Client:     m.Client,
+		BucketName: bucketName,
+	}
+}
+
 // UploadFile uploads a file to the specified MinIO bucket.
 func (m *MinIOStorage) UploadFile(objectName string, data io.Reader) error {
 	log.Printf("Uploading file '%s' to bucket '%s'\n", objectName, m.BucketName)
 	_, err := m.Client.PutObject(context.Background(), m.BucketName, objectName, data, -1, minio.PutObjectOptions{
-		ContentType: "application/csv",
+		ContentType: contentType(objectName),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to upload file '%s' to MinIO: %w", objectName, err)
@@ -81,3 +86,90 @@ func (m *MinIOStorage) UploadFile(objectName string, data io.Reader) error {
 	log.Printf("File '%s' uploaded successfully to bucket '%s'.\n", objectName, m.BucketName)
 	return nil
 }
+
+// Download opens the object for reading. The caller must close the returned reader.
+func (m *MinIOStorage) Download(objectName string) (io.ReadCloser, error) {
+	obj, err := m.Client.GetObject(context.Background(), m.BucketName, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file '%s' from MinIO: %w", objectName, mapMinIOError(err))
+	}
+
+	// GetObject is lazy, so stat the object to surface a missing key before the first read
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, fmt.Errorf("failed to download file '%s' from MinIO: %w", objectName, mapMinIOError(err))
+	}
+
+	return obj, nil
+}
+
+// List returns every object in the bucket whose name starts with prefix, sorted by name.
+func (m *MinIOStorage) List(prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	for obj := range m.Client.ListObjects(context.Background(), m.BucketName, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("failed to list objects with prefix '%s' in MinIO: %w", prefix, obj.Err)
+		}
+		objects = append(objects, ObjectInfo{
+			Name:         obj.Key,
+			Size:         obj.Size,
+			LastModified: obj.LastModified,
+		})
+	}
+	return objects, nil
+}
+
+// Ping checks that the bucket is reachable with the configured credentials.
+func (m *MinIOStorage) Ping(ctx context.Context) error {
+	exists, err := m.Client.BucketExists(ctx, m.BucketName)
+	if err != nil {
+		return fmt.Errorf("error checking bucket '%s': %w", m.BucketName, err)
+	}
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrBucketNotFound, m.BucketName)
+	}
+	return nil
+}
+
+// Stat returns the metadata of an object.
+func (m *MinIOStorage) Stat(objectName string) (ObjectInfo, error) {
+	info, err := m.Client.StatObject(context.Background(), m.BucketName, objectName, minio.StatObjectOptions{})
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to stat file '%s' in MinIO: %w", objectName, mapMinIOError(err))
+	}
+	return ObjectInfo{
+		Name:         info.Key,
+		Size:         info.Size,
+		LastModified: info.LastModified,
+	}, nil
+}
+
+// Delete removes an object from the bucket. Deleting a missing object is not an error.
+func (m *MinIOStorage) Delete(objectName string) error {
+	if err := m.Client.RemoveObject(context.Background(), m.BucketName, objectName, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to delete file '%s' from MinIO: %w", objectName, err)
+	}
+	log.Printf("File '%s' deleted from bucket '%s'.\n", objectName, m.BucketName)
+	return nil
+}
+
+// contentType returns the MIME type of an object based on its extension.
+func contentType(objectName string) string {
+	switch path.Ext(objectName) {
+	case ".gz":
+		return "application/gzip"
+	case ".json":
+		return "application/json"
+	default:
+		return "application/csv"
+	}
+}
+
+// mapMinIOError converts missing key responses to ErrObjectNotFound.
+func mapMinIOError(err error) error {
+	resp := minio.ToErrorResponse(err)
+	if resp.Code == "NoSuchKey" || resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %v", ErrObjectNotFound, err)
+	}
+	return err
+}
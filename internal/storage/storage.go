@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	// ErrObjectNotFound is returned when an object does not exist in the storage backend.
+	ErrObjectNotFound = errors.New("object not found")
+	// ErrBucketNotFound is returned by Ping when the bucket or root directory does not exist.
+	ErrBucketNotFound = errors.New("bucket not found")
+)
+
+// Storage is an interface for storing and retrieving objects.
+type Storage interface {
+	UploadFile(objectName string, reader io.Reader) error
+	Download(objectName string) (io.ReadCloser, error)
+	List(prefix string) ([]ObjectInfo, error)
+	Stat(objectName string) (ObjectInfo, error)
+	Delete(objectName string) error
+	Ping(ctx context.Context) error
+}
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Name         string
+	Size         int64
+	LastModified time.Time
+}
+
+var (
+	_ Storage = (*MinIOStorage)(nil)
+	_ Storage = (*LocalFSStorage)(nil)
+)
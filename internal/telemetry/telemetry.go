@@ -0,0 +1,151 @@
+package telemetry
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Path is where the Prometheus metrics are served.
+const Path = "/internal/prometheus"
+
+// Row stages counted by RecordRun.
+const (
+	RowsParsed     = "parsed"
+	RowsRejected   = "rejected"
+	RowsAggregated = "aggregated"
+)
+
+// StatusError labels CoinGecko requests that failed before a response was received.
+const StatusError = "error"
+
+// Registry holds every metric of the process, including the Go runtime and process collectors.
+var Registry = prometheus.NewRegistry()
+
+var (
+	pipelineRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pipeline_runs_total",
+		Help: "Pipeline runs by result.",
+	}, []string{"result"})
+	pipelineRows = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pipeline_rows_total",
+		Help: "Transaction rows handled by the pipeline across runs, by stage.",
+	}, []string{"stage"})
+	pipelineLastRunRows = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pipeline_last_run_rows",
+		Help: "Transaction rows handled by the last pipeline run, by stage.",
+	}, []string{"stage"})
+	pipelineLastRunTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pipeline_last_run_timestamp_seconds",
+		Help: "Unix time the last pipeline run finished.",
+	})
+	pipelineStageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pipeline_stage_duration_seconds",
+		Help:    "Duration of the pipeline stages.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 4, 10),
+	}, []string{"stage"})
+	coinGeckoRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "coingecko_request_duration_seconds",
+		Help:    "Latency of CoinGecko API requests, by endpoint and HTTP status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"endpoint", "status"})
+	clickHouseBatchRows = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "clickhouse_batch_rows",
+		Help:    "Rows per ClickHouse insert batch, by table.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+	}, []string{"table"})
+	clickHouseInsertDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "clickhouse_insert_duration_seconds",
+		Help:    "Duration of ClickHouse batch inserts, by table.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"table"})
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Latency of API requests, by route pattern, method and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		pipelineRuns,
+		pipelineRows,
+		pipelineLastRunRows,
+		pipelineLastRunTimestamp,
+		pipelineStageDuration,
+		coinGeckoRequestDuration,
+		clickHouseBatchRows,
+		clickHouseInsertDuration,
+		httpRequestDuration,
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// ObserveStage records the duration of a pipeline stage started at start.
+func ObserveStage(stage string, start time.Time) {
+	pipelineStageDuration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+}
+
+// RecordRun records the outcome of a pipeline run along with the rows it parsed, rejected and aggregated.
+func RecordRun(result string, parsed, rejected, aggregated int) {
+	pipelineRuns.WithLabelValues(result).Inc()
+	pipelineLastRunTimestamp.SetToCurrentTime()
+	for stage, rows := range map[string]int{RowsParsed: parsed, RowsRejected: rejected, RowsAggregated: aggregated} {
+		pipelineRows.WithLabelValues(stage).Add(float64(rows))
+		pipelineLastRunRows.WithLabelValues(stage).Set(float64(rows))
+	}
+}
+
+// ObserveCoinGeckoRequest records a CoinGecko request started at start. Pass StatusError when no response was received.
+func ObserveCoinGeckoRequest(endpoint, status string, start time.Time) {
+	coinGeckoRequestDuration.WithLabelValues(endpoint, status).Observe(time.Since(start).Seconds())
+}
+
+// ObserveClickHouseBatch records a ClickHouse batch insert of rows into table started at start.
+func ObserveClickHouseBatch(table string, rows int, start time.Time) {
+	clickHouseBatchRows.WithLabelValues(table).Observe(float64(rows))
+	clickHouseInsertDuration.WithLabelValues(table).Observe(time.Since(start).Seconds())
+}
+
+// Instrument records the latency of every request served by next, labelled by the matched route pattern.
+// Requests matching no pattern are labelled "unmatched" to bound the label cardinality.
+func Instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequestDuration.WithLabelValues(route, r.Method, strconv.Itoa(recorder.status)).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status code before writing it.
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
@@ -0,0 +1,94 @@
+package telemetry
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstrument(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler := Instrument(mux)
+
+	tests := []struct {
+		name   string
+		target string
+		route  string
+		status string
+	}{
+		{name: "Labels the route pattern", target: "/items/42", route: "/items/{id}", status: "418"},
+		{name: "Labels unmatched paths", target: "/unknown", route: "unmatched", status: "404"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tc.target, nil))
+
+			assert.Equal(t, 1, histogramCount(t, tc.route, tc.status))
+		})
+	}
+}
+
+func TestRecordRun(t *testing.T) {
+	RecordRun("success", 10, 2, 8)
+	RecordRun("success", 5, 0, 5)
+
+	assert.Equal(t, float64(15), testutil.ToFloat64(pipelineRows.WithLabelValues(RowsParsed)))
+	assert.Equal(t, float64(2), testutil.ToFloat64(pipelineRows.WithLabelValues(RowsRejected)))
+	assert.Equal(t, float64(5), testutil.ToFloat64(pipelineLastRunRows.WithLabelValues(RowsParsed)))
+	assert.Equal(t, float64(0), testutil.ToFloat64(pipelineLastRunRows.WithLabelValues(RowsRejected)))
+	assert.Equal(t, float64(2), testutil.ToFloat64(pipelineRuns.WithLabelValues("success")))
+}
+
+func TestHandler(t *testing.T) {
+	ObserveStage("parse", time.Now())
+	ObserveCoinGeckoRequest("coins_list", "200", time.Now())
+	ObserveClickHouseBatch("marketplace_analytics", 3, time.Now())
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, Path, nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	for _, name := range []string{
+		`pipeline_stage_duration_seconds_count{stage="parse"} 1`,
+		`coingecko_request_duration_seconds_count{endpoint="coins_list",status="200"} 1`,
+		`clickhouse_batch_rows_sum{table="marketplace_analytics"} 3`,
+		`clickhouse_insert_duration_seconds_count{table="marketplace_analytics"} 1`,
+		"go_goroutines",
+	} {
+		assert.Contains(t, string(body), name)
+	}
+}
+
+// histogramCount returns the number of observations of the HTTP histogram with the given GET labels.
+func histogramCount(t *testing.T, route, status string) int {
+	t.Helper()
+	families, err := Registry.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != "http_request_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["route"] == route && labels["status"] == status && labels["method"] == http.MethodGet {
+				return int(metric.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	return 0
+}
@@ -39,7 +39,13 @@ func DisplayMetrics(metrics []models.AggregatedData) {
 		return
 	}
 
-	fmt.Printf("Marketplace Analytics for %s:\n", metrics[0].Date.Format("2006-01-02"))
+	// Metrics are ordered by date, so the first and last rows span the displayed days
+	first, last := metrics[0].Date.Format("2006-01-02"), metrics[len(metrics)-1].Date.Format("2006-01-02")
+	if first == last {
+		fmt.Printf("Marketplace Analytics for %s:\n", first)
+	} else {
+		fmt.Printf("Marketplace Analytics from %s to %s:\n", first, last)
+	}
 	t := table.NewWriter()
 	t.SetOutputMirror(os.Stdout)
 	t.AppendHeader(table.Row{"Date", "Project ID", "Transaction Count", "Total Volume USD"})
@@ -52,34 +52,6 @@ wait_for_clickhouse() {
     echo "ClickHouse server is ready."
 }
 
-# Function to create or update tables
-create_tables() {
-    echo "Creating or updating ClickHouse tables..."
-
-    # Create or replace 'marketplace_analytics' table
-    docker exec -i ${CONTAINER_NAME} clickhouse-client --query="
-    CREATE TABLE IF NOT EXISTS marketplace_analytics (
-        date Date,
-        project_id String,
-        transaction_count UInt64,
-        total_volume_usd Float64
-    ) ENGINE = MergeTree()
-    ORDER BY (date, project_id);
-    "
-
-    # Create or replace 'token_prices' table
-    docker exec -i ${CONTAINER_NAME} clickhouse-client --query="
-    CREATE TABLE IF NOT EXISTS token_prices (
-        token String,
-        date Date,
-        average_price_usd Float64
-    ) ENGINE = MergeTree()
-    ORDER BY (token, date);
-    "
-
-    echo "Tables 'marketplace_analytics' and 'token_prices' have been created or verified."
-}
-
 # Main Script Execution
 
 # Step 1: Delete existing container if it exists
@@ -91,10 +63,8 @@ start_container
 # Step 3: Verify if ClickHouse is running
 if check_clickhouse_running; then
     # Step 4: Wait for ClickHouse to be fully ready
+    # Tables are created by the pipeline's migrations on startup
     wait_for_clickhouse
-
-    # Step 5: Create or update the necessary tables
-    create_tables
 else
     echo "Exiting script due to ClickHouse startup failure."
     exit 1